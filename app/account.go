@@ -3,30 +3,47 @@ package main
 import (
 	"context"
 	"errors"
-	"net/http"
 	"time"
 
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/urlfetch"
-
 	"github.com/slack-go/slack"
 )
 
 type Account struct {
-	SlackUserId        string         `datastore:",noindex"`
-	SlackTeamName      string         `datastore:",noindex"`
-	SlackTeamUrl       string         `datastore:",noindex"`
-	ApiToken           string         `datastore:",noindex"`
-	TimezoneName       string         `datastore:",noindex"`
-	TimezoneLocation   *time.Location `datastore:"-,"`
-	DigestEmailAddress string         `datastore:",noindex"`
-	DirectMessagesOnly bool           `datastore:",noindex"`
+	SlackUserId          string                   `datastore:",noindex"`
+	SlackTeamName        string                   `datastore:",noindex"`
+	SlackTeamUrl         string                   `datastore:",noindex"`
+	ApiToken             string                   `datastore:",noindex"`
+	TimezoneName         string                   `datastore:",noindex"`
+	TimezoneLocation     *time.Location           `datastore:"-," json:"-"`
+	DigestEmailAddress   string                   `datastore:",noindex"`
+	DirectMessagesOnly   bool                     `datastore:",noindex"`
+	CombinedDigest       bool                     `datastore:",noindex"`
+	ConversationFilters  ConversationFilterConfig `datastore:",noindex"`
+	EmbedImages          bool                     `datastore:",noindex"`
+	FileAttachments      FileAttachmentConfig     `datastore:",noindex"`
+	Backfill             BackfillState            `datastore:",noindex"`
+	Cadence              string                   `datastore:",noindex"`
+	CadenceWeekday       time.Weekday             `datastore:",noindex"`
+	ConversationCadences []ConversationCadence    `datastore:",noindex"`
+	DeliveryMode         string                   `datastore:",noindex"`
+	Imap                 ImapConfig               `datastore:",noindex"`
+	// When the scheduled run last completed, and when each conversation was
+	// last archived.
+	LastArchivedEndTime         time.Time                   `datastore:",noindex"`
+	ConversationArchiveStatuses []ConversationArchiveStatus `datastore:",noindex"`
 }
 
+// Base URL (with a trailing slash) of the Slack Web API that clients use, if
+// not the real one (e.g. a fake server in tests).
+var slackApiUrl string
+
+const (
+	DeliveryModeEmail = "email"
+	DeliveryModeImap  = "imap"
+)
+
 func getAccount(c context.Context, slackUserId string) (*Account, error) {
-	key := datastore.NewKey(c, "Account", slackUserId, 0, nil)
-	account := new(Account)
-	err := datastore.Get(c, key, account)
+	account, err := accountStore.Get(c, slackUserId)
 	if err != nil {
 		return nil, err
 	}
@@ -51,9 +68,7 @@ func initAccount(account *Account) error {
 }
 
 func getAllAccounts(c context.Context) ([]Account, error) {
-	q := datastore.NewQuery("Account")
-	var accounts []Account
-	_, err := q.GetAll(c, &accounts)
+	accounts, err := accountStore.GetAll(c)
 	if err != nil {
 		return nil, err
 	}
@@ -67,15 +82,17 @@ func getAllAccounts(c context.Context) ([]Account, error) {
 }
 
 func (account *Account) Put(c context.Context) error {
-	key := datastore.NewKey(c, "Account", account.SlackUserId, 0, nil)
-	_, err := datastore.Put(c, key, account)
-	return err
+	return accountStore.Put(c, account)
 }
 
 func (account *Account) Delete(c context.Context) error {
-	key := datastore.NewKey(c, "Account", account.SlackUserId, 0, nil)
-	err := datastore.Delete(c, key)
-	return err
+	if err := archiveLog.DeleteAll(c, account.SlackUserId); err != nil {
+		return err
+	}
+	if err := messageStore.DeleteAll(c, account.SlackUserId); err != nil {
+		return err
+	}
+	return accountStore.Delete(c, account.SlackUserId)
 }
 
 func (account *Account) GetDigestEmailAddress(slackClient *slack.Client) (string, error) {
@@ -92,15 +109,66 @@ func (account *Account) GetDigestEmailAddress(slackClient *slack.Client) (string
 	return "", errors.New("No email addresses found in Slack profile")
 }
 
+// Archives are either emailed (the default) or appended to an IMAP mailbox that
+// the user has configured.
+func (account *Account) ArchiveMailer() Mailer {
+	if account.DeliveryMode == DeliveryModeImap {
+		return &ImapMailer{account.Imap}
+	}
+	return mailer
+}
+
+func (account *Account) ArchiveDeliveryVerb() string {
+	if account.DeliveryMode == DeliveryModeImap {
+		return "Saved"
+	}
+	return "Emailed"
+}
+
+// CadenceForConversation returns how often the conversation's archives are
+// sent, taking into account per-conversation overrides.
+func (account *Account) CadenceForConversation(conversationId string) string {
+	for _, override := range account.ConversationCadences {
+		if override.ConversationId == conversationId {
+			return override.Cadence
+		}
+	}
+	if account.Cadence == "" {
+		return CadenceDaily
+	}
+	return account.Cadence
+}
+
+// SetConversationCadence overrides the cadence for a conversation, an empty
+// cadence removes the override.
+func (account *Account) SetConversationCadence(conversationId string, cadence string) {
+	overrides := make([]ConversationCadence, 0, len(account.ConversationCadences)+1)
+	for _, override := range account.ConversationCadences {
+		if override.ConversationId != conversationId {
+			overrides = append(overrides, override)
+		}
+	}
+	if cadence != "" {
+		overrides = append(overrides, ConversationCadence{conversationId, cadence})
+	}
+	account.ConversationCadences = overrides
+}
+
+func (account *Account) CadenceDisplayName() string {
+	return cadenceDisplayName(account.Cadence, account.CadenceWeekday)
+}
+
 func (account *Account) NewSlackClient(c context.Context) *slack.Client {
-	// The Slack API uses the default HTTP transport, so we need to override
-	// it to get it to work on App Engine. This is normally done for all
-	// handlers, but since we're in a delay function that code has not run.
-	c, _ = context.WithTimeout(c, time.Second*60)
-	appengineTransport := &urlfetch.Transport{Context: c}
-	http.DefaultTransport = &CachingTransport{
-		Transport: appengineTransport,
-		Context:   c,
-	}
-	return slack.New(account.ApiToken)
+	return newSlackClient(c, account.ApiToken)
+}
+
+// newSlackClient makes requests with its own HTTP client (see
+// newSlackHttpClient), so that concurrent requests and tasks don't share a
+// context.
+func newSlackClient(c context.Context, token string) *slack.Client {
+	options := []slack.Option{slack.OptionHTTPClient(newSlackHttpClient(c))}
+	if slackApiUrl != "" {
+		options = append(options, slack.OptionAPIURL(slackApiUrl))
+	}
+	return slack.New(token, options...)
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var ErrNoSuchAccount = errors.New("No such account")
+
+// Persistence for Account entities. Get returns ErrNoSuchAccount if there is
+// no account for the given Slack user ID. Update atomically applies a change
+// to the stored account, for state that is modified by concurrent tasks.
+type AccountStore interface {
+	Get(c context.Context, slackUserId string) (*Account, error)
+	GetAll(c context.Context) ([]Account, error)
+	Put(c context.Context, account *Account) error
+	Update(c context.Context, slackUserId string, update func(account *Account) error) error
+	Delete(c context.Context, slackUserId string) error
+}
+
+// Non-persistent AccountStore, accounts are lost when the process exits.
+type MemoryAccountStore struct {
+	mu       sync.Mutex
+	accounts map[string]Account
+}
+
+func newMemoryAccountStore() *MemoryAccountStore {
+	return &MemoryAccountStore{accounts: make(map[string]Account)}
+}
+
+func (s *MemoryAccountStore) Get(c context.Context, slackUserId string) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	account, ok := s.accounts[slackUserId]
+	if !ok {
+		return nil, ErrNoSuchAccount
+	}
+	return &account, nil
+}
+
+func (s *MemoryAccountStore) GetAll(c context.Context) ([]Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accounts := make([]Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].SlackUserId < accounts[j].SlackUserId
+	})
+	return accounts, nil
+}
+
+func (s *MemoryAccountStore) Put(c context.Context, account *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[account.SlackUserId] = *account
+	return nil
+}
+
+func (s *MemoryAccountStore) Update(c context.Context, slackUserId string, update func(account *Account) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	account, ok := s.accounts[slackUserId]
+	if !ok {
+		return ErrNoSuchAccount
+	}
+	if err := update(&account); err != nil {
+		return err
+	}
+	s.accounts[slackUserId] = account
+	return nil
+}
+
+func (s *MemoryAccountStore) Delete(c context.Context, slackUserId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.accounts, slackUserId)
+	return nil
+}
@@ -0,0 +1,103 @@
+//go:build standalone
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const BoltAccountBucket = "Account"
+
+func openBoltDatabase(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 10})
+}
+
+// AccountStore backed by an embedded bbolt database, with accounts stored as
+// JSON keyed by Slack user ID.
+type BoltAccountStore struct {
+	db *bolt.DB
+}
+
+func newBoltAccountStore(db *bolt.DB) (*BoltAccountStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(BoltAccountBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltAccountStore{db}, nil
+}
+
+func (s *BoltAccountStore) Get(c context.Context, slackUserId string) (*Account, error) {
+	var account *Account
+	err := s.db.View(func(tx *bolt.Tx) error {
+		accountBytes := tx.Bucket([]byte(BoltAccountBucket)).Get([]byte(slackUserId))
+		if accountBytes == nil {
+			return ErrNoSuchAccount
+		}
+		account = new(Account)
+		return json.Unmarshal(accountBytes, account)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *BoltAccountStore) GetAll(c context.Context) ([]Account, error) {
+	var accounts []Account
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BoltAccountBucket)).ForEach(func(k, v []byte) error {
+			var account Account
+			if err := json.Unmarshal(v, &account); err != nil {
+				return err
+			}
+			accounts = append(accounts, account)
+			return nil
+		})
+	})
+	return accounts, err
+}
+
+func (s *BoltAccountStore) Put(c context.Context, account *Account) error {
+	accountBytes, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BoltAccountBucket)).Put([]byte(account.SlackUserId), accountBytes)
+	})
+}
+
+func (s *BoltAccountStore) Update(c context.Context, slackUserId string, update func(account *Account) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(BoltAccountBucket))
+		accountBytes := bucket.Get([]byte(slackUserId))
+		if accountBytes == nil {
+			return ErrNoSuchAccount
+		}
+		account := new(Account)
+		if err := json.Unmarshal(accountBytes, account); err != nil {
+			return err
+		}
+		if err := update(account); err != nil {
+			return err
+		}
+		accountBytes, err := json.Marshal(account)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(slackUserId), accountBytes)
+	})
+}
+
+func (s *BoltAccountStore) Delete(c context.Context, slackUserId string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BoltAccountBucket)).Delete([]byte(slackUserId))
+	})
+}
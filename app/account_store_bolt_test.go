@@ -0,0 +1,21 @@
+//go:build standalone
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltAccountStore(t *testing.T) {
+	db, err := openBoltDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err := newBoltAccountStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAccountStore(t, store)
+}
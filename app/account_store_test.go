@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func testAccountStore(t *testing.T, store AccountStore) {
+	c := context.Background()
+
+	if _, err := store.Get(c, "U1"); err != ErrNoSuchAccount {
+		t.Fatalf("Get of missing account: got %v, want ErrNoSuchAccount", err)
+	}
+
+	account := &Account{
+		SlackUserId:        "U1",
+		SlackTeamName:      "Team",
+		ApiToken:           "xoxp-1",
+		TimezoneName:       "Europe/Paris",
+		DigestEmailAddress: "one@example.com",
+	}
+	if err := initAccount(account); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(c, account); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put(c, &Account{SlackUserId: "U2", ApiToken: "xoxp-2"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	stored, err := store.Get(c, "U1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if stored.ApiToken != "xoxp-1" || stored.TimezoneName != "Europe/Paris" ||
+		stored.DigestEmailAddress != "one@example.com" {
+		t.Errorf("Get: got %+v", stored)
+	}
+
+	account.DirectMessagesOnly = true
+	if err := store.Put(c, account); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	all, err := store.GetAll(c)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("GetAll: got %d accounts, want 2", len(all))
+	}
+	for _, a := range all {
+		if a.SlackUserId == "U1" && !a.DirectMessagesOnly {
+			t.Errorf("GetAll: update of U1 was not persisted")
+		}
+	}
+
+	err = store.Update(c, "U2", func(account *Account) error {
+		account.DigestEmailAddress = "two@example.com"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if stored, err := store.Get(c, "U2"); err != nil || stored.DigestEmailAddress != "two@example.com" || stored.ApiToken != "xoxp-2" {
+		t.Errorf("Update: got %+v (%v)", stored, err)
+	}
+	if err := store.Update(c, "U3", func(account *Account) error { return nil }); err != ErrNoSuchAccount {
+		t.Errorf("Update of missing account: got %v, want ErrNoSuchAccount", err)
+	}
+
+	if err := store.Delete(c, "U1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(c, "U1"); err != ErrNoSuchAccount {
+		t.Errorf("Get of deleted account: got %v, want ErrNoSuchAccount", err)
+	}
+}
+
+func TestMemoryAccountStore(t *testing.T) {
+	testAccountStore(t, newMemoryAccountStore())
+}
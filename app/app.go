@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,13 +10,8 @@ import (
 	"net/http"
 	"path/filepath"
 	"runtime/debug"
+	"sort"
 	"strings"
-	"time"
-
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/mail"
-	"google.golang.org/appengine/urlfetch"
 
 	"github.com/gorilla/sessions"
 	"github.com/slack-go/slack"
@@ -150,19 +144,23 @@ type AppHandler func(http.ResponseWriter, *http.Request) *AppError
 func (fn AppHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer panicRecovery(w, r)
 	makeUncacheable(w)
-	c, _ := context.WithTimeout(appengine.NewContext(r), time.Second*60)
-	// The Slack API uses the default HTTP transport, so we need to override it
-	// to get it to work on App Engine.
-	appengineTransport := &urlfetch.Transport{Context: c}
-	http.DefaultTransport = &CachingTransport{
-		Transport: appengineTransport,
-		Context:   c,
-	}
 	if e := fn(w, r); e != nil {
 		handleAppError(e, w, r)
 	}
 }
 
+// Handler for /admin/ routes. On App Engine they're restricted to admins by
+// app.yaml, when running standalone an admin token needs to be configured.
+type AdminAppHandler func(http.ResponseWriter, *http.Request) *AppError
+
+func (fn AdminAppHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isAdminRequest(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	AppHandler(fn).ServeHTTP(w, r)
+}
+
 type SignedInAppHandler func(http.ResponseWriter, *http.Request, *AppSignedInState) *AppError
 
 func (fn SignedInAppHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -174,7 +172,7 @@ func (fn SignedInAppHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		handleAppError(NotSignedIn(r), w, r)
 		return
 	}
-	c := appengine.NewContext(r)
+	c := newContext(r)
 	account, err := getAccount(c, userId)
 	if account == nil || err != nil {
 		handleAppError(NotSignedIn(r), w, r)
@@ -207,28 +205,28 @@ func makeUncacheable(w http.ResponseWriter) {
 }
 
 func handleAppError(e *AppError, w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := newContext(r)
 	if e.Type == AppErrorTypeRedirect {
 		http.Redirect(w, r, e.Message, e.Code)
 		return
 	}
 	if e.Type != AppErrorTypeBadInput {
-		log.Errorf(c, "%v", e.Error)
-		if !appengine.IsDevAppServer() {
+		logErrorf(c, "%v", e.Error)
+		if !isDevServer() {
 			sendAppErrorMail(e, r)
 		}
 		var data = map[string]interface{}{
-			"ShowDetails": appengine.IsDevAppServer(),
+			"ShowDetails": isDevServer(),
 			"Error":       e,
 		}
 		w.WriteHeader(e.Code)
 		templateError := templates["internal-error"].Render(w, data)
 		if templateError != nil {
-			log.Errorf(c, "Error %s rendering error template.", templateError.Error.Error())
+			logErrorf(c, "Error %s rendering error template.", templateError.Error.Error())
 		}
 		return
 	} else {
-		log.Infof(c, "%v", e.Error)
+		logInfof(c, "%v", e.Error)
 	}
 	http.Error(w, e.Message, e.Code)
 }
@@ -237,9 +235,9 @@ func sendAppErrorMail(e *AppError, r *http.Request) {
 	session, _ := sessionStore.Get(r, sessionConfig.CookieName)
 	userId, _ := session.Values[sessionConfig.UserIdKey].(string)
 
-	errorMessage := &mail.Message{
-		Sender:  "Slack Archive Admin <admin@slack-archive.appspotmail.com>",
-		To:      []string{"mihai.parparita@gmail.com"},
+	errorMessage := &MailMessage{
+		Sender:  mailConfig.AdminSender,
+		To:      []string{mailConfig.AdminEmailAddress},
 		Subject: fmt.Sprintf("Slack Archive Internal Error on %s", r.URL),
 		Body: fmt.Sprintf(`Request URL: %s
 HTTP status code: %d
@@ -255,10 +253,10 @@ Error: %s`,
 			e.Message,
 			e.Error),
 	}
-	c := appengine.NewContext(r)
-	err := mail.Send(c, errorMessage)
+	c := newContext(r)
+	err := mailer.Send(c, errorMessage)
 	if err != nil {
-		log.Errorf(c, "Error %s sending error email.", err.Error())
+		logErrorf(c, "Error %s sending error email.", err.Error())
 	}
 }
 
@@ -304,13 +302,7 @@ func AbsoluteRouteUrl(name string, pairs ...string) (string, error) {
 }
 
 func AbsolutePathUrl(path string) string {
-	var baseUrl string
-	if appengine.IsDevAppServer() {
-		baseUrl = "http://localhost:8080"
-	} else {
-		baseUrl = "https://slack-archive.appspot.com"
-	}
-	return baseUrl + path
+	return baseUrl() + path
 }
 
 func loadTemplates() (templates map[string]*Template) {
@@ -341,9 +333,9 @@ func loadTemplates() (templates map[string]*Template) {
 		templateName := filepath.Base(templateFileName)
 		templateName = strings.TrimSuffix(templateName, filepath.Ext(templateName))
 		fileNames := make([]string, 0, len(sharedFileNames)+2)
-		// The base template has to come first, except for email ones, which
-		// don't use it.
-		if !strings.HasSuffix(templateName, "-email") {
+		// The base template has to come first, except for email and site
+		// export ones, which don't use it.
+		if !strings.HasSuffix(templateName, "-email") && !strings.HasPrefix(templateName, "site-") {
 			fileNames = append(fileNames, "templates/base/page.html")
 		}
 		fileNames = append(fileNames, templateFileName)
@@ -375,7 +367,15 @@ func loadStyles() (result map[string]template.CSS) {
 		if path != "" {
 			path += "."
 		}
-		for k, v := range stylesJson {
+		// Properties are sorted so that the output is stable (and shorthand
+		// ones come before the longhand ones that refine them).
+		keys := make([]string, 0, len(stylesJson))
+		for k := range stylesJson {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := stylesJson[k]
 			switch v.(type) {
 			case string:
 				*currentStyle += k + ":" + v.(string) + ";"
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Limit on the total size of thumbnails that are embedded in a single archive
+// email, past that point thumbnails are referenced via the proxy URL instead.
+const ArchiveEmbeddedImagesMaxBytes = 10 * 1024 * 1024
+
+// Most mail providers reject messages that are larger than 25 MB. Embedded
+// thumbnails and attached files share this budget (for their base64-encoded
+// size), which leaves room for the rest of the message.
+const ArchiveEmailAttachmentsMaxEncodedBytes = 24 * 1024 * 1024
+
+const ArchiveFileFetchTimeout = time.Second * 60
+
+const (
+	DefaultFileAttachmentMaxFileMegabytes    = 5
+	DefaultFileAttachmentMaxMessageMegabytes = 15
+	// Most mail providers reject messages that are larger than 25 MB, and
+	// base64 encoding makes attachments a third larger.
+	FileAttachmentMaxMessageMegabytesLimit = 18
+)
+
+// Settings for attaching the original files to archive emails. Zero sizes
+// mean that the defaults should be used.
+type FileAttachmentConfig struct {
+	Enabled bool
+	// Files larger than this are linked to instead of being attached.
+	MaxFileMegabytes int
+	// Limit on the total size of attachments in a single archive email, files
+	// past it are linked to.
+	MaxMessageMegabytes int
+}
+
+func (config *FileAttachmentConfig) MaxFileBytes() int {
+	if config.MaxFileMegabytes == 0 {
+		return DefaultFileAttachmentMaxFileMegabytes * 1024 * 1024
+	}
+	return config.MaxFileMegabytes * 1024 * 1024
+}
+
+func (config *FileAttachmentConfig) MaxMessageBytes() int {
+	if config.MaxMessageMegabytes == 0 {
+		return DefaultFileAttachmentMaxMessageMegabytes * 1024 * 1024
+	}
+	return config.MaxMessageMegabytes * 1024 * 1024
+}
+
+func (config *FileAttachmentConfig) Validate() error {
+	if config.MaxFileMegabytes < 0 || config.MaxMessageMegabytes < 0 {
+		return errors.New("Attachment size limits must be positive")
+	}
+	if config.MaxMessageMegabytes > FileAttachmentMaxMessageMegabytesLimit {
+		return fmt.Errorf("Attachments can be at most %d MB per email", FileAttachmentMaxMessageMegabytesLimit)
+	}
+	return nil
+}
+
+// thumbnailSourceUrl returns the Slack URL for a file's thumbnail. We're
+// displaying using the Thumb360 dimensions, but prefer the 720 data (if
+// available) for retina screens.
+func thumbnailSourceUrl(file *slack.File) string {
+	if file.Thumb720 != "" {
+		return file.Thumb720
+	}
+	return file.Thumb360
+}
+
+// fetchSlackFile downloads a (private) Slack file URL using the account's
+// token. Files that are larger than maxBytes result in an error.
+func fetchSlackFile(c context.Context, account *Account, url string, maxBytes int) ([]byte, string, error) {
+	return fetchUrl(c, url, fmt.Sprintf("Bearer %s", account.ApiToken), maxBytes, nil)
+}
+
+// fetchUrl downloads url, with the given Authorization header (if any). Files
+// that are larger than maxBytes result in an error. If allowedHosts is set,
+// url (and any redirects) must be on one of them or their subdomains.
+func fetchUrl(c context.Context, url string, authorization string, maxBytes int, allowedHosts []string) ([]byte, string, error) {
+	c, cancel := context.WithTimeout(c, ArchiveFileFetchTimeout)
+	defer cancel()
+	checkHost := func(req *http.Request) error {
+		if allowedHosts != nil && !isAllowedHost(req.URL.Hostname(), allowedHosts) {
+			return fmt.Errorf("%s is not an allowed host", req.URL.Hostname())
+		}
+		return nil
+	}
+	client := http.Client{
+		Transport: newTransport(c),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return checkHost(req)
+		},
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkHost(req); err != nil {
+		return nil, "", err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("could not fetch %s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxBytes {
+		return nil, "", fmt.Errorf("%s is larger than %d bytes", url, maxBytes)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+func isAllowedHost(host string, allowedHosts []string) bool {
+	host = strings.ToLower(host)
+	for _, allowedHost := range allowedHosts {
+		if host == allowedHost || strings.HasSuffix(host, "."+allowedHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// Files that are included in an archive email, keyed by file ID. Shared by
+// all of the email's messages, so that MessageFile can reference them. Also
+// used for site exports, which save thumbnails alongside the pages.
+type archiveEmailFiles struct {
+	messages            []*Message
+	thumbnailContentIds map[string]string
+	attachmentNames     map[string]string
+	thumbnailPaths      map[string]string
+	// What's left of ArchiveEmailAttachmentsMaxEncodedBytes.
+	remainingEncodedBytes int
+	// Set if the email will be sent with a mailer that rejects some
+	// attachments.
+	attachmentFilter AttachmentFilteringMailer
+}
+
+// newArchiveEmailFiles is passed all of the archives that are included in the
+// email (there's more than one for combined digests).
+func newArchiveEmailFiles(archives ...*ConversationArchive) *archiveEmailFiles {
+	emailFiles := &archiveEmailFiles{
+		thumbnailContentIds: make(map[string]string),
+		attachmentNames:     make(map[string]string),
+		thumbnailPaths:      make(map[string]string),
+
+		remainingEncodedBytes: ArchiveEmailAttachmentsMaxEncodedBytes,
+	}
+	for _, archive := range archives {
+		emailFiles.messages = append(emailFiles.messages, archive.allMessages()...)
+	}
+	for _, message := range emailFiles.messages {
+		message.emailFiles = emailFiles
+	}
+	return emailFiles
+}
+
+// maxAttachmentBytes returns the size of the largest attachment that still
+// fits in the email's budget, once encoded.
+func (emailFiles *archiveEmailFiles) maxAttachmentBytes() int {
+	return maxBase64DataLength(emailFiles.remainingEncodedBytes)
+}
+
+func (emailFiles *archiveEmailFiles) addAttachment(attachment *MailAttachment) {
+	emailFiles.remainingEncodedBytes -= base64EncodedLength(len(attachment.Data))
+}
+
+// allowsAttachment checks the name (e.g. its extension) against the
+// mailer's restrictions, if any.
+func (emailFiles *archiveEmailFiles) allowsAttachment(name string) bool {
+	return emailFiles.attachmentFilter == nil || emailFiles.attachmentFilter.AllowsAttachment(name)
+}
+
+// archiveMailAttachments returns the thumbnails and files that the account
+// wants to have included in the email with the given archives.
+func archiveMailAttachments(c context.Context, account *Account, archives ...*ConversationArchive) []*MailAttachment {
+	var attachments []*MailAttachment
+	emailFiles := newArchiveEmailFiles(archives...)
+	if filter, ok := account.ArchiveMailer().(AttachmentFilteringMailer); ok {
+		emailFiles.attachmentFilter = filter
+	}
+	if account.EmbedImages {
+		attachments = append(attachments, embedArchiveImages(c, account, emailFiles)...)
+	}
+	if account.FileAttachments.Enabled {
+		attachments = append(attachments, attachArchiveFiles(c, account, emailFiles)...)
+	}
+	return attachments
+}
+
+func embeddedThumbnailContentId(file *slack.File) string {
+	return fmt.Sprintf("thumbnail-%s@slack-archive", file.ID)
+}
+
+// embedArchiveImages fetches the thumbnails of all of the files in the email's
+// archives, so that they can be included as inline parts of the email (and
+// still render if the proxy can no longer access them). Thumbnails that can't
+// be fetched (or that don't fit in the size budgets) keep using the proxy URL.
+func embedArchiveImages(c context.Context, account *Account, emailFiles *archiveEmailFiles) []*MailAttachment {
+	attachments := make([]*MailAttachment, 0)
+	remainingBytes := ArchiveEmbeddedImagesMaxBytes
+	for _, message := range emailFiles.messages {
+		for _, file := range message.MessageFiles() {
+			if file.Kind() != MessageFileKindImage {
+				continue
+			}
+			if _, ok := emailFiles.thumbnailContentIds[file.ID]; ok {
+				continue
+			}
+			maxBytes := emailFiles.maxAttachmentBytes()
+			if remainingBytes < maxBytes {
+				maxBytes = remainingBytes
+			}
+			if maxBytes <= 0 {
+				continue
+			}
+			data, contentType, err := fetchSlackFile(c, account, thumbnailSourceUrl(file.File), maxBytes)
+			if err != nil {
+				logWarningf(c, "Could not embed thumbnail for %s: %s", file.ID, err.Error())
+				continue
+			}
+			if !strings.HasPrefix(contentType, "image/") {
+				logWarningf(c, "Could not embed thumbnail for %s: unexpected type %s", file.ID, contentType)
+				continue
+			}
+			name := file.ID + thumbnailExtension(contentType)
+			if !emailFiles.allowsAttachment(name) {
+				logInfof(c, "Not embedding thumbnail for %s, the mailer does not allow %s", file.ID, name)
+				continue
+			}
+			contentId := embeddedThumbnailContentId(file.File)
+			emailFiles.thumbnailContentIds[file.ID] = contentId
+			attachment := &MailAttachment{
+				Name:        name,
+				ContentType: contentType,
+				Data:        data,
+				ContentId:   contentId,
+			}
+			attachments = append(attachments, attachment)
+			emailFiles.addAttachment(attachment)
+			remainingBytes -= len(data)
+		}
+	}
+	return attachments
+}
+
+func thumbnailExtension(contentType string) string {
+	switch contentType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	}
+	return ".jpg"
+}
+
+// attachArchiveFiles downloads the original files that were shared in the
+// email's messages, so that they're preserved even if the workspace goes
+// away. Files that are too large (individually or once the per-message
+// budgets are used up), that the mailer doesn't allow or that can't be fetched
+// are only linked to.
+func attachArchiveFiles(c context.Context, account *Account, emailFiles *archiveEmailFiles) []*MailAttachment {
+	config := account.FileAttachments
+	attachments := make([]*MailAttachment, 0)
+	usedNames := make(map[string]bool)
+	remainingBytes := config.MaxMessageBytes()
+	for _, message := range emailFiles.messages {
+		for _, file := range message.MessageFiles() {
+			if _, ok := emailFiles.attachmentNames[file.ID]; ok {
+				continue
+			}
+			if file.URLPrivate == "" || file.IsExternal ||
+				file.Mode == "tombstone" || file.Mode == "hidden_by_limit" {
+				continue
+			}
+			if !emailFiles.allowsAttachment(file.Name) {
+				logInfof(c, "Not attaching %s, the mailer does not allow %q", file.ID, file.Name)
+				continue
+			}
+			maxBytes := config.MaxFileBytes()
+			if remainingBytes < maxBytes {
+				maxBytes = remainingBytes
+			}
+			if emailMaxBytes := emailFiles.maxAttachmentBytes(); emailMaxBytes < maxBytes {
+				maxBytes = emailMaxBytes
+			}
+			if file.Size > maxBytes {
+				logInfof(c, "Not attaching %s, %s is over the limit", file.ID, file.DisplaySize())
+				continue
+			}
+			data, contentType, err := fetchSlackFile(c, account, file.URLPrivate, maxBytes)
+			if err != nil {
+				logWarningf(c, "Could not attach %s: %s", file.ID, err.Error())
+				continue
+			}
+			if file.Mimetype != "" {
+				contentType = file.Mimetype
+			}
+			name := uniqueAttachmentName(file, usedNames)
+			emailFiles.attachmentNames[file.ID] = name
+			attachment := &MailAttachment{
+				Name:        name,
+				ContentType: contentType,
+				Data:        data,
+			}
+			attachments = append(attachments, attachment)
+			emailFiles.addAttachment(attachment)
+			remainingBytes -= len(data)
+		}
+	}
+	return attachments
+}
+
+// uniqueAttachmentName returns the file's name, with a " (2)"-style suffix if
+// another attachment already has it.
+func uniqueAttachmentName(file *MessageFile, usedNames map[string]bool) string {
+	name := file.Name
+	if name == "" {
+		name = file.ID
+	}
+	extension := path.Ext(name)
+	base := strings.TrimSuffix(name, extension)
+	for i := 2; usedNames[name]; i++ {
+		name = fmt.Sprintf("%s (%d)%s", base, i, extension)
+	}
+	usedNames[name] = true
+	return name
+}
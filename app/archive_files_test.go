@@ -0,0 +1,217 @@
+//go:build standalone
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+// Only run in the standalone build, since App Engine's transport (urlfetch)
+// is not available in tests.
+func TestEmbedArchiveImages(t *testing.T) {
+	account := initTestApp(t)
+	fileUrlRefEncryptionKey = []byte("0123456789abcdef")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+account.ApiToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/thumb.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png data"))
+		case "/login.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	newFile := func(id string, path string) slack.File {
+		return slack.File{ID: id, Mimetype: "image/png", Thumb360: server.URL + path}
+	}
+	reply := newTestMessage(account, slack.Msg{
+		Files: []slack.File{newFile("F1", "/thumb.png"), newFile("F2", "/login.html")},
+	})
+	message := newTestMessage(account, slack.Msg{
+		Files: []slack.File{newFile("F1", "/thumb.png"), newFile("F3", "/missing.png")},
+	})
+	message.ReplyMessageGroups = []*MessageGroup{{Messages: []*Message{reply}}}
+	archive := &ConversationArchive{
+		MessageGroups: []*MessageGroup{{Messages: []*Message{message}}},
+	}
+
+	emailFiles := newArchiveEmailFiles(archive)
+	attachments := embedArchiveImages(context.Background(), account, emailFiles)
+	if len(attachments) != 1 {
+		t.Fatalf("Expected a single attachment, got %d", len(attachments))
+	}
+	attachment := attachments[0]
+	if attachment.ContentId != "thumbnail-F1@slack-archive" || attachment.Name != "F1.png" ||
+		attachment.ContentType != "image/png" || string(attachment.Data) != "png data" {
+		t.Errorf("Unexpected attachment: %+v", attachment)
+	}
+	for _, m := range []*Message{message, reply} {
+		for _, file := range m.MessageFiles() {
+			thumbnailUrl, err := file.ThumbnailUrl()
+			if err != nil {
+				t.Fatal(err)
+			}
+			embedded := strings.HasPrefix(string(thumbnailUrl), "cid:")
+			if embedded != (file.ID == "F1") {
+				t.Errorf("%s: unexpected thumbnail URL %s", file.ID, thumbnailUrl)
+			}
+		}
+	}
+}
+
+func TestAttachArchiveFiles(t *testing.T) {
+	account := initTestApp(t)
+	account.FileAttachments = FileAttachmentConfig{Enabled: true, MaxFileMegabytes: 1, MaxMessageMegabytes: 2}
+	megabyte := strings.Repeat("x", 1024*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+account.ApiToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/small":
+			w.Write([]byte("small"))
+		case "/large":
+			w.Write([]byte(megabyte))
+		case "/unexpectedly-large":
+			w.Write([]byte(megabyte + megabyte))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	files := []slack.File{
+		{ID: "F1", Name: "notes.txt", Mimetype: "text/plain", Size: 5, URLPrivate: server.URL + "/small"},
+		{ID: "F2", Name: "notes.txt", Mimetype: "text/plain", Size: 5, URLPrivate: server.URL + "/small"},
+		{ID: "F3", Name: "huge.zip", Size: 10 * 1024 * 1024, URLPrivate: server.URL + "/large"},
+		{ID: "F4", Name: "wrong-size.bin", Size: 10, URLPrivate: server.URL + "/unexpectedly-large"},
+		{ID: "F5", Name: "a.bin", Size: 1024 * 1024, URLPrivate: server.URL + "/large"},
+		// Over the per-message limit (since F1, F2 and F5 were attached).
+		{ID: "F6", Name: "b.bin", Size: 1024 * 1024, URLPrivate: server.URL + "/large"},
+		{ID: "F7", Name: "missing.txt", Size: 5, URLPrivate: server.URL + "/missing"},
+		{ID: "F8", Name: "external.doc", IsExternal: true, URLPrivate: server.URL + "/small"},
+	}
+	message := newTestMessage(account, slack.Msg{Files: files})
+	archive := &ConversationArchive{
+		MessageGroups: []*MessageGroup{{Messages: []*Message{message}}},
+	}
+
+	emailFiles := newArchiveEmailFiles(archive)
+	attachments := attachArchiveFiles(context.Background(), account, emailFiles)
+	var names []string
+	for _, attachment := range attachments {
+		names = append(names, attachment.Name)
+		if attachment.ContentId != "" {
+			t.Errorf("%s: attachments should not be inline", attachment.Name)
+		}
+	}
+	if actual := strings.Join(names, ", "); actual != "notes.txt, notes (2).txt, a.bin" {
+		t.Errorf("Unexpected attachments: %s", actual)
+	}
+	if attachments[0].ContentType != "text/plain" || string(attachments[0].Data) != "small" {
+		t.Errorf("Unexpected attachment: %+v", attachments[0])
+	}
+	for _, file := range message.MessageFiles() {
+		if file.ID == "F2" && file.AttachmentName() != "notes (2).txt" {
+			t.Errorf("%s: unexpected attachment name %q", file.ID, file.AttachmentName())
+		}
+		if file.ID == "F6" && file.AttachmentName() != "" {
+			t.Errorf("%s: should not be attached", file.ID)
+		}
+	}
+}
+
+func TestArchiveMailAttachmentsSharedBudget(t *testing.T) {
+	account := initTestApp(t)
+	account.FileAttachments = FileAttachmentConfig{Enabled: true, MaxFileMegabytes: 2}
+	megabyte := strings.Repeat("x", 1024*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/thumb.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(megabyte))
+		case "/large":
+			w.Write([]byte(megabyte))
+		case "/small":
+			w.Write([]byte("small"))
+		}
+	}))
+	defer server.Close()
+
+	message := newTestMessage(account, slack.Msg{Files: []slack.File{
+		{ID: "F1", Mimetype: "image/png", Thumb360: server.URL + "/thumb.png"},
+		{ID: "F2", Name: "a.bin", Size: 1024 * 1024, URLPrivate: server.URL + "/large"},
+		{ID: "F3", Name: "notes.txt", Size: 5, URLPrivate: server.URL + "/small"},
+	}})
+	archive := &ConversationArchive{MessageGroups: []*MessageGroup{{Messages: []*Message{message}}}}
+
+	// Only enough room for the (encoded) thumbnail and the small file, even
+	// though both fit in their own limits.
+	emailFiles := newArchiveEmailFiles(archive)
+	emailFiles.remainingEncodedBytes = base64EncodedLength(len(megabyte)) + base64EncodedLength(len("small"))
+	attachments := embedArchiveImages(context.Background(), account, emailFiles)
+	attachments = append(attachments, attachArchiveFiles(context.Background(), account, emailFiles)...)
+	var names []string
+	for _, attachment := range attachments {
+		names = append(names, attachment.Name)
+	}
+	if actual := strings.Join(names, ", "); actual != "F1.png, notes.txt" {
+		t.Errorf("Unexpected attachments: %s", actual)
+	}
+	if emailFiles.remainingEncodedBytes != 0 {
+		t.Errorf("Expected the budget to be used up, %d bytes remain", emailFiles.remainingEncodedBytes)
+	}
+}
+
+// Mailer that only accepts .txt attachments.
+type textOnlyMailer struct {
+	testMailer
+}
+
+func (m *textOnlyMailer) AllowsAttachment(name string) bool {
+	return strings.HasSuffix(name, ".txt")
+}
+
+func TestArchiveMailAttachmentsFiltered(t *testing.T) {
+	account := initTestApp(t)
+	account.FileAttachments = FileAttachmentConfig{Enabled: true}
+	previousMailer := mailer
+	t.Cleanup(func() { mailer = previousMailer })
+	mailer = &textOnlyMailer{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	message := newTestMessage(account, slack.Msg{Files: []slack.File{
+		{ID: "F1", Name: "notes.txt", Size: 4, URLPrivate: server.URL + "/notes.txt"},
+		{ID: "F2", Name: "main.go", Size: 4, URLPrivate: server.URL + "/main.go"},
+		{ID: "F3", Name: "Makefile", Size: 4, URLPrivate: server.URL + "/Makefile"},
+	}})
+	archive := &ConversationArchive{MessageGroups: []*MessageGroup{{Messages: []*Message{message}}}}
+	attachments := archiveMailAttachments(context.Background(), account, archive)
+	if len(attachments) != 1 || attachments[0].Name != "notes.txt" {
+		t.Errorf("Expected only notes.txt to be attached, got %+v", attachments)
+	}
+	// The others are linked to instead.
+	for _, file := range message.MessageFiles() {
+		if attached := file.AttachmentName() != ""; attached != (file.ID == "F1") {
+			t.Errorf("%s: unexpected attachment name %q", file.ID, file.AttachmentName())
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Used as the conversation ID of log entries for combined digests (whose
+	// date is the day the digest was sent, instead of the first day it
+	// covers).
+	ArchiveLogDigestConversationId = "digest"
+	ArchiveLogSentTimeFormat       = "January 2, 2006 at 3:04pm"
+)
+
+var ErrNoSuchArchiveLogEntry = errors.New("No such archive log entry")
+
+// Returned when sending an archive that the log says was already sent (and
+// resending wasn't forced), e.g. because a task was retried.
+var ErrArchiveAlreadySent = errors.New("Archive was already sent")
+
+// Record of an archive that was sent, used to avoid sending it again.
+type ArchiveLogEntry struct {
+	SlackUserId    string
+	ConversationId string
+	// First day that the archive covers, in ArchiveDayFormat.
+	Date string
+	// Last day that the archive covers (empty for entries from before
+	// archives had cadences, which only covered Date).
+	EndDate      string    `datastore:",noindex"`
+	SentTime     time.Time `datastore:",noindex"`
+	MessageCount int       `datastore:",noindex"`
+	DeliveryMode string    `datastore:",noindex"`
+	// Empty if the mailer generated its own (see MessageIdGeneratingMailer).
+	MessageId string `datastore:",noindex"`
+}
+
+func archiveLogKey(slackUserId string, conversationId string, date string) string {
+	return slackUserId + "/" + conversationId + "/" + date
+}
+
+func (entry *ArchiveLogEntry) Key() string {
+	return archiveLogKey(entry.SlackUserId, entry.ConversationId, entry.Date)
+}
+
+func (entry *ArchiveLogEntry) DisplaySentTime(location *time.Location) string {
+	return safeFormattedDate(entry.SentTime.In(location).Format(ArchiveLogSentTimeFormat))
+}
+
+// Persistence for ArchiveLogEntry entities. Get returns
+// ErrNoSuchArchiveLogEntry if the archive hasn't been sent.
+type ArchiveLogStore interface {
+	Get(c context.Context, slackUserId string, conversationId string, date string) (*ArchiveLogEntry, error)
+	Put(c context.Context, entry *ArchiveLogEntry) error
+	// Returns the latest entry for the conversation from before date (i.e.
+	// the previous archive that was sent), or ErrNoSuchArchiveLogEntry.
+	GetPrevious(c context.Context, slackUserId string, conversationId string, date string) (*ArchiveLogEntry, error)
+	// Removes all of the entries for an account, when it's deleted.
+	DeleteAll(c context.Context, slackUserId string) error
+}
+
+func (entry *ArchiveLogEntry) endDate() string {
+	if entry.EndDate == "" {
+		return entry.Date
+	}
+	return entry.EndDate
+}
+
+// sentArchiveLogEntry returns the entry for an archive of the conversation
+// that was sent and covers any of period's days (e.g. a manual send that
+// started on a different day than the scheduled one), or
+// ErrNoSuchArchiveLogEntry. Only the latest one that starts before the end of
+// period is checked, since sent archives don't overlap unless resending was
+// forced.
+func sentArchiveLogEntry(c context.Context, slackUserId string, conversationId string, period ArchivePeriod) (*ArchiveLogEntry, error) {
+	afterEndDate := period.EndDay.AddDate(0, 0, 1).Format(ArchiveDayFormat)
+	entry, err := archiveLog.GetPrevious(c, slackUserId, conversationId, afterEndDate)
+	if err != nil {
+		return nil, err
+	}
+	if entry.endDate() < period.StartDay.Format(ArchiveDayFormat) {
+		return nil, ErrNoSuchArchiveLogEntry
+	}
+	return entry, nil
+}
+
+// checkArchiveLog returns ErrArchiveAlreadySent if an archive that overlaps
+// period was sent (unless force is set).
+func checkArchiveLog(c context.Context, slackUserId string, conversationId string, period ArchivePeriod, force bool) error {
+	if force {
+		return nil
+	}
+	entry, err := sentArchiveLogEntry(c, slackUserId, conversationId, period)
+	if err == ErrNoSuchArchiveLogEntry {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logInfof(c, "Archive %s was already sent on %s", entry.Key(), entry.SentTime)
+	return ErrArchiveAlreadySent
+}
+
+// previousArchiveMessageId returns the Message-ID of the last archive of the
+// conversation that was sent before date, or an empty string if there was
+// none (archives without messages are not sent, so this may be from well
+// before the previous period).
+func previousArchiveMessageId(c context.Context, slackUserId string, conversationId string, date string) (string, error) {
+	entry, err := archiveLog.GetPrevious(c, slackUserId, conversationId, date)
+	if err == ErrNoSuchArchiveLogEntry {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return entry.MessageId, nil
+}
+
+func logSentArchive(c context.Context, account *Account, conversationId string, period ArchivePeriod, messageCount int, messageId string) error {
+	deliveryMode := account.DeliveryMode
+	if deliveryMode == "" {
+		deliveryMode = DeliveryModeEmail
+	}
+	return archiveLog.Put(c, &ArchiveLogEntry{
+		SlackUserId:    account.SlackUserId,
+		ConversationId: conversationId,
+		Date:           period.StartDay.Format(ArchiveDayFormat),
+		EndDate:        period.EndDay.Format(ArchiveDayFormat),
+		SentTime:       time.Now(),
+		MessageCount:   messageCount,
+		DeliveryMode:   deliveryMode,
+		MessageId:      messageId,
+	})
+}
+
+// Non-persistent ArchiveLogStore, entries are lost when the process exits.
+type MemoryArchiveLogStore struct {
+	mu      sync.Mutex
+	entries map[string]ArchiveLogEntry
+}
+
+func newMemoryArchiveLogStore() *MemoryArchiveLogStore {
+	return &MemoryArchiveLogStore{entries: make(map[string]ArchiveLogEntry)}
+}
+
+func (s *MemoryArchiveLogStore) Get(c context.Context, slackUserId string, conversationId string, date string) (*ArchiveLogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[archiveLogKey(slackUserId, conversationId, date)]
+	if !ok {
+		return nil, ErrNoSuchArchiveLogEntry
+	}
+	return &entry, nil
+}
+
+func (s *MemoryArchiveLogStore) Put(c context.Context, entry *ArchiveLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[entry.Key()] = *entry
+	return nil
+}
+
+func (s *MemoryArchiveLogStore) GetPrevious(c context.Context, slackUserId string, conversationId string, date string) (*ArchiveLogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var previous *ArchiveLogEntry
+	for _, entry := range s.entries {
+		if entry.SlackUserId == slackUserId && entry.ConversationId == conversationId && entry.Date < date &&
+			(previous == nil || entry.Date > previous.Date) {
+			entry := entry
+			previous = &entry
+		}
+	}
+	if previous == nil {
+		return nil, ErrNoSuchArchiveLogEntry
+	}
+	return previous, nil
+}
+
+func (s *MemoryArchiveLogStore) DeleteAll(c context.Context, slackUserId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.entries {
+		if strings.HasPrefix(key, slackUserId+"/") {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+//go:build standalone
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const BoltArchiveLogBucket = "ArchiveLog"
+
+// ArchiveLogStore backed by the same bbolt database as BoltAccountStore, with
+// entries stored as JSON keyed by archiveLogKey.
+type BoltArchiveLogStore struct {
+	db *bolt.DB
+}
+
+func newBoltArchiveLogStore(db *bolt.DB) (*BoltArchiveLogStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(BoltArchiveLogBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltArchiveLogStore{db}, nil
+}
+
+func (s *BoltArchiveLogStore) Get(c context.Context, slackUserId string, conversationId string, date string) (*ArchiveLogEntry, error) {
+	var entry *ArchiveLogEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key := archiveLogKey(slackUserId, conversationId, date)
+		entryBytes := tx.Bucket([]byte(BoltArchiveLogBucket)).Get([]byte(key))
+		if entryBytes == nil {
+			return ErrNoSuchArchiveLogEntry
+		}
+		entry = new(ArchiveLogEntry)
+		return json.Unmarshal(entryBytes, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *BoltArchiveLogStore) Put(c context.Context, entry *ArchiveLogEntry) error {
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BoltArchiveLogBucket)).Put([]byte(entry.Key()), entryBytes)
+	})
+}
+
+// Keys end with the date, so the previous entry is the one before where date's
+// key would be.
+func (s *BoltArchiveLogStore) GetPrevious(c context.Context, slackUserId string, conversationId string, date string) (*ArchiveLogEntry, error) {
+	var entry *ArchiveLogEntry
+	prefix := []byte(archiveLogKey(slackUserId, conversationId, ""))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(BoltArchiveLogBucket)).Cursor()
+		k, _ := cursor.Seek([]byte(archiveLogKey(slackUserId, conversationId, date)))
+		var entryBytes []byte
+		if k == nil {
+			k, entryBytes = cursor.Last()
+		} else {
+			k, entryBytes = cursor.Prev()
+		}
+		if k == nil || !bytes.HasPrefix(k, prefix) {
+			return ErrNoSuchArchiveLogEntry
+		}
+		entry = new(ArchiveLogEntry)
+		return json.Unmarshal(entryBytes, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *BoltArchiveLogStore) DeleteAll(c context.Context, slackUserId string) error {
+	prefix := []byte(slackUserId + "/")
+	return s.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(BoltArchiveLogBucket)).Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Seek(prefix) {
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,80 @@
+//go:build standalone
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltArchiveLogStore(t *testing.T) {
+	db, err := openBoltDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err := newBoltArchiveLogStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testArchiveLogStore(t, store)
+}
+
+// checkArchiveLog logs, which requires an App Engine context in that build.
+func TestCheckArchiveLog(t *testing.T) {
+	account := initTestApp(t)
+	c := context.Background()
+	day := func(dayOfMonth int) time.Time {
+		return time.Date(2026, time.March, dayOfMonth, 0, 0, 0, 0, account.TimezoneLocation)
+	}
+	if err := checkArchiveLog(c, account.SlackUserId, "C1", ArchivePeriod{day(4), day(4)}, false); err != nil {
+		t.Errorf("Unsent archive: got %v", err)
+	}
+	account.DeliveryMode = DeliveryModeImap
+	if err := logSentArchive(c, account, "C1", ArchivePeriod{day(4), day(4)}, 2, "<id@example.com>"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkArchiveLog(c, account.SlackUserId, "C1", ArchivePeriod{day(4), day(4)}, false); err != ErrArchiveAlreadySent {
+		t.Errorf("Sent archive: got %v, want ErrArchiveAlreadySent", err)
+	}
+	if err := checkArchiveLog(c, account.SlackUserId, "C1", ArchivePeriod{day(4), day(4)}, true); err != nil {
+		t.Errorf("Forced resend: got %v", err)
+	}
+	if err := checkArchiveLog(c, account.SlackUserId, "C1", ArchivePeriod{day(5), day(5)}, false); err != nil {
+		t.Errorf("Next day's archive: got %v", err)
+	}
+
+	// A weekly archive sent manually on the 11th (covering the 4th to the
+	// 10th) overlaps the one that is due on the 9th, and any later ones that
+	// start before the 11th.
+	if err := logSentArchive(c, account, "C2", ArchivePeriod{day(4), day(10)}, 1, "<weekly@example.com>"); err != nil {
+		t.Fatal(err)
+	}
+	for _, period := range []ArchivePeriod{{day(2), day(8)}, {day(9), day(15)}, {day(10), day(10)}} {
+		if err := checkArchiveLog(c, account.SlackUserId, "C2", period, false); err != ErrArchiveAlreadySent {
+			t.Errorf("Overlapping archive %s: got %v, want ErrArchiveAlreadySent", period.DisplayDate(), err)
+		}
+	}
+	for _, period := range []ArchivePeriod{{day(1), day(3)}, {day(11), day(17)}} {
+		if err := checkArchiveLog(c, account.SlackUserId, "C2", period, false); err != nil {
+			t.Errorf("Separate archive %s: got %v", period.DisplayDate(), err)
+		}
+	}
+
+	entry, err := archiveLog.Get(c, account.SlackUserId, "C1", "2026-03-04")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.DeliveryMode != DeliveryModeImap || entry.MessageCount != 2 || entry.EndDate != "2026-03-04" || entry.SentTime.IsZero() {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+
+	if err := account.Delete(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archiveLog.Get(c, account.SlackUserId, "C1", "2026-03-04"); err != ErrNoSuchArchiveLogEntry {
+		t.Errorf("Entries should be deleted with the account, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func testArchiveLogStore(t *testing.T, store ArchiveLogStore) {
+	c := context.Background()
+
+	if _, err := store.Get(c, "U1", "C1", "2026-03-04"); err != ErrNoSuchArchiveLogEntry {
+		t.Fatalf("Get of missing entry: got %v, want ErrNoSuchArchiveLogEntry", err)
+	}
+
+	sentTime := time.Date(2026, time.March, 5, 8, 0, 0, 0, time.UTC)
+	for _, entry := range []*ArchiveLogEntry{
+		{SlackUserId: "U1", ConversationId: "C1", Date: "2026-03-04", SentTime: sentTime, MessageCount: 3,
+			DeliveryMode: DeliveryModeEmail, MessageId: "<20260304.C1.T1@example.com>"},
+		{SlackUserId: "U1", ConversationId: "C1", Date: "2026-03-05"},
+		{SlackUserId: "U1", ConversationId: "C2", Date: "2026-03-04"},
+		{SlackUserId: "U10", ConversationId: "C1", Date: "2026-03-04"},
+	} {
+		if err := store.Put(c, entry); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	entry, err := store.Get(c, "U1", "C1", "2026-03-04")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !entry.SentTime.Equal(sentTime) || entry.MessageCount != 3 || entry.DeliveryMode != DeliveryModeEmail ||
+		entry.MessageId != "<20260304.C1.T1@example.com>" {
+		t.Errorf("Get: got %+v", entry)
+	}
+
+	for _, test := range []struct {
+		slackUserId    string
+		conversationId string
+		date           string
+		previousDate   string
+	}{
+		{"U1", "C1", "2026-03-05", "2026-03-04"},
+		{"U1", "C1", "2026-04-01", "2026-03-05"},
+		{"U1", "C1", "2026-03-04", ""},
+		{"U1", "C2", "2026-03-10", "2026-03-04"},
+		{"U1", "C3", "2026-03-10", ""},
+		{"U2", "C1", "2026-03-10", ""},
+	} {
+		previous, err := store.GetPrevious(c, test.slackUserId, test.conversationId, test.date)
+		if test.previousDate == "" {
+			if err != ErrNoSuchArchiveLogEntry {
+				t.Errorf("GetPrevious(%s, %s, %s): got %+v, %v, want ErrNoSuchArchiveLogEntry",
+					test.slackUserId, test.conversationId, test.date, previous, err)
+			}
+		} else if err != nil || previous.ConversationId != test.conversationId || previous.Date != test.previousDate {
+			t.Errorf("GetPrevious(%s, %s, %s): got %+v, %v, want %s",
+				test.slackUserId, test.conversationId, test.date, previous, err, test.previousDate)
+		}
+	}
+
+	if err := store.DeleteAll(c, "U1"); err != nil {
+		t.Fatalf("DeleteAll: %v", err)
+	}
+	for _, conversationId := range []string{"C1", "C2"} {
+		if _, err := store.Get(c, "U1", conversationId, "2026-03-04"); err != ErrNoSuchArchiveLogEntry {
+			t.Errorf("Get of deleted %s entry: got %v, want ErrNoSuchArchiveLogEntry", conversationId, err)
+		}
+	}
+	// Other accounts (even ones with the same ID prefix) are not affected.
+	if _, err := store.Get(c, "U10", "C1", "2026-03-04"); err != nil {
+		t.Errorf("Get of other account's entry: %v", err)
+	}
+}
+
+func TestMemoryArchiveLogStore(t *testing.T) {
+	testArchiveLogStore(t, newMemoryArchiveLogStore())
+}
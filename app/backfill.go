@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	BackfillConversationsAll            = "all"
+	BackfillConversationsDirectMessages = "direct-messages"
+
+	// Limit on the number of days that a single backfill can cover.
+	BackfillMaxDays = 366
+	// Backfills that haven't made progress in this long are assumed to have
+	// been interrupted (e.g. because the task ran out of retries or the
+	// standalone server was restarted), and are resumed by the archive cron.
+	BackfillStallTimeout = time.Hour
+)
+
+// Progress of sending archives for past days. Backfills are processed one day
+// at a time by backfillArchiveFunc, which enqueues the archives for that day
+// and then re-enqueues itself. Since the state is stored with the account, an
+// interrupted backfill can be resumed from the day it got to.
+type BackfillState struct {
+	// Inclusive range of days (in ArchiveDayFormat) that archives are sent
+	// for.
+	StartDate string
+	EndDate   string
+	// One of BackfillConversationsAll, BackfillConversationsDirectMessages or
+	// a single conversation (as "<type>/<ref>").
+	Conversations      string
+	ConversationsLabel string
+	// The next day that archives need to be enqueued for, empty once the
+	// backfill is done (or if it was canceled).
+	NextDate      string
+	EnqueuedCount int
+	UpdatedTime   time.Time
+}
+
+// newBackfillState validates a backfill request, dates are in
+// ArchiveDayFormat and the latest day that can be backfilled is yesterday
+// (today's archive hasn't been sent yet).
+func newBackfillState(account *Account, slackClient *slack.Client, startDate string, endDate string, conversations string) (*BackfillState, error) {
+	start, err := parseArchiveDay(startDate, account)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed start date: %s", startDate)
+	}
+	end, err := parseArchiveDay(endDate, account)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed end date: %s", endDate)
+	}
+	if end.Before(start) {
+		return nil, errors.New("The end date must not be before the start date")
+	}
+	if end.After(previousArchiveDay(account)) {
+		return nil, errors.New("Only past days can be backfilled")
+	}
+	state := &BackfillState{
+		StartDate:     startDate,
+		EndDate:       endDate,
+		Conversations: conversations,
+		NextDate:      startDate,
+		UpdatedTime:   time.Now(),
+	}
+	if state.TotalDays() > BackfillMaxDays {
+		return nil, fmt.Errorf("At most %d days can be backfilled at once", BackfillMaxDays)
+	}
+	switch conversations {
+	case BackfillConversationsAll:
+		state.ConversationsLabel = "all conversations"
+	case BackfillConversationsDirectMessages:
+		state.ConversationsLabel = "direct messages"
+	default:
+		conversationType, ref, ok := strings.Cut(conversations, "/")
+		if !ok {
+			return nil, fmt.Errorf("Malformed conversations: %s", conversations)
+		}
+		conversation, err := getConversationFromRef(conversationType, ref, slackClient)
+		if err != nil {
+			return nil, err
+		}
+		state.ConversationsLabel = conversation.Name()
+	}
+	return state, nil
+}
+
+func (state *BackfillState) InProgress() bool {
+	return state.NextDate != ""
+}
+
+func (state *BackfillState) Stalled() bool {
+	return state.InProgress() && time.Since(state.UpdatedTime) > BackfillStallTimeout
+}
+
+func (state *BackfillState) TotalDays() int {
+	return daysBetween(state.StartDate, state.EndDate) + 1
+}
+
+func (state *BackfillState) CompletedDays() int {
+	if !state.InProgress() {
+		return state.TotalDays()
+	}
+	return daysBetween(state.StartDate, state.NextDate)
+}
+
+// daysBetween returns the number of days between two ArchiveDayFormat dates.
+// They're parsed as UTC, so that DST transitions don't affect the result.
+func daysBetween(startDate string, endDate string) int {
+	start, _ := time.Parse(ArchiveDayFormat, startDate)
+	end, _ := time.Parse(ArchiveDayFormat, endDate)
+	return int(end.Sub(start).Hours() / 24)
+}
+
+func (state *BackfillState) advance() {
+	if !state.InProgress() {
+		return
+	}
+	if state.NextDate == state.EndDate {
+		state.NextDate = ""
+		return
+	}
+	next, _ := time.Parse(ArchiveDayFormat, state.NextDate)
+	state.NextDate = next.AddDate(0, 0, 1).Format(ArchiveDayFormat)
+}
+
+func (state *BackfillState) getConversations(slackClient *slack.Client, account *Account) ([]Conversation, error) {
+	switch state.Conversations {
+	case BackfillConversationsAll, BackfillConversationsDirectMessages:
+		conversations, err := getConversations(slackClient, account)
+		if err != nil {
+			return nil, err
+		}
+		if state.Conversations == BackfillConversationsAll {
+			return includedConversations(conversations.AllConversations, account), nil
+		}
+		return includedConversations(
+			append(conversations.DirectMessages, conversations.MultiPartyDirectMessages...), account), nil
+	}
+	conversationType, ref, _ := strings.Cut(state.Conversations, "/")
+	conversation, err := getConversationFromRef(conversationType, ref, slackClient)
+	if err != nil {
+		return nil, err
+	}
+	return []Conversation{conversation}, nil
+}
+
+func startBackfill(c context.Context, account *Account, state *BackfillState) error {
+	// Only the backfill is changed, so that archive tasks' updates to the
+	// account aren't overwritten.
+	err := accountStore.Update(c, account.SlackUserId, func(stored *Account) error {
+		stored.Backfill = *state
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	account.Backfill = *state
+	return backfillArchiveFunc.Call(c, account.SlackUserId)
+}
+
+var backfillArchiveFunc TaskFunc
+
+func init() {
+	// Assigned here since the task re-enqueues itself (which would otherwise
+	// be an initialization cycle).
+	backfillArchiveFunc = newTaskFunc("backfillArchive", backfillArchive)
+}
+
+func backfillArchive(c context.Context, slackUserId string) error {
+	account, err := getAccount(c, slackUserId)
+	if err != nil {
+		logErrorf(c, "Error looking up account %s: %s", slackUserId, err.Error())
+		return err
+	}
+	backfill := &account.Backfill
+	if !backfill.InProgress() {
+		logInfof(c, "No backfill in progress for %s.", slackUserId)
+		return nil
+	}
+	logInfof(c, "Backfilling %s for %s...", backfill.NextDate, slackUserId)
+	slackClient := account.NewSlackClient(c)
+	conversations, err := backfill.getConversations(slackClient, account)
+	if err != nil {
+		logErrorf(c, "  Error looking up conversations: %s", err.Error())
+		return err
+	}
+	for _, conversation := range conversations {
+		conversationType, ref := conversation.ToRef()
+		err := sendConversationArchiveFunc.Call(
+			c, slackUserId, conversationType, ref, CadenceDaily, backfill.NextDate)
+		if err != nil {
+			logErrorf(c, "  Error enqueuing archive: %s", err.Error())
+			return err
+		}
+	}
+	logInfof(c, "  Enqueued %d conversation archives.", len(conversations))
+	backfill.EnqueuedCount += len(conversations)
+	backfill.advance()
+	backfill.UpdatedTime = time.Now()
+	if err := saveBackfillState(c, account); err != nil {
+		logErrorf(c, "  Error saving backfill progress: %s", err.Error())
+		return err
+	}
+	if backfill.InProgress() {
+		return backfillArchiveFunc.Call(c, slackUserId)
+	}
+	logInfof(c, "  Backfill done.")
+	return nil
+}
+
+// resumeStalledBackfill is run by the archive cron, so that backfills that
+// were interrupted eventually finish.
+func resumeStalledBackfill(c context.Context, account *Account) error {
+	if !account.Backfill.Stalled() {
+		return nil
+	}
+	logInfof(c, "Resuming backfill for %s from %s...", account.SlackUserId, account.Backfill.NextDate)
+	// Mark the backfill as updated, so that it's not resumed again while
+	// this task is pending.
+	account.Backfill.UpdatedTime = time.Now()
+	if err := saveBackfillState(c, account); err != nil {
+		return err
+	}
+	return backfillArchiveFunc.Call(c, account.SlackUserId)
+}
+
+// saveBackfillState only updates the backfill state, since archive tasks may
+// be modifying the account concurrently.
+func saveBackfillState(c context.Context, account *Account) error {
+	return accountStore.Update(c, account.SlackUserId, func(stored *Account) error {
+		stored.Backfill = account.Backfill
+		return nil
+	})
+}
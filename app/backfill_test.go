@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNewBackfillState(t *testing.T) {
+	account := &Account{TimezoneName: "America/Los_Angeles"}
+	if err := initAccount(account); err != nil {
+		t.Fatal(err)
+	}
+	yesterday := previousArchiveDay(account).Format(ArchiveDayFormat)
+	today := time.Now().In(account.TimezoneLocation).Format(ArchiveDayFormat)
+
+	state, err := newBackfillState(account, nil, "2026-01-01", yesterday, BackfillConversationsAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.NextDate != "2026-01-01" || state.ConversationsLabel != "all conversations" || !state.InProgress() {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+
+	for _, test := range []struct {
+		name      string
+		startDate string
+		endDate   string
+	}{
+		{"malformed start", "01/01/2026", yesterday},
+		{"malformed end", "2026-01-01", ""},
+		{"reversed", "2026-01-02", "2026-01-01"},
+		{"today", "2026-01-01", today},
+		{"too long", "2020-01-01", "2021-12-31"},
+	} {
+		if _, err := newBackfillState(account, nil, test.startDate, test.endDate, BackfillConversationsDirectMessages); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+	if _, err := newBackfillState(account, nil, "2026-01-01", "2026-01-02", "C123"); err == nil {
+		t.Errorf("Expected an error for malformed conversations")
+	}
+}
+
+func TestBackfillStateProgress(t *testing.T) {
+	// Spans the start of DST in the US.
+	state := &BackfillState{StartDate: "2026-03-07", EndDate: "2026-03-09", NextDate: "2026-03-07"}
+	for _, expected := range []struct {
+		nextDate      string
+		completedDays int
+	}{
+		{"2026-03-07", 0},
+		{"2026-03-08", 1},
+		{"2026-03-09", 2},
+		{"", 3},
+	} {
+		if state.NextDate != expected.nextDate || state.CompletedDays() != expected.completedDays {
+			t.Errorf("Got %q (%d days), want %q (%d days)",
+				state.NextDate, state.CompletedDays(), expected.nextDate, expected.completedDays)
+		}
+		if state.TotalDays() != 3 {
+			t.Errorf("TotalDays: got %d", state.TotalDays())
+		}
+		state.advance()
+	}
+	if state.InProgress() || state.Stalled() {
+		t.Errorf("Expected the backfill to be done")
+	}
+
+	state = &BackfillState{NextDate: "2026-03-07", UpdatedTime: time.Now().Add(-2 * BackfillStallTimeout)}
+	if !state.Stalled() {
+		t.Errorf("Expected the backfill to be stalled")
+	}
+}
+
+func TestCancelBackfillHandler(t *testing.T) {
+	account := initTestApp(t)
+	account.Backfill = BackfillState{StartDate: "2026-01-01", EndDate: "2026-01-31", NextDate: "2026-01-10"}
+	if err := account.Put(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	r, w, state := newTestSignedInRequest(t, "POST", "/account/backfill/cancel", url.Values{}, account)
+	e := cancelBackfillHandler(w, r, state)
+	if e == nil || e.Type != AppErrorTypeRedirect {
+		t.Fatalf("Expected redirect, got %+v", e)
+	}
+	stored, err := getAccount(context.Background(), account.SlackUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Backfill.InProgress() || stored.Backfill.StartDate != "2026-01-01" {
+		t.Errorf("Unexpected backfill state: %+v", stored.Backfill)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Renders Block Kit blocks (https://api.slack.com/block-kit) as HTML. Styles
+// are inlined (from styles.json), since this is used for emails too.
+
+const (
+	BlockDateFormat = "January 2, 2006 3:04pm"
+
+	RichTextListStyleBullet  = "bullet"
+	RichTextListStyleOrdered = "ordered"
+)
+
+// The rich_text element types that the Slack library doesn't parse (it leaves
+// them as slack.RichTextUnknown with the raw JSON).
+type richTextList struct {
+	Style    string                  `json:"style"`
+	Indent   int                     `json:"indent"`
+	Offset   int                     `json:"offset"`
+	Elements []slack.RichTextSection `json:"elements"`
+}
+
+var richTextBulletListStyleTypes = []string{"disc", "circle", "square"}
+var richTextOrderedListStyleTypes = []string{"decimal", "lower-alpha", "lower-roman"}
+
+// User group mentions in the message text, which have the group's handle
+// (looking it up would need another OAuth scope).
+var userGroupMentionPattern = regexp.MustCompile(`<!subteam\^([A-Z0-9]+)\|@?([^>]+)>`)
+
+type blockRenderer struct {
+	builder strings.Builder
+	message *Message
+	// Set if some part of the block could not be rendered.
+	unknown bool
+}
+
+// Each block is rendered separately, so that one that can't be (or that has
+// elements that can't be) doesn't affect the others. Rich text blocks are
+// replaced by the message text (which Slack derives from them), and others
+// by a placeholder. Returns false if none of the blocks could be rendered, in
+// which case the caller should fall back to the message text.
+func blocksToHtml(blocks []slack.Block, message *Message) (string, bool) {
+	var builder strings.Builder
+	rendered := false
+	textUsed := false
+	for _, block := range blocks {
+		r := &blockRenderer{message: message}
+		r.writeBlock(block)
+		if !r.unknown {
+			builder.WriteString(r.builder.String())
+			rendered = true
+			continue
+		}
+		log.Printf("Could not render %s block, falling back", block.BlockType())
+		if block.BlockType() == slack.MBTRichText {
+			if !textUsed {
+				builder.WriteString(string(message.TextHtml()))
+				textUsed = true
+			}
+			continue
+		}
+		fmt.Fprintf(&builder, "<div style='%s'>[Unsupported %s content]</div>",
+			Style("message.block.unsupported"), html.EscapeString(string(block.BlockType())))
+	}
+	if !rendered {
+		return "", false
+	}
+	return builder.String(), true
+}
+
+func (r *blockRenderer) writeBlock(block slack.Block) {
+	switch block := block.(type) {
+	case *slack.RichTextBlock:
+		r.writeRichTextBlock(block)
+	case *slack.HeaderBlock:
+		fmt.Fprintf(&r.builder, "<div style='%s'>", Style("message.block.header"))
+		r.writeTextObject(block.Text)
+		r.builder.WriteString("</div>")
+	case *slack.SectionBlock:
+		r.writeSectionBlock(block)
+	case *slack.ContextBlock:
+		fmt.Fprintf(&r.builder, "<div style='%s'>", Style("message.block.context"))
+		for _, element := range block.ContextElements.Elements {
+			switch element := element.(type) {
+			case *slack.ImageBlockElement:
+				fmt.Fprintf(&r.builder, "<img src='%s' alt='%s' width='16' height='16' style='%s'> ",
+					html.EscapeString(element.ImageURL),
+					html.EscapeString(element.AltText),
+					Style("message.block.context.image"))
+			case *slack.TextBlockObject:
+				r.writeTextObject(element)
+				r.builder.WriteString(" ")
+			default:
+				r.unknown = true
+			}
+		}
+		r.builder.WriteString("</div>")
+	case *slack.ImageBlock:
+		r.builder.WriteString("<div>")
+		if block.Title != nil {
+			fmt.Fprintf(&r.builder, "<div style='%s'>", Style("message.block.image.title"))
+			r.writeTextObject(block.Title)
+			r.builder.WriteString("</div>")
+		}
+		fmt.Fprintf(&r.builder, "<a href='%s'><img src='%s' alt='%s' style='%s'></a></div>",
+			html.EscapeString(block.ImageURL),
+			html.EscapeString(block.ImageURL),
+			html.EscapeString(block.AltText),
+			Style("message.block.image"))
+	case *slack.DividerBlock:
+		fmt.Fprintf(&r.builder, "<hr style='%s'>", Style("message.block.divider"))
+	case *slack.ActionBlock, *slack.InputBlock:
+		// Interactive elements (buttons, menus, etc.) are not useful in an
+		// archive.
+	default:
+		r.unknown = true
+	}
+}
+
+func (r *blockRenderer) writeTextObject(text *slack.TextBlockObject) {
+	if text == nil {
+		return
+	}
+	if text.Type == slack.MarkdownType {
+		r.builder.WriteString(string(textToHtml(text.Text, false, r.message.slackClient)))
+		return
+	}
+	r.builder.WriteString(strings.Replace(html.EscapeString(text.Text), "\n", "<br>", -1))
+}
+
+func (r *blockRenderer) writeSectionBlock(block *slack.SectionBlock) {
+	fmt.Fprintf(&r.builder, "<div style='%s'>", Style("message.block.section"))
+	if block.Accessory != nil && block.Accessory.ImageElement != nil {
+		fmt.Fprintf(&r.builder, "<img src='%s' alt='%s' style='%s'>",
+			html.EscapeString(block.Accessory.ImageElement.ImageURL),
+			html.EscapeString(block.Accessory.ImageElement.AltText),
+			Style("message.block.section.accessory-image"))
+	}
+	if block.Text != nil {
+		fmt.Fprint(&r.builder, "<div>")
+		r.writeTextObject(block.Text)
+		r.builder.WriteString("</div>")
+	}
+	if len(block.Fields) > 0 {
+		fmt.Fprintf(&r.builder, "<table style='%s'><tr>", Style("message.block.section.fields"))
+		for i, field := range block.Fields {
+			if i != 0 && i%2 == 0 {
+				r.builder.WriteString("</tr><tr>")
+			}
+			fmt.Fprintf(&r.builder, "<td width='250' style='%s'>", Style("message.block.section.field"))
+			r.writeTextObject(field)
+			r.builder.WriteString("</td>")
+		}
+		r.builder.WriteString("</tr></table>")
+	}
+	r.builder.WriteString("</div>")
+}
+
+func (r *blockRenderer) writeRichTextBlock(block *slack.RichTextBlock) {
+	for _, element := range block.Elements {
+		switch element := element.(type) {
+		case *slack.RichTextSection:
+			r.writeRichTextSectionElements(element.Elements)
+		case *slack.RichTextUnknown:
+			r.writeRichTextRawElement(element)
+		default:
+			r.unknown = true
+		}
+	}
+}
+
+func (r *blockRenderer) writeRichTextRawElement(element *slack.RichTextUnknown) {
+	switch element.Type {
+	case slack.RTEList:
+		var list richTextList
+		if err := json.Unmarshal([]byte(element.Raw), &list); err != nil {
+			log.Printf("Could not parse rich text list: %s", err)
+			r.unknown = true
+			return
+		}
+		r.writeRichTextList(&list)
+	case slack.RTEQuote, slack.RTEPreformatted:
+		// Their elements are the same as a section's, so it can parse them.
+		var section slack.RichTextSection
+		if err := json.Unmarshal([]byte(element.Raw), &section); err != nil {
+			log.Printf("Could not parse rich text %s: %s", element.Type, err)
+			r.unknown = true
+			return
+		}
+		if element.Type == slack.RTEQuote {
+			fmt.Fprintf(&r.builder, "<blockquote style='%s'>", Style("message.blockquote"))
+			r.writeRichTextSectionElements(section.Elements)
+			r.builder.WriteString("</blockquote>")
+		} else {
+			fmt.Fprintf(&r.builder, "<pre style='%s'>", Style("message.pre"))
+			r.writePreformattedElements(section.Elements)
+			r.builder.WriteString("</pre>")
+		}
+	default:
+		r.unknown = true
+	}
+}
+
+func (r *blockRenderer) writeRichTextList(list *richTextList) {
+	tag := "ul"
+	styleTypes := richTextBulletListStyleTypes
+	start := ""
+	if list.Style == RichTextListStyleOrdered {
+		tag = "ol"
+		styleTypes = richTextOrderedListStyleTypes
+		if list.Offset > 0 {
+			start = fmt.Sprintf(" start='%d'", list.Offset+1)
+		}
+	}
+	fmt.Fprintf(&r.builder, "<%s%s style='%smargin-left:%dem;list-style-type:%s;'>",
+		tag, start,
+		Style("message.block.list"),
+		list.Indent*2,
+		styleTypes[list.Indent%len(styleTypes)])
+	for i := range list.Elements {
+		r.builder.WriteString("<li>")
+		r.writeRichTextSectionElements(list.Elements[i].Elements)
+		r.builder.WriteString("</li>")
+	}
+	fmt.Fprintf(&r.builder, "</%s>", tag)
+}
+
+func (r *blockRenderer) writeRichTextSectionElements(elements []slack.RichTextSectionElement) {
+	for _, element := range elements {
+		switch element := element.(type) {
+		case *slack.RichTextSectionTextElement:
+			r.writeStyled(element.Style, strings.Replace(
+				html.EscapeString(element.Text), "\n", "<br>", -1))
+		case *slack.RichTextSectionLinkElement:
+			text := element.Text
+			if text == "" {
+				text = element.URL
+			}
+			r.writeStyled(element.Style, fmt.Sprintf("<a href='%s' style='%s'>%s</a>",
+				html.EscapeString(element.URL), Style("message.link"), html.EscapeString(text)))
+		case *slack.RichTextSectionUserElement:
+			r.writeStyled(element.Style, r.userMentionHtml(element.UserID))
+		case *slack.RichTextSectionChannelElement:
+			r.writeStyled(element.Style, r.channelMentionHtml(element.ChannelID))
+		case *slack.RichTextSectionEmojiElement:
+			emojiHtml, err := getEmojiHtml(element.Name, r.message.slackClient)
+			if err != nil {
+				emojiHtml = fmt.Sprintf(":%s:", html.EscapeString(element.Name))
+			}
+			r.writeStyled(element.Style, fmt.Sprintf("<span title=\":%s:\">%s</span>",
+				html.EscapeString(element.Name), emojiHtml))
+		case *slack.RichTextSectionBroadcastElement:
+			fmt.Fprintf(&r.builder, "<b>@%s</b>", html.EscapeString(element.Range))
+		case *slack.RichTextSectionUserGroupElement:
+			fmt.Fprintf(&r.builder, "<b>@%s</b>", html.EscapeString(r.userGroupHandle(element.UsergroupID)))
+		case *slack.RichTextSectionTeamElement:
+			fmt.Fprintf(&r.builder, "<b>%s</b>", html.EscapeString(element.TeamID))
+		case *slack.RichTextSectionDateElement:
+			r.builder.WriteString(html.EscapeString(r.formatDate(element.Timestamp)))
+		case *slack.RichTextSectionColorElement:
+			r.builder.WriteString(html.EscapeString(element.Value))
+		default:
+			r.unknown = true
+		}
+	}
+}
+
+// Preformatted text is shown verbatim, so links and mentions are just text.
+func (r *blockRenderer) writePreformattedElements(elements []slack.RichTextSectionElement) {
+	for _, element := range elements {
+		switch element := element.(type) {
+		case *slack.RichTextSectionTextElement:
+			r.builder.WriteString(html.EscapeString(element.Text))
+		case *slack.RichTextSectionLinkElement:
+			text := element.Text
+			if text == "" {
+				text = element.URL
+			}
+			r.builder.WriteString(html.EscapeString(text))
+		default:
+			r.writeRichTextSectionElements([]slack.RichTextSectionElement{element})
+		}
+	}
+}
+
+func (r *blockRenderer) writeStyled(style *slack.RichTextSectionTextStyle, contentHtml string) {
+	if style == nil {
+		r.builder.WriteString(contentHtml)
+		return
+	}
+	if style.Code {
+		contentHtml = fmt.Sprintf("<code style='%s'>%s</code>", Style("message.code"), contentHtml)
+	}
+	if style.Strike {
+		contentHtml = fmt.Sprintf("<del>%s</del>", contentHtml)
+	}
+	if style.Italic {
+		contentHtml = fmt.Sprintf("<i>%s</i>", contentHtml)
+	}
+	if style.Bold {
+		contentHtml = fmt.Sprintf("<b>%s</b>", contentHtml)
+	}
+	r.builder.WriteString(contentHtml)
+}
+
+func (r *blockRenderer) userMentionHtml(userId string) string {
+	user, err := r.message.userLookup.GetUser(userId)
+	if err != nil {
+		log.Printf("Could not render user mention: %s", err)
+		return fmt.Sprintf("@%s", html.EscapeString(userId))
+	}
+	return fmt.Sprintf("<a href='%s' style='%s'>@%s</a>",
+		html.EscapeString(userMentionUrl(user)), Style("message.link"), html.EscapeString(user.Name))
+}
+
+// userGroupHandle returns the group's handle from the mentions in the message
+// text, or "group" if it isn't there.
+func (r *blockRenderer) userGroupHandle(userGroupId string) string {
+	for _, match := range userGroupMentionPattern.FindAllStringSubmatch(r.message.Text, -1) {
+		if match[1] == userGroupId {
+			return match[2]
+		}
+	}
+	return "group"
+}
+
+func (r *blockRenderer) channelMentionHtml(channelId string) string {
+	channel, err := r.message.slackClient.GetConversationInfo(channelId, false)
+	if err != nil {
+		log.Printf("Could not render channel mention: %s", err)
+		return fmt.Sprintf("#%s", html.EscapeString(channelId))
+	}
+	return fmt.Sprintf("<a href='%s' style='%s'>#%s</a>",
+		html.EscapeString(channelMentionUrl(channelId)), Style("message.link"), html.EscapeString(channel.Name))
+}
+
+func (r *blockRenderer) formatDate(timestamp string) string {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return timestamp
+	}
+	return time.Unix(seconds, 0).In(r.message.account.TimezoneLocation).Format(BlockDateFormat)
+}
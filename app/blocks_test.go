@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func newTestBlocksMessage(t *testing.T, messageJson string) *Message {
+	var message slack.Message
+	if err := json.Unmarshal([]byte(messageJson), &message); err != nil {
+		t.Fatal(err)
+	}
+	return newTestMessage(&Account{TimezoneLocation: time.UTC}, message.Msg)
+}
+
+func TestMessageBodyHtmlRichText(t *testing.T) {
+	initTestEmoji()
+	message := newTestBlocksMessage(t, `{
+		"type": "message",
+		"text": "fallback",
+		"blocks": [{
+			"type": "rich_text",
+			"elements": [
+				{"type": "rich_text_section", "elements": [
+					{"type": "text", "text": "Hi "},
+					{"type": "user", "user_id": "U1"},
+					{"type": "text", "text": ", <see> ", "style": {"bold": true}},
+					{"type": "link", "url": "https://example.com/?a=1&b=2", "text": "this", "style": {"italic": true}},
+					{"type": "text", "text": " "},
+					{"type": "emoji", "name": "+1"},
+					{"type": "text", "text": "\nrun "},
+					{"type": "text", "text": "make", "style": {"code": true}},
+					{"type": "broadcast", "range": "here"},
+					{"type": "date", "timestamp": "1772668800"}
+				]},
+				{"type": "rich_text_list", "style": "bullet", "indent": 0, "elements": [
+					{"type": "rich_text_section", "elements": [{"type": "text", "text": "one"}]},
+					{"type": "rich_text_section", "elements": [{"type": "text", "text": "two", "style": {"strike": true}}]}
+				]},
+				{"type": "rich_text_list", "style": "ordered", "indent": 1, "offset": 2, "elements": [
+					{"type": "rich_text_section", "elements": [{"type": "text", "text": "three"}]}
+				]},
+				{"type": "rich_text_quote", "elements": [{"type": "text", "text": "quoted"}]},
+				{"type": "rich_text_preformatted", "elements": [
+					{"type": "text", "text": "if a < b {\n}"},
+					{"type": "link", "url": "https://example.com"}
+				]}
+			]
+		}]
+	}`)
+	expected := "Hi <a href='https://slack.com/app_redirect?team=T1&amp;channel=U1' style=''>@alice</a>" +
+		"<b>, &lt;see&gt; </b>" +
+		"<i><a href='https://example.com/?a=1&amp;b=2' style=''>this</a></i> " +
+		"<span title=\":+1:\">&#x1F44D;</span>" +
+		"<br>run <code style=''>make</code><b>@here</b>March 5, 2026 12:00am" +
+		"<ul style='margin-left:0em;list-style-type:disc;'><li>one</li><li><del>two</del></li></ul>" +
+		"<ol start='3' style='margin-left:2em;list-style-type:lower-alpha;'><li>three</li></ol>" +
+		"<blockquote style=''>quoted</blockquote>" +
+		"<pre style=''>if a &lt; b {\n}https://example.com</pre>"
+	if actual := string(message.BodyHtml()); actual != expected {
+		t.Errorf("BodyHtml:\n got %q\nwant %q", actual, expected)
+	}
+}
+
+func TestMessageBodyHtmlLayoutBlocks(t *testing.T) {
+	message := newTestBlocksMessage(t, `{
+		"type": "message",
+		"text": "fallback",
+		"blocks": [
+			{"type": "header", "text": {"type": "plain_text", "text": "Deploy <done>"}},
+			{"type": "section",
+				"text": {"type": "mrkdwn", "text": "*Status:* ok"},
+				"fields": [
+					{"type": "mrkdwn", "text": "_a_"},
+					{"type": "plain_text", "text": "b"},
+					{"type": "plain_text", "text": "c"}
+				],
+				"accessory": {"type": "image", "image_url": "https://example.com/a.png", "alt_text": "A"}},
+			{"type": "divider"},
+			{"type": "context", "elements": [
+				{"type": "image", "image_url": "https://example.com/i.png", "alt_text": "icon"},
+				{"type": "mrkdwn", "text": "by ~bot~"}
+			]},
+			{"type": "image", "image_url": "https://example.com/b.png", "alt_text": "B",
+				"title": {"type": "plain_text", "text": "Graph"}},
+			{"type": "actions", "elements": [
+				{"type": "button", "text": {"type": "plain_text", "text": "Approve"}, "action_id": "approve"}
+			]}
+		]
+	}`)
+	expected := "<div style=''>Deploy &lt;done&gt;</div>" +
+		"<div style=''><img src='https://example.com/a.png' alt='A' style=''>" +
+		"<div><b>Status:</b> ok</div>" +
+		"<table style=''><tr><td width='250' style=''><i>a</i></td><td width='250' style=''>b</td>" +
+		"</tr><tr><td width='250' style=''>c</td></tr></table></div>" +
+		"<hr style=''>" +
+		"<div style=''><img src='https://example.com/i.png' alt='icon' width='16' height='16' style=''> " +
+		"by <del>bot</del> </div>" +
+		"<div><div style=''>Graph</div><a href='https://example.com/b.png'>" +
+		"<img src='https://example.com/b.png' alt='B' style=''></a></div>"
+	if actual := string(message.BodyHtml()); actual != expected {
+		t.Errorf("BodyHtml:\n got %q\nwant %q", actual, expected)
+	}
+}
+
+func TestMessageBodyHtmlFallback(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		blocksJson string
+	}{
+		{"no blocks", `[]`},
+		{"unknown block", `[{"type": "video", "title": {"type": "plain_text", "text": "Video"}}]`},
+		{"unknown rich text element", `[{"type": "rich_text", "elements": [{"type": "rich_text_future"}]}]`},
+		{"unknown section element", `[{"type": "rich_text", "elements": [
+			{"type": "rich_text_section", "elements": [{"type": "future"}]}
+		]}]`},
+	} {
+		message := newTestBlocksMessage(t,
+			`{"type": "message", "text": "*fallback*", "blocks": `+test.blocksJson+`}`)
+		if actual := string(message.BodyHtml()); actual != "<b>fallback</b>" {
+			t.Errorf("%s: got %q", test.name, actual)
+		}
+	}
+}
+
+func TestMessageBodyHtmlPartialFallback(t *testing.T) {
+	// Only the blocks that can't be rendered fall back, the rich text ones
+	// to the text (once) and others to a placeholder.
+	message := newTestBlocksMessage(t, `{
+		"type": "message",
+		"text": "*fallback*",
+		"blocks": [
+			{"type": "header", "text": {"type": "plain_text", "text": "Title"}},
+			{"type": "rich_text", "elements": [{"type": "rich_text_future"}]},
+			{"type": "video", "title": {"type": "plain_text", "text": "Video"}},
+			{"type": "rich_text", "elements": [{"type": "rich_text_future"}]},
+			{"type": "divider"}
+		]
+	}`)
+	expected := "<div style=''>Title</div>" +
+		"<b>fallback</b>" +
+		"<div style=''>[Unsupported video content]</div>" +
+		"<hr style=''>"
+	if actual := string(message.BodyHtml()); actual != expected {
+		t.Errorf("BodyHtml:\n got %q\nwant %q", actual, expected)
+	}
+}
+
+func TestMessageBodyHtmlUserGroup(t *testing.T) {
+	// Handles come from the mentions in the text, if they're there.
+	message := newTestBlocksMessage(t, `{
+		"type": "message",
+		"text": "<!subteam^S1|@oncall> and <!subteam^S2>",
+		"blocks": [{"type": "rich_text", "elements": [
+			{"type": "rich_text_section", "elements": [
+				{"type": "usergroup", "usergroup_id": "S1"},
+				{"type": "text", "text": " and "},
+				{"type": "usergroup", "usergroup_id": "S2"}
+			]}
+		]}]
+	}`)
+	expected := "<b>@oncall</b> and <b>@group</b>"
+	if actual := string(message.BodyHtml()); actual != expected {
+		t.Errorf("BodyHtml:\n got %q\nwant %q", actual, expected)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCacheMiss = errors.New("Cache miss")
+
+// Byte-oriented cache with memcache semantics: Get returns ErrCacheMiss for
+// missing or expired items, and an expiration of 0 means that the item does
+// not expire (though it may still be evicted).
+type Cache interface {
+	Get(c context.Context, key string) ([]byte, error)
+	Set(c context.Context, key string, value []byte, expiration time.Duration) error
+}
+
+const MemoryCacheMaxItems = 1000
+
+type memoryCacheItem struct {
+	value      []byte
+	expiration time.Time
+}
+
+// In-process Cache, evicts arbitrary items once it has more than
+// MemoryCacheMaxItems.
+type MemoryCache struct {
+	mu    sync.Mutex
+	items map[string]memoryCacheItem
+}
+
+func newMemoryCache() *MemoryCache {
+	return &MemoryCache{items: make(map[string]memoryCacheItem)}
+}
+
+func (cache *MemoryCache) Get(c context.Context, key string) ([]byte, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	item, ok := cache.items[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	if !item.expiration.IsZero() && time.Now().After(item.expiration) {
+		delete(cache.items, key)
+		return nil, ErrCacheMiss
+	}
+	return item.value, nil
+}
+
+func (cache *MemoryCache) Set(c context.Context, key string, value []byte, expiration time.Duration) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	item := memoryCacheItem{value: value}
+	if expiration > 0 {
+		item.expiration = time.Now().Add(expiration)
+	}
+	for evictKey := range cache.items {
+		if len(cache.items) < MemoryCacheMaxItems {
+			break
+		}
+		delete(cache.items, evictKey)
+	}
+	cache.items[key] = item
+	return nil
+}
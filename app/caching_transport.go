@@ -12,11 +12,19 @@ import (
 	"net/http/httputil"
 	"strings"
 	"time"
-
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/memcache"
 )
 
+// newSlackHttpClient returns the client for Slack API requests made on behalf
+// of c, which go through the platform's transport (and the cache).
+func newSlackHttpClient(c context.Context) *http.Client {
+	return &http.Client{
+		Transport: &CachingTransport{
+			Transport: newTransport(c),
+			Context:   c,
+		},
+	}
+}
+
 // Simple http.RoundTripper implementation which wraps an existing transport and
 // caches all responses for GET and HEAD requests. Meant to speed up the
 // iteration cycle during development.
@@ -57,28 +65,28 @@ func (t *CachingTransport) RoundTrip(req *http.Request) (resp *http.Response, er
 	}
 	cacheKey := fmt.Sprintf("CachingTransport:%x", cacheHash.Sum(nil))
 
-	cachedRespItem, err := memcache.Get(t.Context, cacheKey)
-	if err != nil && err != memcache.ErrCacheMiss {
-		log.Errorf(t.Context, "Error getting cached response: %v", err)
+	cachedResp, err := cache.Get(t.Context, cacheKey)
+	if err != nil && err != ErrCacheMiss {
+		logErrorf(t.Context, "Error getting cached response: %v", err)
 		return t.Transport.RoundTrip(req)
 	}
 	if err == nil {
-		cacheRespBuffer := bytes.NewBuffer(cachedRespItem.Value)
+		cacheRespBuffer := bytes.NewBuffer(cachedResp)
 		resp, err := http.ReadResponse(bufio.NewReader(cacheRespBuffer), req)
 		if err == nil {
 			return resp, nil
 		} else {
-			log.Errorf(t.Context, "Error readings bytes for cached response: %v", err)
+			logErrorf(t.Context, "Error readings bytes for cached response: %v", err)
 		}
 	}
-	log.Infof(t.Context, "Fetching %s", req.URL)
+	logInfof(t.Context, "Fetching %s", req.URL)
 	resp, err = t.Transport.RoundTrip(req)
 	if err != nil || resp.StatusCode != 200 {
 		return
 	}
 	respBytes, err := httputil.DumpResponse(resp, true)
 	if err != nil {
-		log.Errorf(t.Context, "Error dumping bytes for cached response: %v", err)
+		logErrorf(t.Context, "Error dumping bytes for cached response: %v", err)
 		return resp, nil
 	}
 	var expiration time.Duration = time.Hour
@@ -86,15 +94,9 @@ func (t *CachingTransport) RoundTrip(req *http.Request) (resp *http.Response, er
 		strings.HasSuffix(req.URL.Path, ".history") {
 		expiration = 0
 	}
-	err = memcache.Set(
-		t.Context,
-		&memcache.Item{
-			Key:        cacheKey,
-			Value:      respBytes,
-			Expiration: expiration,
-		})
+	err = cache.Set(t.Context, cacheKey, respBytes, expiration)
 	if err != nil {
-		log.Errorf(t.Context, "Error setting cached response for %s (cache key %s, %d bytes to cache): %v",
+		logErrorf(t.Context, "Error setting cached response for %s (cache key %s, %d bytes to cache): %v",
 			req.URL, cacheKey, len(respBytes), err)
 	}
 	return resp, nil
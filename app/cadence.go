@@ -0,0 +1,85 @@
+package main
+
+import (
+	"time"
+)
+
+// How often archives are sent, and thus how many days each one covers. Weekly
+// archives are sent on the account's chosen weekday and cover the preceding
+// seven days, monthly ones are sent on the first of the month and cover the
+// previous month.
+const (
+	CadenceDaily   = "daily"
+	CadenceWeekly  = "weekly"
+	CadenceMonthly = "monthly"
+)
+
+// Override of the account's cadence for a single conversation.
+type ConversationCadence struct {
+	ConversationId string
+	Cadence        string
+}
+
+func isValidCadence(cadence string) bool {
+	switch cadence {
+	case CadenceDaily, CadenceWeekly, CadenceMonthly:
+		return true
+	}
+	return false
+}
+
+func cadenceDisplayName(cadence string, weekday time.Weekday) string {
+	switch cadence {
+	case CadenceWeekly:
+		return "Weekly on " + weekday.String() + "s"
+	case CadenceMonthly:
+		return "Monthly"
+	}
+	return "Daily"
+}
+
+// cadenceDue returns whether an archive with the given cadence should be sent
+// on day (the start of a day in the account's timezone).
+func cadenceDue(cadence string, weekday time.Weekday, day time.Time) bool {
+	switch cadence {
+	case CadenceWeekly:
+		return day.Weekday() == weekday
+	case CadenceMonthly:
+		return day.Day() == 1
+	}
+	return true
+}
+
+// cadenceRange returns the first and last days that are covered by an archive
+// that is sent on day.
+func cadenceRange(cadence string, day time.Time) (time.Time, time.Time) {
+	endDay := day.AddDate(0, 0, -1)
+	switch cadence {
+	case CadenceWeekly:
+		return day.AddDate(0, 0, -7), endDay
+	case CadenceMonthly:
+		return day.AddDate(0, -1, 0), endDay
+	}
+	return endDay, endDay
+}
+
+// cadenceEndDay returns the last day that is covered by an archive that starts
+// on startDay. Archives normally start right after the previous one was due,
+// but catch-up archives may not, in which case they're cut short so that
+// later archives line up with the cadence again.
+func cadenceEndDay(cadence string, weekday time.Weekday, startDay time.Time) time.Time {
+	return cadenceNextDue(cadence, weekday, startDay).AddDate(0, 0, -1)
+}
+
+// cadenceNextDue returns the first day after day on which an archive with the
+// given cadence is due.
+func cadenceNextDue(cadence string, weekday time.Weekday, day time.Time) time.Time {
+	switch cadence {
+	case CadenceWeekly:
+		days := (int(weekday)-int(day.Weekday())+6)%7 + 1
+		return day.AddDate(0, 0, days)
+	case CadenceMonthly:
+		return time.Date(day.Year(), day.Month()+1, 1, 0, 0, 0, 0, day.Location())
+	}
+	return day.AddDate(0, 0, 1)
+}
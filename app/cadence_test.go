@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestCadenceRange(t *testing.T) {
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2026, month, day, 0, 0, 0, 0, location)
+	}
+	for _, test := range []struct {
+		cadence  string
+		day      time.Time
+		due      bool
+		startDay time.Time
+		endDay   time.Time
+	}{
+		{CadenceDaily, date(time.March, 4), true, date(time.March, 3), date(time.March, 3)},
+		// March 9, 2026 is a Monday (and the day after DST starts).
+		{CadenceWeekly, date(time.March, 9), true, date(time.March, 2), date(time.March, 8)},
+		{CadenceWeekly, date(time.March, 10), false, date(time.March, 3), date(time.March, 9)},
+		{CadenceMonthly, date(time.March, 1), true, date(time.February, 1), date(time.February, 28)},
+		{CadenceMonthly, date(time.March, 2), false, date(time.February, 2), date(time.March, 1)},
+	} {
+		if due := cadenceDue(test.cadence, time.Monday, test.day); due != test.due {
+			t.Errorf("%s on %s: got due %v", test.cadence, test.day, due)
+		}
+		startDay, endDay := cadenceRange(test.cadence, test.day)
+		if !startDay.Equal(test.startDay) || !endDay.Equal(test.endDay) {
+			t.Errorf("%s on %s: got %s - %s, want %s - %s",
+				test.cadence, test.day, startDay, endDay, test.startDay, test.endDay)
+		}
+		if !test.due {
+			continue
+		}
+		if actual := cadenceEndDay(test.cadence, time.Monday, startDay); !actual.Equal(endDay) {
+			t.Errorf("%s from %s: got end day %s, want %s", test.cadence, startDay, actual, endDay)
+		}
+	}
+	// Archives that don't start on a period boundary end early, so that the
+	// next one does.
+	for _, test := range []struct {
+		cadence  string
+		startDay time.Time
+		endDay   time.Time
+	}{
+		{CadenceWeekly, date(time.March, 4), date(time.March, 8)},
+		{CadenceWeekly, date(time.March, 9), date(time.March, 15)},
+		{CadenceMonthly, date(time.February, 15), date(time.February, 28)},
+		{CadenceMonthly, date(time.December, 2), date(time.December, 31)},
+	} {
+		if actual := cadenceEndDay(test.cadence, time.Monday, test.startDay); !actual.Equal(test.endDay) {
+			t.Errorf("%s from %s: got end day %s, want %s", test.cadence, test.startDay, actual, test.endDay)
+		}
+	}
+}
+
+func TestAccountCadenceForConversation(t *testing.T) {
+	account := &Account{}
+	if cadence := account.CadenceForConversation("C1"); cadence != CadenceDaily {
+		t.Errorf("Default: got %s", cadence)
+	}
+	account.Cadence = CadenceWeekly
+	account.SetConversationCadence("C1", CadenceMonthly)
+	account.SetConversationCadence("C2", CadenceDaily)
+	account.SetConversationCadence("C1", CadenceDaily)
+	for _, test := range []struct{ conversationId, cadence string }{
+		{"C1", CadenceDaily},
+		{"C2", CadenceDaily},
+		{"C3", CadenceWeekly},
+	} {
+		if cadence := account.CadenceForConversation(test.conversationId); cadence != test.cadence {
+			t.Errorf("%s: got %s, want %s", test.conversationId, cadence, test.cadence)
+		}
+	}
+	account.SetConversationCadence("C2", "")
+	if len(account.ConversationCadences) != 1 || account.CadenceForConversation("C2") != CadenceWeekly {
+		t.Errorf("Override not removed: %+v", account.ConversationCadences)
+	}
+}
+
+func TestConversationArchiveDays(t *testing.T) {
+	account := &Account{TimezoneLocation: time.UTC}
+	newGroup := func(timestamp string, text string) *MessageGroup {
+		return &MessageGroup{
+			Messages: []*Message{newTestMessage(account, slack.Msg{Timestamp: timestamp, Text: text})},
+			Author:   testAlice,
+		}
+	}
+	archive := &ConversationArchive{
+		Conversation: &ChannelConversation{&slack.Channel{GroupConversation: slack.GroupConversation{Name: "general"}}},
+		MessageGroups: []*MessageGroup{
+			// March 2, 2026 10:00 and 11:00, March 4 2026 23:00 UTC.
+			newGroup("1772445600", "first"),
+			newGroup("1772449200", "second"),
+			newGroup("1772665200", "third"),
+		},
+		MessageCount: 3,
+		StartTime:    time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:      time.Date(2026, time.March, 7, 23, 59, 59, 0, time.UTC),
+	}
+	if !archive.MultiDay() {
+		t.Fatalf("Expected a multi-day archive")
+	}
+	days := archive.Days()
+	if len(days) != 2 || len(days[0].MessageGroups) != 2 || len(days[1].MessageGroups) != 1 {
+		t.Fatalf("Unexpected days: %+v", days)
+	}
+	if days[1].Date.Day() != 4 {
+		t.Errorf("Unexpected second day: %s", days[1].Date)
+	}
+
+	plainText := archive.PlainText()
+	for _, expected := range []string{
+		"== " + days[0].DisplayDate() + " ==\n\nalice",
+		"== " + days[1].DisplayDate() + " ==\n\nalice",
+	} {
+		if !strings.Contains(plainText, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, plainText)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Limit on how far back missed archives are caught up on (e.g. after a token
+// was revoked for a long time).
+const CatchUpMaxDays = BackfillMaxDays
+
+// Limit on how many archives (or digests) a single scheduled run sends, so
+// that catching up on a long gap doesn't enqueue hundreds of tasks at once.
+// The oldest ones are sent first, later runs continue where they left off.
+const CatchUpMaxArchivesPerRun = 50
+
+// End time up to which all of a conversation's archives were sent (or were
+// found to be empty). Scheduled runs send all of the archives that were missed
+// since then, so that days aren't lost if a run or task fails.
+type ConversationArchiveStatus struct {
+	ConversationId      string
+	LastArchivedEndTime time.Time
+}
+
+// Inclusive range of days that an archive covers.
+type ArchivePeriod struct {
+	StartDay time.Time
+	EndDay   time.Time
+}
+
+func (period ArchivePeriod) EndTime() time.Time {
+	return period.EndDay.AddDate(0, 0, 1).Add(-time.Second)
+}
+
+func (period ArchivePeriod) DisplayDate() string {
+	return archiveDisplayDate(period.StartDay, period.EndDay)
+}
+
+// Archives that should have been sent but weren't, shown on the index page.
+type ArchiveGap struct {
+	Name    string
+	Periods []ArchivePeriod
+}
+
+func (gap *ArchiveGap) DisplayDate() string {
+	return archiveDisplayDate(gap.Periods[0].StartDay, gap.Periods[len(gap.Periods)-1].EndDay)
+}
+
+// ConversationLastArchivedEndTime returns the zero time if the conversation
+// has never been archived.
+func (account *Account) ConversationLastArchivedEndTime(conversationId string) time.Time {
+	for _, status := range account.ConversationArchiveStatuses {
+		if status.ConversationId == conversationId {
+			return status.LastArchivedEndTime
+		}
+	}
+	return time.Time{}
+}
+
+func (account *Account) setConversationLastArchivedEndTime(conversationId string, endTime time.Time) {
+	for i := range account.ConversationArchiveStatuses {
+		status := &account.ConversationArchiveStatuses[i]
+		if status.ConversationId == conversationId {
+			if endTime.After(status.LastArchivedEndTime) {
+				status.LastArchivedEndTime = endTime
+			}
+			return
+		}
+	}
+	account.ConversationArchiveStatuses = append(
+		account.ConversationArchiveStatuses, ConversationArchiveStatus{conversationId, endTime})
+}
+
+// recordConversationsArchived updates the statuses of conversations (keyed by
+// ID), e.g. after a digest was sent. Times only move forward, so backfilling
+// older days has no effect. Archive tasks for the same account run
+// concurrently, hence the update.
+func recordConversationsArchived(c context.Context, slackUserId string, endTimes map[string]time.Time) error {
+	return accountStore.Update(c, slackUserId, func(account *Account) error {
+		for conversationId, endTime := range endTimes {
+			account.setConversationLastArchivedEndTime(conversationId, endTime)
+		}
+		return nil
+	})
+}
+
+// recordConversationArchived updates the conversation's status after the
+// archive for period was sent (or was found to be empty). Archive tasks run
+// concurrently and may fail, so the time only moves forward if period
+// continues from it. Otherwise an earlier archive is still missing, and later
+// runs need to catch up on it (the archive log keeps the ones after it from
+// being sent again).
+func recordConversationArchived(c context.Context, slackUserId string, conversationId string, period ArchivePeriod) error {
+	return accountStore.Update(c, slackUserId, func(account *Account) error {
+		lastEndTime := account.ConversationLastArchivedEndTime(conversationId)
+		if !lastEndTime.IsZero() && !continuesArchives(lastEndTime, period.StartDay) {
+			return nil
+		}
+		account.setConversationLastArchivedEndTime(conversationId, period.EndTime())
+		return nil
+	})
+}
+
+// continuesArchives returns true if there are no days missing between
+// lastEndTime and startDay.
+func continuesArchives(lastEndTime time.Time, startDay time.Time) bool {
+	return !startDay.After(nextArchiveDay(lastEndTime, startDay.Location()))
+}
+
+// recordScheduledArchiveRun records that the scheduled run for the account
+// completed (all archives were enqueued, or the digest was sent).
+func recordScheduledArchiveRun(c context.Context, slackUserId string, endTime time.Time) error {
+	return accountStore.Update(c, slackUserId, func(account *Account) error {
+		if endTime.After(account.LastArchivedEndTime) {
+			account.LastArchivedEndTime = endTime
+		}
+		return nil
+	})
+}
+
+// missedArchivePeriods returns the periods after lastEndTime that should have
+// been sent by today. Conversations that were never archived only get the
+// archive that is due today (if any).
+func missedArchivePeriods(cadence string, weekday time.Weekday, lastEndTime time.Time, today time.Time) []ArchivePeriod {
+	periods := make([]ArchivePeriod, 0)
+	if lastEndTime.IsZero() {
+		if cadenceDue(cadence, weekday, today) {
+			startDay, endDay := cadenceRange(cadence, today)
+			periods = append(periods, ArchivePeriod{startDay, endDay})
+		}
+		return periods
+	}
+	startDay := nextArchiveDay(lastEndTime, today.Location())
+	if earliestDay := today.AddDate(0, 0, -CatchUpMaxDays); startDay.Before(earliestDay) {
+		startDay = earliestDay
+	}
+	for {
+		endDay := cadenceEndDay(cadence, weekday, startDay)
+		if !endDay.Before(today) {
+			break
+		}
+		periods = append(periods, ArchivePeriod{startDay, endDay})
+		startDay = endDay.AddDate(0, 0, 1)
+	}
+	return periods
+}
+
+// missedDigestDays returns the days that the scheduled run should have sent a
+// digest on, from the day after the last one up to today.
+func missedDigestDays(lastEndTime time.Time, today time.Time) []time.Time {
+	if lastEndTime.IsZero() {
+		return []time.Time{today}
+	}
+	// The digest sent on a day covers the days before it.
+	day := nextArchiveDay(lastEndTime, today.Location()).AddDate(0, 0, 1)
+	if earliestDay := today.AddDate(0, 0, -CatchUpMaxDays); day.Before(earliestDay) {
+		day = earliestDay
+	}
+	days := make([]time.Time, 0)
+	for ; !day.After(today); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return days
+}
+
+// nextArchiveDay returns the start of the day after the one that t is in.
+func nextArchiveDay(t time.Time, location *time.Location) time.Time {
+	t = t.In(location)
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+}
+
+// archiveGaps returns the archives that were missed before today (the ones
+// that are due today may not have been sent yet).
+func archiveGaps(account *Account, conversations []Conversation) []*ArchiveGap {
+	today := currentArchiveDay(account)
+	gaps := make([]*ArchiveGap, 0)
+	if account.CombinedDigest {
+		if account.LastArchivedEndTime.IsZero() {
+			return gaps
+		}
+		gap := &ArchiveGap{Name: "Digest"}
+		for _, day := range missedDigestDays(account.LastArchivedEndTime, today) {
+			if day.Before(today) {
+				gap.Periods = append(gap.Periods, ArchivePeriod{day.AddDate(0, 0, -1), day.AddDate(0, 0, -1)})
+			}
+		}
+		if len(gap.Periods) > 0 {
+			gaps = append(gaps, gap)
+		}
+		return gaps
+	}
+	for _, conversation := range includedConversations(conversations, account) {
+		lastEndTime := account.ConversationLastArchivedEndTime(conversation.Id())
+		if lastEndTime.IsZero() {
+			continue
+		}
+		gap := &ArchiveGap{Name: conversation.Name()}
+		cadence := account.CadenceForConversation(conversation.Id())
+		for _, period := range missedArchivePeriods(cadence, account.CadenceWeekday, lastEndTime, today) {
+			if period.EndDay.AddDate(0, 0, 1).Before(today) {
+				gap.Periods = append(gap.Periods, period)
+			}
+		}
+		if len(gap.Periods) > 0 {
+			gaps = append(gaps, gap)
+		}
+	}
+	return gaps
+}
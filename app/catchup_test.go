@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMissedArchivePeriods(t *testing.T) {
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2026, month, day, 0, 0, 0, 0, location)
+	}
+	endOf := func(day time.Time) time.Time {
+		return day.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	for _, test := range []struct {
+		name        string
+		cadence     string
+		lastEndTime time.Time
+		today       time.Time
+		expected    []ArchivePeriod
+	}{
+		{"up to date", CadenceDaily, endOf(date(time.March, 3)), date(time.March, 4), nil},
+		{"daily", CadenceDaily, endOf(date(time.March, 1)), date(time.March, 4), []ArchivePeriod{
+			{date(time.March, 2), date(time.March, 2)},
+			{date(time.March, 3), date(time.March, 3)},
+		}},
+		// March 2 and 9, 2026 are Mondays.
+		{"weekly", CadenceWeekly, endOf(date(time.March, 1)), date(time.March, 16), []ArchivePeriod{
+			{date(time.March, 2), date(time.March, 8)},
+			{date(time.March, 9), date(time.March, 15)},
+		}},
+		{"weekly not yet due", CadenceWeekly, endOf(date(time.March, 8)), date(time.March, 13), nil},
+		{"weekly after daily", CadenceWeekly, endOf(date(time.March, 3)), date(time.March, 9), []ArchivePeriod{
+			{date(time.March, 4), date(time.March, 8)},
+		}},
+		{"monthly", CadenceMonthly, endOf(date(time.January, 31)), date(time.April, 1), []ArchivePeriod{
+			{date(time.February, 1), date(time.February, 28)},
+			{date(time.March, 1), date(time.March, 31)},
+		}},
+		{"new daily", CadenceDaily, time.Time{}, date(time.March, 4), []ArchivePeriod{
+			{date(time.March, 3), date(time.March, 3)},
+		}},
+		{"new weekly", CadenceWeekly, time.Time{}, date(time.March, 4), nil},
+	} {
+		actual := missedArchivePeriods(test.cadence, time.Monday, test.lastEndTime, test.today)
+		if len(actual) != len(test.expected) {
+			t.Errorf("%s: got %v, want %v", test.name, actual, test.expected)
+			continue
+		}
+		for i := range actual {
+			if !actual[i].StartDay.Equal(test.expected[i].StartDay) || !actual[i].EndDay.Equal(test.expected[i].EndDay) {
+				t.Errorf("%s: got %v, want %v", test.name, actual, test.expected)
+				break
+			}
+		}
+	}
+
+	periods := missedArchivePeriods(CadenceDaily, time.Monday, endOf(date(time.January, 1).AddDate(-2, 0, 0)), date(time.March, 4))
+	if len(periods) != CatchUpMaxDays {
+		t.Errorf("Expected catching up to be limited to %d days, got %d", CatchUpMaxDays, len(periods))
+	}
+}
+
+func TestMissedDigestDays(t *testing.T) {
+	today := time.Date(2026, time.March, 4, 0, 0, 0, 0, time.UTC)
+	if days := missedDigestDays(time.Time{}, today); len(days) != 1 || !days[0].Equal(today) {
+		t.Errorf("New account: got %v", days)
+	}
+	if days := missedDigestDays(today.Add(-time.Second), today); len(days) != 0 {
+		t.Errorf("Up to date: got %v", days)
+	}
+	// The last digest was sent on March 1 (covering February 28).
+	days := missedDigestDays(today.AddDate(0, 0, -3).Add(-time.Second), today)
+	if len(days) != 3 || !days[0].Equal(today.AddDate(0, 0, -2)) || !days[2].Equal(today) {
+		t.Errorf("Missed days: got %v", days)
+	}
+}
+
+func TestArchiveGaps(t *testing.T) {
+	account := &Account{TimezoneName: "America/Los_Angeles"}
+	if err := initAccount(account); err != nil {
+		t.Fatal(err)
+	}
+	today := currentArchiveDay(account)
+	general := newTestChannelConversation("C1", "general")
+	random := newTestChannelConversation("C2", "random")
+	newChannel := newTestChannelConversation("C3", "new")
+	account.setConversationLastArchivedEndTime("C1", today.AddDate(0, 0, -1).Add(-time.Second))
+	account.setConversationLastArchivedEndTime("C2", today.AddDate(0, 0, -3).Add(-time.Second))
+
+	gaps := archiveGaps(account, []Conversation{general, random, newChannel})
+	if len(gaps) != 1 || gaps[0].Name != "#random" || len(gaps[0].Periods) != 2 {
+		t.Fatalf("Unexpected gaps: %+v", gaps)
+	}
+
+	account.ConversationFilters.SetIncluded("C2", "false")
+	if gaps := archiveGaps(account, []Conversation{general, random}); len(gaps) != 0 {
+		t.Errorf("Excluded conversations should not have gaps: %+v", gaps)
+	}
+
+	account.CombinedDigest = true
+	account.LastArchivedEndTime = today.AddDate(0, 0, -2).Add(-time.Second)
+	gaps = archiveGaps(account, nil)
+	if len(gaps) != 1 || gaps[0].Name != "Digest" || len(gaps[0].Periods) != 1 {
+		t.Errorf("Unexpected digest gaps: %+v", gaps)
+	}
+}
+
+func TestRecordConversationsArchived(t *testing.T) {
+	account := initTestApp(t)
+	c := context.Background()
+	endTime := time.Date(2026, time.March, 4, 23, 59, 59, 0, account.TimezoneLocation)
+	err := recordConversationsArchived(c, account.SlackUserId, map[string]time.Time{"C1": endTime, "C2": endTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Backfilled archives don't move the time back.
+	err = recordConversationsArchived(c, account.SlackUserId, map[string]time.Time{"C1": endTime.AddDate(0, 0, -7)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = recordConversationsArchived(c, account.SlackUserId, map[string]time.Time{"C2": endTime.AddDate(0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := getAccount(c, account.SlackUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := stored.ConversationLastArchivedEndTime("C1"); !actual.Equal(endTime) {
+		t.Errorf("C1: got %s", actual)
+	}
+	if actual := stored.ConversationLastArchivedEndTime("C2"); !actual.Equal(endTime.AddDate(0, 0, 1)) {
+		t.Errorf("C2: got %s", actual)
+	}
+	if actual := stored.ConversationLastArchivedEndTime("C3"); !actual.IsZero() {
+		t.Errorf("C3: got %s", actual)
+	}
+}
+
+func TestRecordConversationArchived(t *testing.T) {
+	account := initTestApp(t)
+	c := context.Background()
+	day := func(dayOfMonth int) time.Time {
+		return time.Date(2026, time.March, dayOfMonth, 0, 0, 0, 0, account.TimezoneLocation)
+	}
+	record := func(startDay time.Time, endDay time.Time) time.Time {
+		if err := recordConversationArchived(c, account.SlackUserId, "C1", ArchivePeriod{startDay, endDay}); err != nil {
+			t.Fatal(err)
+		}
+		stored, err := getAccount(c, account.SlackUserId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stored.ConversationLastArchivedEndTime("C1")
+	}
+
+	// The first archive of a conversation is always recorded.
+	if actual := record(day(4), day(4)); !actual.Equal(day(5).Add(-time.Second)) {
+		t.Errorf("First archive: got %s", actual)
+	}
+	// The 5th's archive (e.g. its task failed) is still missing, so the 6th's
+	// doesn't move the time past it.
+	if actual := record(day(6), day(6)); !actual.Equal(day(5).Add(-time.Second)) {
+		t.Errorf("Archive after a missing one: got %s", actual)
+	}
+	if actual := record(day(5), day(5)); !actual.Equal(day(6).Add(-time.Second)) {
+		t.Errorf("Missing archive: got %s", actual)
+	}
+	// Once it's caught up on, the 6th's (skipped by the archive log) is too.
+	if actual := record(day(6), day(6)); !actual.Equal(day(7).Add(-time.Second)) {
+		t.Errorf("Caught up archive: got %s", actual)
+	}
+	// Periods continue from the last archived day even if the timezone
+	// changed in between.
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+	londonDay := time.Date(2026, time.March, 8, 0, 0, 0, 0, london)
+	if actual := record(londonDay, londonDay.AddDate(0, 0, 6)); !actual.Equal(londonDay.AddDate(0, 0, 7).Add(-time.Second)) {
+		t.Errorf("Archive in a new timezone: got %s", actual)
+	}
+}
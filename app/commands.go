@@ -0,0 +1,442 @@
+//go:build standalone
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Command-line tools for the standalone server, run as
+// "slack-archive <command> [flags]" instead of serving. Export commands use the
+// server's config and database, the archive command only needs a Slack token.
+
+type Command struct {
+	Name        string
+	Description string
+	Run         func(args []string) error
+}
+
+var commands = []*Command{
+	{"archive", "Archive a conversation with a Slack token (without an account)", archiveCommand},
+	{"export-mbox", "Export a conversation's archives as an mbox file", exportMboxCommand},
+	{"export-site", "Export all conversations as a static HTML site", exportSiteCommand},
+	{"export-slack", "Export all conversations in Slack's JSON export format", exportSlackCommand},
+	{"export-markdown", "Export all conversations as Markdown files", exportMarkdownCommand},
+}
+
+// runCommand returns false if args don't name a command, in which case the
+// server should be run instead.
+func runCommand(args []string) (bool, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return false, nil
+	}
+	for _, command := range commands {
+		if command.Name == args[0] {
+			return true, command.Run(args[1:])
+		}
+	}
+	var usage strings.Builder
+	fmt.Fprintf(&usage, "Unknown command: %s. Commands are:", args[0])
+	for _, command := range commands {
+		fmt.Fprintf(&usage, "\n  %s: %s", command.Name, command.Description)
+	}
+	return true, errors.New(usage.String())
+}
+
+// initAccountCommand sets up commands that use accounts, which need the
+// server's config and database.
+func initAccountCommand() {
+	initPlatform()
+	initRendering()
+	sessionStore, sessionConfig = initSession()
+	fileUrlRefEncryptionKey = loadFileUrlRefEncryptionKey()
+}
+
+// initTokenCommand sets up the archive command, which only needs a token (so
+// it works without the server's config, or while the server has the database
+// open). Nothing that it stores is kept.
+func initTokenCommand() error {
+	initRendering()
+	accountStore = newMemoryAccountStore()
+	archiveLog = newMemoryArchiveLogStore()
+	messageStore = newMemoryMessageStore()
+	cache = newMemoryCache()
+	// Thumbnails link to the server's proxy, which only works for signed in
+	// accounts, but the links still need a key to be rendered.
+	fileUrlRefEncryptionKey = make([]byte, 32)
+	_, err := rand.Read(fileUrlRefEncryptionKey)
+	return err
+}
+
+// Formats that the archive command can output.
+var archiveCommandFormats = []string{"html", "eml", "mbox", "json", "markdown"}
+
+// archiveCommand renders a conversation's archive for a one-off use (e.g.
+// scripting, debugging rendering, or keeping a copy of a channel before it's
+// deleted), using a token directly instead of a signed in account.
+func archiveCommand(args []string) error {
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	token := flags.String("token", os.Getenv("SLACK_TOKEN"), "Slack user token to archive with (defaults to $SLACK_TOKEN)")
+	conversationRef := flags.String("conversation", "", "Conversation to archive, as <type>/<ref> (as in archive URLs)")
+	startDate := flags.String("start", "", "First day to archive (YYYY-MM-DD, defaults to yesterday)")
+	endDate := flags.String("end", "", "Last day to archive (YYYY-MM-DD, defaults to the first day)")
+	format := flags.String("format", "html", "Output format: "+strings.Join(archiveCommandFormats, ", "))
+	timezone := flags.String("timezone", "", "Timezone that days are in (defaults to the user's Slack timezone)")
+	outputPath := flags.String("output", "", "File to write to (defaults to standard output)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *token == "" || *conversationRef == "" {
+		flags.Usage()
+		return errors.New("-token (or $SLACK_TOKEN) and -conversation are required")
+	}
+	if !containsString(archiveCommandFormats, *format) {
+		return fmt.Errorf("Unknown format: %s", *format)
+	}
+
+	if err := initTokenCommand(); err != nil {
+		return err
+	}
+	c := context.Background()
+	account, err := newTokenAccount(c, *token, *timezone)
+	if err != nil {
+		return err
+	}
+	slackClient := account.NewSlackClient(c)
+	conversationType, ref, ok := strings.Cut(*conversationRef, "/")
+	if !ok {
+		return fmt.Errorf("Malformed conversation: %s", *conversationRef)
+	}
+	conversation, err := getConversationFromRef(conversationType, ref, slackClient)
+	if err != nil {
+		return err
+	}
+	startDay, endDay := previousArchiveDay(account), previousArchiveDay(account)
+	if *startDate != "" {
+		if *endDate == "" {
+			endDate = startDate
+		}
+		startDay, endDay, err = parseExportDays(*startDate, *endDate, account)
+		if err != nil {
+			return err
+		}
+	} else if *endDate != "" {
+		return errors.New("-end requires -start")
+	}
+
+	output, closeOutput, err := openOutput(*outputPath)
+	if err != nil {
+		return err
+	}
+	if err := writeConversationArchive(output, *format, conversation, account, startDay, endDay, c); err != nil {
+		closeOutput()
+		return err
+	}
+	return closeOutput()
+}
+
+// openOutput writes to standard output if outputPath is empty. The returned
+// function flushes the output and closes the file.
+func openOutput(outputPath string) (io.Writer, func() error, error) {
+	if outputPath == "" {
+		buffered := bufio.NewWriter(os.Stdout)
+		return buffered, buffered.Flush, nil
+	}
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	buffered := bufio.NewWriter(file)
+	return buffered, func() error {
+		if err := buffered.Flush(); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}, nil
+}
+
+// newTokenAccount returns an (unsaved) account for the user that the token
+// belongs to.
+func newTokenAccount(c context.Context, token string, timezone string) (*Account, error) {
+	account := &Account{ApiToken: token, TimezoneName: timezone}
+	slackClient := account.NewSlackClient(c)
+	authTest, err := slackClient.AuthTest()
+	if err != nil {
+		return nil, fmt.Errorf("Could not check token: %w", err)
+	}
+	account.SlackUserId = authTest.UserID
+	account.SlackTeamName = authTest.Team
+	account.SlackTeamUrl = authTest.URL
+	if account.TimezoneName == "" {
+		user, err := slackClient.GetUserInfo(authTest.UserID)
+		if err != nil {
+			return nil, err
+		}
+		account.TimezoneName = user.TZ
+	}
+	return account, initAccount(account)
+}
+
+func writeConversationArchive(w io.Writer, format string, conversation Conversation, account *Account, startDay time.Time, endDay time.Time, c context.Context) error {
+	slackClient := account.NewSlackClient(c)
+	switch format {
+	case "mbox":
+		_, err := exportConversationMbox(w, conversation, account, startDay, endDay, false, c)
+		return err
+	case "json":
+		// Messages as returned by the Slack API (the same as in the day files
+		// of Slack exports).
+		messages, err := getConversationMessages(
+			conversation, slackClient, startDay, endDay.AddDate(0, 0, 1).Add(-time.Second))
+		if err != nil {
+			return err
+		}
+		messagesJson, err := json.MarshalIndent(messages, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(messagesJson, '\n'))
+		return err
+	}
+	archive, err := newConversationArchiveForDays(conversation, slackClient, account, startDay, endDay)
+	if err != nil {
+		return err
+	}
+	if format == "markdown" {
+		_, err := io.WriteString(w, archive.Markdown(nil))
+		return err
+	}
+	team, err := slackClient.GetTeamInfo()
+	if err != nil {
+		return err
+	}
+	emailAddress := ""
+	if format == "eml" {
+		emailAddress, err = account.GetDigestEmailAddress(slackClient)
+		if err != nil {
+			return err
+		}
+	}
+	// One-off archives aren't part of a thread of earlier ones.
+	message, err := newConversationArchiveMailMessage(archive, account, team, emailAddress, "", c)
+	if err != nil {
+		return err
+	}
+	if format == "html" {
+		_, err := io.WriteString(w, message.HTMLBody)
+		return err
+	}
+	messageBytes, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(messageBytes)
+	return err
+}
+
+func exportMboxCommand(args []string) error {
+	flags := flag.NewFlagSet("export-mbox", flag.ContinueOnError)
+	slackUserId := flags.String("user", "", "Slack user ID of the account to export with")
+	conversationRef := flags.String("conversation", "", "Conversation to export, as <type>/<ref> (as in archive URLs)")
+	startDate := flags.String("start", "", "First day to export (YYYY-MM-DD)")
+	endDate := flags.String("end", "", "Last day to export (YYYY-MM-DD)")
+	perThread := flags.Bool("per-thread", false, "Export each thread as its own message, instead of each day")
+	outputPath := flags.String("output", "", "File to write to (defaults to standard output)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *slackUserId == "" || *conversationRef == "" || *startDate == "" || *endDate == "" {
+		flags.Usage()
+		return errors.New("-user, -conversation, -start and -end are required")
+	}
+
+	initAccountCommand()
+	c := context.Background()
+	account, err := getAccount(c, *slackUserId)
+	if err != nil {
+		return fmt.Errorf("Could not load account %s: %w", *slackUserId, err)
+	}
+	conversationType, ref, ok := strings.Cut(*conversationRef, "/")
+	if !ok {
+		return fmt.Errorf("Malformed conversation: %s", *conversationRef)
+	}
+	conversation, err := getConversationFromRef(conversationType, ref, account.NewSlackClient(c))
+	if err != nil {
+		return err
+	}
+	startDay, endDay, err := parseExportDays(*startDate, *endDate, account)
+	if err != nil {
+		return err
+	}
+
+	output, closeOutput, err := openOutput(*outputPath)
+	if err != nil {
+		return err
+	}
+	messageCount, err := exportConversationMbox(output, conversation, account, startDay, endDay, *perThread, c)
+	if err != nil {
+		closeOutput()
+		return err
+	}
+	if err := closeOutput(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d messages from %s\n", messageCount, conversation.Name())
+	return nil
+}
+
+func exportSiteCommand(args []string) error {
+	flags := flag.NewFlagSet("export-site", flag.ContinueOnError)
+	slackUserId := flags.String("user", "", "Slack user ID of the account to export with")
+	startDate := flags.String("start", "", "First day to export (YYYY-MM-DD)")
+	endDate := flags.String("end", "", "Last day to export (YYYY-MM-DD)")
+	outputPath := flags.String("output", "", "Directory to write the site to, or a .zip file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *slackUserId == "" || *startDate == "" || *endDate == "" || *outputPath == "" {
+		flags.Usage()
+		return errors.New("-user, -start, -end and -output are required")
+	}
+
+	initAccountCommand()
+	c := context.Background()
+	account, err := getAccount(c, *slackUserId)
+	if err != nil {
+		return fmt.Errorf("Could not load account %s: %w", *slackUserId, err)
+	}
+	startDay, endDay, err := parseExportDays(*startDate, *endDate, account)
+	if err != nil {
+		return err
+	}
+
+	writer, closeWriter, err := openExportWriter(*outputPath)
+	if err != nil {
+		return err
+	}
+	conversationCount, err := exportSite(writer, account, startDay, endDay, c)
+	if err != nil {
+		closeWriter()
+		return err
+	}
+	if err := closeWriter(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d conversations to %s\n", conversationCount, *outputPath)
+	return nil
+}
+
+func exportSlackCommand(args []string) error {
+	flags := flag.NewFlagSet("export-slack", flag.ContinueOnError)
+	slackUserId := flags.String("user", "", "Slack user ID of the account to export with")
+	startDate := flags.String("start", "", "First day to export (YYYY-MM-DD)")
+	endDate := flags.String("end", "", "Last day to export (YYYY-MM-DD)")
+	outputPath := flags.String("output", "", "Directory to write the export to, or a .zip file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *slackUserId == "" || *startDate == "" || *endDate == "" || *outputPath == "" {
+		flags.Usage()
+		return errors.New("-user, -start, -end and -output are required")
+	}
+
+	initAccountCommand()
+	c := context.Background()
+	account, err := getAccount(c, *slackUserId)
+	if err != nil {
+		return fmt.Errorf("Could not load account %s: %w", *slackUserId, err)
+	}
+	startDay, endDay, err := parseExportDays(*startDate, *endDate, account)
+	if err != nil {
+		return err
+	}
+
+	writer, closeWriter, err := openExportWriter(*outputPath)
+	if err != nil {
+		return err
+	}
+	messageCount, err := exportSlack(writer, account, startDay, endDay, c)
+	if err != nil {
+		closeWriter()
+		return err
+	}
+	if err := closeWriter(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d messages to %s\n", messageCount, *outputPath)
+	return nil
+}
+
+func exportMarkdownCommand(args []string) error {
+	flags := flag.NewFlagSet("export-markdown", flag.ContinueOnError)
+	slackUserId := flags.String("user", "", "Slack user ID of the account to export with")
+	startDate := flags.String("start", "", "First day to export (YYYY-MM-DD)")
+	endDate := flags.String("end", "", "Last day to export (YYYY-MM-DD)")
+	perDay := flags.Bool("per-day", false, "Write a file per day for each conversation, instead of one per conversation")
+	outputPath := flags.String("output", "", "Directory to write the export to, or a .zip file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *slackUserId == "" || *startDate == "" || *endDate == "" || *outputPath == "" {
+		flags.Usage()
+		return errors.New("-user, -start, -end and -output are required")
+	}
+
+	initAccountCommand()
+	c := context.Background()
+	account, err := getAccount(c, *slackUserId)
+	if err != nil {
+		return fmt.Errorf("Could not load account %s: %w", *slackUserId, err)
+	}
+	startDay, endDay, err := parseExportDays(*startDate, *endDate, account)
+	if err != nil {
+		return err
+	}
+
+	writer, closeWriter, err := openExportWriter(*outputPath)
+	if err != nil {
+		return err
+	}
+	conversationCount, err := exportMarkdown(writer, account, startDay, endDay, *perDay, MarkdownExportFilesMaxBytes, c)
+	if err != nil {
+		closeWriter()
+		return err
+	}
+	if err := closeWriter(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d conversations to %s\n", conversationCount, *outputPath)
+	return nil
+}
+
+// openExportWriter writes to a directory, or to a zip file if outputPath ends
+// in .zip. The returned function finishes the export.
+func openExportWriter(outputPath string) (ExportWriter, func() error, error) {
+	if !strings.HasSuffix(outputPath, ".zip") {
+		return &DirectoryExportWriter{outputPath}, func() error { return nil }, nil
+	}
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	exportZip := zip.NewWriter(file)
+	return &ZipExportWriter{exportZip}, func() error {
+		if err := exportZip.Close(); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}, nil
+}
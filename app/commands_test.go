@@ -0,0 +1,114 @@
+//go:build standalone
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommand(t *testing.T) {
+	for _, args := range [][]string{nil, {"-flag"}} {
+		if ran, err := runCommand(args); ran || err != nil {
+			t.Errorf("%v: expected the server to run, got %v, %v", args, ran, err)
+		}
+	}
+	ran, err := runCommand([]string{"export-everything"})
+	if !ran || err == nil || !strings.Contains(err.Error(), "export-mbox") {
+		t.Errorf("Expected an unknown command error listing commands, got %v, %v", ran, err)
+	}
+	ran, err = runCommand([]string{"export-mbox", "-user", "U1"})
+	if !ran || err == nil || !strings.Contains(err.Error(), "required") {
+		t.Errorf("Expected a missing flags error, got %v, %v", ran, err)
+	}
+	ran, err = runCommand([]string{"archive", "-token=", "-conversation", "channel/C1"})
+	if !ran || err == nil || !strings.Contains(err.Error(), "required") {
+		t.Errorf("Expected a missing token error, got %v, %v", ran, err)
+	}
+	ran, err = runCommand([]string{"archive", "-token", "xoxp-1", "-conversation", "channel/C1", "-format", "pdf"})
+	if !ran || err == nil || !strings.Contains(err.Error(), "Unknown format") {
+		t.Errorf("Expected an unknown format error, got %v, %v", ran, err)
+	}
+}
+
+// What the archive command writes in each format, with an account for the
+// token (instead of a stored one).
+func TestWriteConversationArchive(t *testing.T) {
+	initTestFakeSlack(t)
+	fileUrlRefEncryptionKey = []byte("0123456789abcdef")
+	c := context.Background()
+	account, err := newTokenAccount(c, FakeSlackToken, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.SlackUserId != "U1" || account.TimezoneLocation.String() != "America/Los_Angeles" {
+		t.Errorf("Unexpected account: %+v", account)
+	}
+	conversation, err := getConversationFromRef("channel", "C1", account.NewSlackClient(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, time.March, 4, 0, 0, 0, 0, account.TimezoneLocation)
+	for _, test := range []struct {
+		format   string
+		expected []string
+	}{
+		{"html", []string{"Good morning <b>team</b>", "deploybot"}},
+		{"eml", []string{"Subject: #general Archive", "To: <me@example.com>", "Content-Type: multipart/alternative"}},
+		{"mbox", []string{"From ", "Subject: #general Archive"}},
+		{"json", []string{`"text": "Good morning *team* :partyparrot:"`}},
+		{"markdown", []string{"Good morning **team**", "deploybot"}},
+	} {
+		var output bytes.Buffer
+		if err := writeConversationArchive(&output, test.format, conversation, account, day, day, c); err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(output.String(), expected) {
+				t.Errorf("%s: expected %q in %s", test.format, expected, output.String())
+			}
+		}
+		if strings.Contains(output.String(), "The next day") {
+			t.Errorf("%s: messages from the next day should not be included", test.format)
+		}
+	}
+}
+
+func TestOpenExportWriter(t *testing.T) {
+	directory := t.TempDir()
+	writer, closeWriter, err := openExportWriter(filepath.Join(directory, "export.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteFile("channels.json", []byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	if err := closeWriter(); err != nil {
+		t.Fatal(err)
+	}
+	exportZip, err := zip.OpenReader(filepath.Join(directory, "export.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exportZip.Close()
+	if len(exportZip.File) != 1 || exportZip.File[0].Name != "channels.json" {
+		t.Errorf("Unexpected zip contents: %v", exportZip.File)
+	}
+
+	writer, closeWriter, err = openExportWriter(filepath.Join(directory, "export"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := writer.(*DirectoryExportWriter); !ok {
+		t.Errorf("Expected a directory writer, got %T", writer)
+	}
+	if err := closeWriter(); err != nil {
+		t.Fatal(err)
+	}
+}
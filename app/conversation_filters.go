@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+)
+
+// Controls which of the account's conversations are archived (in addition to
+// Account.DirectMessagesOnly, which is applied when fetching conversations).
+// Explicit per-conversation choices take precedence over the rules.
+type ConversationFilterConfig struct {
+	IncludedIds []string
+	ExcludedIds []string
+	// Channels (public or private) whose name starts with any of these are
+	// excluded, e.g. "alerts-".
+	ExcludedPrefixes []string
+	// Skip archives where every message was posted by a bot. Since this
+	// depends on the messages, it's only checked when the archive is sent.
+	ExcludeBotOnly bool
+}
+
+// Whether a conversation is archived, and why.
+type ConversationFilterState struct {
+	Conversation Conversation
+	Included     bool
+	// Set if the user chose to include or exclude the conversation, instead
+	// of it following the rules.
+	Explicit bool
+	// Human-readable explanation of why a conversation is excluded.
+	ExclusionReason string
+}
+
+func (filters *ConversationFilterConfig) State(conversation Conversation) *ConversationFilterState {
+	state := &ConversationFilterState{Conversation: conversation, Included: true}
+	id := conversation.Id()
+	if containsString(filters.IncludedIds, id) {
+		state.Explicit = true
+		return state
+	}
+	if containsString(filters.ExcludedIds, id) {
+		state.Included = false
+		state.Explicit = true
+		state.ExclusionReason = "Excluded"
+		return state
+	}
+	if name, ok := channelName(conversation); ok {
+		for _, prefix := range filters.ExcludedPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				state.Included = false
+				state.ExclusionReason = "Excluded by the \"" + prefix + "\" prefix"
+				return state
+			}
+		}
+	}
+	return state
+}
+
+func (filters *ConversationFilterConfig) Includes(conversation Conversation) bool {
+	return filters.State(conversation).Included
+}
+
+// ExcludesArchive returns whether an archive of an included conversation
+// should still not be sent because of its contents.
+func (filters *ConversationFilterConfig) ExcludesArchive(archive *ConversationArchive) bool {
+	if !filters.ExcludeBotOnly || containsString(filters.IncludedIds, archive.Conversation.Id()) {
+		return false
+	}
+	return archive.BotOnly()
+}
+
+// SetIncluded records an explicit choice for a conversation, an empty value
+// removes it (so that the conversation follows the rules again).
+func (filters *ConversationFilterConfig) SetIncluded(conversationId string, included string) {
+	filters.IncludedIds = removeString(filters.IncludedIds, conversationId)
+	filters.ExcludedIds = removeString(filters.ExcludedIds, conversationId)
+	switch included {
+	case "true":
+		filters.IncludedIds = append(filters.IncludedIds, conversationId)
+	case "false":
+		filters.ExcludedIds = append(filters.ExcludedIds, conversationId)
+	}
+}
+
+// parseChannelPrefixes splits a comma or whitespace-separated list of
+// prefixes (which may be written with a leading #).
+func parseChannelPrefixes(value string) []string {
+	prefixes := make([]string, 0)
+	for _, prefix := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	}) {
+		prefix = strings.TrimPrefix(prefix, "#")
+		if prefix != "" && !containsString(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// includedConversations returns the subset of conversations that the account
+// wants archived.
+func includedConversations(conversations []Conversation, account *Account) []Conversation {
+	included := make([]Conversation, 0, len(conversations))
+	for _, conversation := range conversations {
+		if account.ConversationFilters.Includes(conversation) {
+			included = append(included, conversation)
+		}
+	}
+	return included
+}
+
+func channelName(conversation Conversation) (string, bool) {
+	switch c := conversation.(type) {
+	case *ChannelConversation:
+		return c.channel.Name, true
+	case *PrivateChannelConversation:
+		return c.channel.Name, true
+	}
+	return "", false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(values []string, value string) []string {
+	result := values[:0]
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func newTestChannelConversation(id string, name string) *ChannelConversation {
+	channel := &slack.Channel{}
+	channel.ID = id
+	channel.Name = name
+	return &ChannelConversation{channel}
+}
+
+func TestConversationFilterState(t *testing.T) {
+	filters := &ConversationFilterConfig{
+		IncludedIds:      []string{"C3"},
+		ExcludedIds:      []string{"C2"},
+		ExcludedPrefixes: []string{"alerts-"},
+	}
+	dm := &slack.Channel{}
+	dm.ID = "D1"
+	for _, test := range []struct {
+		conversation Conversation
+		included     bool
+		explicit     bool
+	}{
+		{newTestChannelConversation("C1", "general"), true, false},
+		{newTestChannelConversation("C2", "random"), false, true},
+		{newTestChannelConversation("C3", "alerts-important"), true, true},
+		{newTestChannelConversation("C4", "alerts-prod"), false, false},
+		{&PrivateChannelConversation{&slack.Channel{GroupConversation: slack.GroupConversation{Name: "alerts-secret"}}}, false, false},
+		{&DirectMessageConversation{dm, &slack.User{ID: "U2", Name: "alerts-bot"}}, true, false},
+	} {
+		state := filters.State(test.conversation)
+		if state.Included != test.included || state.Explicit != test.explicit {
+			t.Errorf("%s: got %+v", test.conversation.Name(), state)
+		}
+		if state.Included != (state.ExclusionReason == "") {
+			t.Errorf("%s: unexpected exclusion reason %q", test.conversation.Name(), state.ExclusionReason)
+		}
+	}
+
+	filters.SetIncluded("C4", "true")
+	filters.SetIncluded("C3", "false")
+	filters.SetIncluded("C2", "")
+	if !reflect.DeepEqual(filters.IncludedIds, []string{"C4"}) || !reflect.DeepEqual(filters.ExcludedIds, []string{"C3"}) {
+		t.Errorf("Unexpected explicit choices: %v and %v", filters.IncludedIds, filters.ExcludedIds)
+	}
+}
+
+func TestConversationFilterExcludesArchive(t *testing.T) {
+	newArchive := func(id string, subTypes ...string) *ConversationArchive {
+		groups := make([]*MessageGroup, 0)
+		for _, subType := range subTypes {
+			message := newTestMessage(nil, slack.Msg{SubType: subType})
+			groups = append(groups, &MessageGroup{Messages: []*Message{message}})
+		}
+		return &ConversationArchive{
+			Conversation:  newTestChannelConversation(id, "alerts"),
+			MessageGroups: groups,
+		}
+	}
+	filters := &ConversationFilterConfig{ExcludeBotOnly: true, IncludedIds: []string{"C2"}}
+	for _, test := range []struct {
+		archive  *ConversationArchive
+		excluded bool
+	}{
+		{newArchive("C1", "bot_message", "bot_message"), true},
+		{newArchive("C1", "bot_message", ""), false},
+		{newArchive("C1"), false},
+		{newArchive("C2", "bot_message"), false},
+	} {
+		if actual := filters.ExcludesArchive(test.archive); actual != test.excluded {
+			t.Errorf("%s with %d groups: got %t", test.archive.Conversation.Id(), len(test.archive.MessageGroups), actual)
+		}
+	}
+	filters.ExcludeBotOnly = false
+	if filters.ExcludesArchive(newArchive("C1", "bot_message")) {
+		t.Errorf("Bot-only archives should only be excluded if the rule is set")
+	}
+}
+
+func TestParseChannelPrefixes(t *testing.T) {
+	actual := parseChannelPrefixes(" #alerts-, bot-  deploys,alerts-,")
+	if expected := []string{"alerts-", "bot-", "deploys"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Got %v, want %v", actual, expected)
+	}
+}
+
+func TestSaveConversationIncludedHandler(t *testing.T) {
+	account := initTestApp(t)
+	form := url.Values{"conversation_id": {"C1"}, "included": {"false"}}
+	r, w, state := newTestSignedInRequest(t, "POST", "/archive/conversation/included", form, account)
+	e := saveConversationIncludedHandler(w, r, state)
+	if e == nil || e.Type != AppErrorTypeRedirect || e.Message != "/" {
+		t.Fatalf("Expected redirect to index, got %+v", e)
+	}
+	stored, err := getAccount(context.Background(), account.SlackUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored.ConversationFilters.ExcludedIds, []string{"C1"}) {
+		t.Errorf("Exclusion not saved: %+v", stored.ConversationFilters)
+	}
+
+	form = url.Values{"conversation_id": {"C1"}, "included": {"maybe"}}
+	r, w, state = newTestSignedInRequest(t, "POST", "/archive/conversation/included", form, account)
+	if e := saveConversationIncludedHandler(w, r, state); e == nil || e.Type != AppErrorTypeBadInput {
+		t.Errorf("Expected a bad request, got %+v", e)
+	}
+}
+
+func TestIndexConversationTemplate(t *testing.T) {
+	initTestApp(t)
+	templates := loadTemplates()
+	filters := &ConversationFilterConfig{ExcludedPrefixes: []string{"alerts-"}}
+	var buffer bytes.Buffer
+	state := filters.State(newTestChannelConversation("C1", "alerts-prod"))
+	if err := templates["index"].ExecuteTemplate(&buffer, "conversation", state); err != nil {
+		t.Fatal(err)
+	}
+	html := buffer.String()
+	for _, expected := range []string{
+		"class=\"conversation excluded\"",
+		"value=\"C1\"",
+		"Excluded by the &#34;alerts-&#34; prefix",
+		"value=\"true\" class=\"inline\">include",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected %q in %s", expected, html)
+		}
+	}
+}
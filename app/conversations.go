@@ -13,7 +13,11 @@ import (
 )
 
 const (
-	ConversationArchiveDateFormat = "January 2, 2006"
+	ConversationArchiveDateFormat    = "January 2, 2006"
+	ConversationArchiveDayDateFormat = "Monday, January 2"
+	// Used to identify the day that an archive covers in task arguments and
+	// forms.
+	ArchiveDayFormat = "2006-01-02"
 )
 
 func conversationArchiveUrl(c Conversation) string {
@@ -212,10 +216,7 @@ func (c *MultiPartyDirectMessageConversation) loadUsersWithLookup(slackClient *s
 	if err != nil {
 		return err
 	}
-	members, _, err := slackClient.GetUsersInConversation(&slack.GetUsersInConversationParameters{
-		ChannelID: c.mpim.ID,
-		Limit:     100,
-	})
+	members, err := getConversationMembers(c.mpim.ID, slackClient)
 	if err != nil {
 		return err
 	}
@@ -244,6 +245,8 @@ type Conversations struct {
 	PrivateChannels          []Conversation
 	DirectMessages           []Conversation
 	MultiPartyDirectMessages []Conversation
+	// All of the workspace's users, as fetched for the conversations.
+	UserLookup *UserLookup
 }
 
 func getConversationFromRef(conversationType string, ref string, slackClient *slack.Client) (Conversation, error) {
@@ -268,7 +271,7 @@ func getConversations(slackClient *slack.Client, account *Account) (*Conversatio
 	if err != nil {
 		return nil, err
 	}
-	conversations := &Conversations{}
+	conversations := &Conversations{UserLookup: userLookup}
 
 	var conversationTypes []string
 	if account.DirectMessagesOnly {
@@ -280,9 +283,17 @@ func getConversations(slackClient *slack.Client, account *Account) (*Conversatio
 		Limit: 1000,
 		Types: conversationTypes,
 	}
-	slackConversations, _, err := slackClient.GetConversationsForUser(&params)
-	if err != nil {
-		return nil, err
+	slackConversations := make([]slack.Channel, 0)
+	for {
+		pageConversations, cursor, err := slackClient.GetConversationsForUser(&params)
+		if err != nil {
+			return nil, err
+		}
+		slackConversations = append(slackConversations, pageConversations...)
+		if cursor == "" {
+			break
+		}
+		params.Cursor = cursor
 	}
 	conversations.Channels = make([]Conversation, 0)
 	conversations.PrivateChannels = make([]Conversation, 0)
@@ -334,6 +345,19 @@ type ConversationArchive struct {
 	MessageCount  int
 	StartTime     time.Time
 	EndTime       time.Time
+	// Cadence that the archive is being sent with, used to determine the
+	// previous archive for threading. Empty if it's not being sent.
+	Cadence string
+}
+
+// Messages from a single day of a (multi-day) archive.
+type ConversationArchiveDay struct {
+	Date          time.Time
+	MessageGroups []*MessageGroup
+}
+
+func (day *ConversationArchiveDay) DisplayDate() string {
+	return safeFormattedDate(day.Date.Format(ConversationArchiveDayDateFormat))
 }
 
 func (archive *ConversationArchive) Empty() bool {
@@ -346,41 +370,146 @@ func (archive *ConversationArchive) Empty() bool {
 }
 
 func (archive *ConversationArchive) DisplayDate() string {
-	return safeFormattedDate(archive.EndTime.Format(ConversationArchiveDateFormat))
+	return archiveDisplayDate(archive.StartTime, archive.EndTime)
 }
 
-func newConversationArchive(conversation Conversation, slackClient *slack.Client, account *Account, devMode bool) (*ConversationArchive, error) {
-	messages := make([]*slack.Message, 0)
-	now := time.Now().In(account.TimezoneLocation)
-	var archiveStartTime time.Time
-	var archiveEndTime time.Time
-	if !devMode {
-		archiveStartTime = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -1)
-		archiveEndTime = archiveStartTime.AddDate(0, 0, 1).Add(-time.Second)
-	} else {
-		archiveStartTime = now.AddDate(0, 0, -1)
-		archiveEndTime = now
+func archiveDisplayDate(startTime time.Time, endTime time.Time) string {
+	if !isSameDay(startTime, endTime) {
+		return safeFormattedDate(fmt.Sprintf("%s – %s",
+			startTime.Format(ConversationArchiveDateFormat),
+			endTime.Format(ConversationArchiveDateFormat)))
 	}
+	return safeFormattedDate(endTime.Format(ConversationArchiveDateFormat))
+}
 
-	params := slack.GetConversationHistoryParameters{
-		ChannelID: conversation.Id(),
-		Latest:    fmt.Sprintf("%d", archiveEndTime.Unix()),
-		Oldest:    fmt.Sprintf("%d", archiveStartTime.Unix()),
-		Limit:     1000,
-		Inclusive: false,
+// AnchorName is used to link to the archive when it's part of a digest.
+func (archive *ConversationArchive) AnchorName() string {
+	return "conversation-" + archive.Conversation.Id()
+}
+
+// BotOnly returns whether all of the archive's messages were posted by bots.
+func (archive *ConversationArchive) BotOnly() bool {
+	hasMessages := false
+	for _, group := range archive.MessageGroups {
+		if len(group.Messages) == 0 {
+			continue
+		}
+		if !group.FromBot() {
+			return false
+		}
+		hasMessages = true
 	}
-	for {
-		history, err := slackClient.GetConversationHistory(&params)
-		if err != nil {
-			return nil, err
+	return hasMessages
+}
+
+func (archive *ConversationArchive) MultiDay() bool {
+	return !isSameDay(archive.StartTime, archive.EndTime)
+}
+
+// Days returns the archive's message groups split up by the day that they're
+// from (groups never span days). Days without messages are omitted.
+func (archive *ConversationArchive) Days() []*ConversationArchiveDay {
+	days := make([]*ConversationArchiveDay, 0)
+	var currentDay *ConversationArchiveDay
+	for _, group := range archive.MessageGroups {
+		if len(group.Messages) == 0 {
+			continue
 		}
-		for i := range history.Messages {
-			messages = append([]*slack.Message{&history.Messages[i]}, messages...)
+		timestamp := group.Messages[0].TimestampTime()
+		if currentDay == nil || !isSameDay(currentDay.Date, timestamp) {
+			currentDay = &ConversationArchiveDay{
+				Date: time.Date(timestamp.Year(), timestamp.Month(), timestamp.Day(), 0, 0, 0, 0, timestamp.Location()),
+			}
+			days = append(days, currentDay)
 		}
-		if !history.HasMore {
-			break
+		currentDay.MessageGroups = append(currentDay.MessageGroups, group)
+	}
+	return days
+}
+
+// dayArchives splits the archive up into one per day (for the days that have
+// messages).
+func (archive *ConversationArchive) dayArchives() []*ConversationArchive {
+	archives := make([]*ConversationArchive, 0)
+	for _, day := range archive.Days() {
+		messageCount := 0
+		for _, group := range day.MessageGroups {
+			messageCount += len(group.Messages)
 		}
-		params.Latest = history.Messages[len(history.Messages)-1].Timestamp
+		archives = append(archives, &ConversationArchive{
+			Conversation:  archive.Conversation,
+			MessageGroups: day.MessageGroups,
+			MessageCount:  messageCount,
+			StartTime:     day.Date,
+			EndTime:       day.Date.AddDate(0, 0, 1).Add(-time.Second),
+			Cadence:       archive.Cadence,
+		})
+	}
+	return archives
+}
+
+func isSameDay(a time.Time, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+// allMessages returns all of the archive's messages, including thread replies.
+func (archive *ConversationArchive) allMessages() []*Message {
+	return appendGroupMessages(nil, archive.MessageGroups)
+}
+
+func appendGroupMessages(messages []*Message, groups []*MessageGroup) []*Message {
+	for _, group := range groups {
+		for _, message := range group.Messages {
+			messages = append(messages, message)
+			messages = appendGroupMessages(messages, message.ReplyMessageGroups)
+		}
+	}
+	return messages
+}
+
+// currentArchiveDay returns the start of today in the account's timezone.
+// Scheduled archives are sent shortly after it, and cover days before it.
+func currentArchiveDay(account *Account) time.Time {
+	now := time.Now().In(account.TimezoneLocation)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// previousArchiveDay returns the start of yesterday in the account's
+// timezone, which is the last day that archives can cover.
+func previousArchiveDay(account *Account) time.Time {
+	return currentArchiveDay(account).AddDate(0, 0, -1)
+}
+
+// parseArchiveDay parses a ArchiveDayFormat date into the start of that day
+// in the account's timezone.
+func parseArchiveDay(date string, account *Account) (time.Time, error) {
+	return time.ParseInLocation(ArchiveDayFormat, date, account.TimezoneLocation)
+}
+
+func newConversationArchive(conversation Conversation, slackClient *slack.Client, account *Account, devMode bool) (*ConversationArchive, error) {
+	if devMode {
+		now := time.Now().In(account.TimezoneLocation)
+		return newConversationArchiveForRange(conversation, slackClient, account, now.AddDate(0, 0, -1), now)
+	}
+	return newConversationArchiveForDay(conversation, slackClient, account, previousArchiveDay(account))
+}
+
+func newConversationArchiveForDay(conversation Conversation, slackClient *slack.Client, account *Account, day time.Time) (*ConversationArchive, error) {
+	return newConversationArchiveForDays(conversation, slackClient, account, day, day)
+}
+
+// newConversationArchiveForDays returns an archive that covers startDay
+// through endDay (inclusive).
+func newConversationArchiveForDays(conversation Conversation, slackClient *slack.Client, account *Account, startDay time.Time, endDay time.Time) (*ConversationArchive, error) {
+	archiveStartTime := startDay
+	archiveEndTime := endDay.AddDate(0, 0, 1).Add(-time.Second)
+	return newConversationArchiveForRange(conversation, slackClient, account, archiveStartTime, archiveEndTime)
+}
+
+func newConversationArchiveForRange(conversation Conversation, slackClient *slack.Client, account *Account, archiveStartTime time.Time, archiveEndTime time.Time) (*ConversationArchive, error) {
+	messages, err := getConversationHistory(conversation, slackClient, archiveStartTime, archiveEndTime)
+	if err != nil {
+		return nil, err
 	}
 	messageGroups, err := groupMessages(messages, slackClient, account)
 	if err != nil {
@@ -391,27 +520,11 @@ func newConversationArchive(conversation Conversation, slackClient *slack.Client
 		for j := range messageGroup.Messages {
 			message := messageGroup.Messages[j]
 			if message.HasReplies() {
-				replyParams := slack.GetConversationRepliesParameters{
-					ChannelID: conversation.Id(),
-					Timestamp: message.ThreadTimestamp,
-					Latest:    fmt.Sprintf("%d", archiveEndTime.Unix()),
-					Oldest:    fmt.Sprintf("%d", archiveStartTime.Unix()),
-					Limit:     1000,
-					Inclusive: false,
-				}
-				clientReplyMessages, _, _, err := slackClient.GetConversationReplies(&replyParams)
+				replyMessages, err := getThreadReplies(conversation, slackClient, message.ThreadTimestamp, archiveStartTime, archiveEndTime)
 				if err != nil {
 					log.Printf("Could not get replies for %s, continuing: %s", message.ClientMsgID, err)
 					continue
 				}
-				replyMessages := make([]*slack.Message, 0, len(clientReplyMessages))
-				for i := range clientReplyMessages {
-					m := &clientReplyMessages[len(clientReplyMessages)-1-i]
-					if m.Timestamp == m.ThreadTimestamp {
-						continue
-					}
-					replyMessages = append([]*slack.Message{m}, replyMessages...)
-				}
 				replyGroups, err := groupMessages(replyMessages, slackClient, account)
 				if err != nil {
 					log.Printf("Could not group replies for %s, continuing: %s", message.ClientMsgID, err)
@@ -430,3 +543,84 @@ func newConversationArchive(conversation Conversation, slackClient *slack.Client
 		EndTime:       archiveEndTime,
 	}, nil
 }
+
+// getConversationHistory returns the conversation's top-level messages
+// between startTime and endTime (oldest first), following pagination.
+func getConversationHistory(conversation Conversation, slackClient *slack.Client, startTime time.Time, endTime time.Time) ([]*slack.Message, error) {
+	messages := make([]*slack.Message, 0)
+	params := slack.GetConversationHistoryParameters{
+		ChannelID: conversation.Id(),
+		Latest:    fmt.Sprintf("%d", endTime.Unix()),
+		Oldest:    fmt.Sprintf("%d", startTime.Unix()),
+		Limit:     1000,
+		Inclusive: false,
+	}
+	for {
+		history, err := slackClient.GetConversationHistory(&params)
+		if err != nil {
+			return nil, err
+		}
+		for i := range history.Messages {
+			messages = append([]*slack.Message{&history.Messages[i]}, messages...)
+		}
+		if !history.HasMore {
+			break
+		}
+		params.Latest = history.Messages[len(history.Messages)-1].Timestamp
+	}
+	return messages, nil
+}
+
+// getThreadReplies returns the replies (without the parent message) in the
+// thread between startTime and endTime, oldest first, following pagination.
+func getThreadReplies(conversation Conversation, slackClient *slack.Client, threadTimestamp string, startTime time.Time, endTime time.Time) ([]*slack.Message, error) {
+	replyParams := slack.GetConversationRepliesParameters{
+		ChannelID: conversation.Id(),
+		Timestamp: threadTimestamp,
+		Latest:    fmt.Sprintf("%d", endTime.Unix()),
+		Oldest:    fmt.Sprintf("%d", startTime.Unix()),
+		Limit:     1000,
+		Inclusive: false,
+	}
+	replyMessages := make([]*slack.Message, 0)
+	for {
+		clientReplyMessages, hasMore, cursor, err := slackClient.GetConversationReplies(&replyParams)
+		if err != nil {
+			return nil, err
+		}
+		for i := range clientReplyMessages {
+			m := &clientReplyMessages[i]
+			if m.Timestamp == m.ThreadTimestamp {
+				continue
+			}
+			replyMessages = append(replyMessages, m)
+		}
+		if !hasMore || cursor == "" {
+			break
+		}
+		replyParams.Cursor = cursor
+	}
+	return replyMessages, nil
+}
+
+// getConversationMembers returns the IDs of all of the conversation's members,
+// following pagination.
+func getConversationMembers(conversationId string, slackClient *slack.Client) ([]string, error) {
+	members := make([]string, 0)
+	params := slack.GetUsersInConversationParameters{
+		ChannelID: conversationId,
+		Limit:     1000,
+	}
+	for {
+		pageMembers, cursor, err := slackClient.GetUsersInConversation(&params)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, pageMembers...)
+		if cursor == "" {
+			break
+		}
+		params.Cursor = cursor
+	}
+	return members, nil
+}
@@ -0,0 +1,355 @@
+//go:build standalone
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/mail"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// End-to-end tests that fetch conversations from the fake Slack server (see
+// fake_slack_test.go), so they're only in the standalone build.
+
+func initTestFakeSlack(t *testing.T) (*Account, *fakeSlack) {
+	account := initTestApp(t)
+	// Other tests expect inline styles to be left out.
+	previousStyles := styles
+	t.Cleanup(func() { styles = previousStyles })
+	templates = loadTemplates()
+	styles = loadStyles()
+	emojiByShortName = map[string]*Emoji{"+1": {UnicodeCodePointHex: "1F44D"}}
+	return account, newFakeSlack(t, "workspace.json")
+}
+
+func TestGetConversations(t *testing.T) {
+	account, fake := initTestFakeSlack(t)
+	slackClient := account.NewSlackClient(context.Background())
+	conversations, err := getConversations(slackClient, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conversations.AllConversations) != 3 || len(conversations.Channels) != 1 ||
+		len(conversations.MultiPartyDirectMessages) != 1 || len(conversations.DirectMessages) != 1 {
+		t.Fatalf("Unexpected conversations: %+v", conversations)
+	}
+	if name := conversations.Channels[0].Name(); name != "#general" {
+		t.Errorf("Unexpected channel name: %s", name)
+	}
+	if name := conversations.DirectMessages[0].Name(); name != "alice" {
+		t.Errorf("Unexpected DM name: %s", name)
+	}
+	if name := conversations.MultiPartyDirectMessages[0].Name(); !strings.Contains(name, "alice") || !strings.Contains(name, "bob") {
+		t.Errorf("Unexpected group DM name: %s", name)
+	}
+	if len(conversations.UserLookup.usersById) != 3 {
+		t.Errorf("Expected all users to be fetched, got %d", len(conversations.UserLookup.usersById))
+	}
+	// Everything is more than a page, so cursors need to be followed.
+	for _, method := range []string{"users.list", "users.conversations"} {
+		if calls := fake.Calls(method); calls < 2 {
+			t.Errorf("Expected %s to be paginated, got %d calls", method, calls)
+		}
+	}
+}
+
+func TestConversationArchiveEmail(t *testing.T) {
+	account, fake := initTestFakeSlack(t)
+	c := context.Background()
+	slackClient := account.NewSlackClient(c)
+	conversation, err := getConversationFromRef("channel", "C1", slackClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, time.March, 4, 0, 0, 0, 0, account.TimezoneLocation)
+	archive, err := newConversationArchiveForDay(conversation, slackClient, account, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The four top-level messages (the next day's is left out), with the
+	// thread's replies grouped separately.
+	if archive.MessageCount != 4 {
+		t.Errorf("Unexpected message count: %d", archive.MessageCount)
+	}
+	thread := archive.MessageGroups[0].Messages[0]
+	if len(thread.ReplyMessageGroups) != 2 {
+		t.Errorf("Expected replies from two authors, got %d groups", len(thread.ReplyMessageGroups))
+	}
+	if calls := fake.Calls("conversations.history"); calls < 2 {
+		t.Errorf("Expected history to be paginated, got %d calls", calls)
+	}
+	if calls := fake.Calls("conversations.replies"); calls < 2 {
+		t.Errorf("Expected replies to be paginated, got %d calls", calls)
+	}
+
+	team, err := slackClient.GetTeamInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailAddress, err := account.GetDigestEmailAddress(slackClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := newConversationArchiveMailMessage(archive, account, team, emailAddress, "", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sender, err := mail.ParseAddress(message.Sender); err != nil || sender.Name != "Example Slack Archive" ||
+		message.Subject != "#general Archive" || message.To[0] != "me@example.com" {
+		t.Errorf("Unexpected headers: %q to %v from %q", message.Subject, message.To, message.Sender)
+	}
+	for _, expected := range []string{
+		"Good morning <b>team</b>",
+		// Custom emoji come from emoji.list.
+		"https://emoji.example.com/partyparrot.gif",
+		"&#x1F44D;",
+		// Replies, including a quote.
+		"Morning!",
+		"<blockquote",
+		"How was the trip?",
+		// Bot messages are attributed to the bot, with their attachments.
+		"deploybot",
+		"https://deploys.example.com/123",
+		"All checks passed",
+		"Q1 report",
+		"See <a href='https://example.com/docs'",
+		"<b>alice</b>",
+		"https://avatars.example.com/U3.png",
+	} {
+		if !strings.Contains(message.HTMLBody, expected) {
+			t.Errorf("Expected %q in the HTML: %s", expected, message.HTMLBody)
+		}
+	}
+	if strings.Contains(message.HTMLBody, "The next day") {
+		t.Errorf("Messages from the next day should not be included")
+	}
+	for _, expected := range []string{"Good morning *team*", "Deploy finished", "the docs"} {
+		if !strings.Contains(message.Body, expected) {
+			t.Errorf("Expected %q in the plain text: %s", expected, message.Body)
+		}
+	}
+}
+
+func TestConversationArchiveDirectMessage(t *testing.T) {
+	account, _ := initTestFakeSlack(t)
+	slackClient := account.NewSlackClient(context.Background())
+	conversation, err := getConversationFromRef("dm", "D1", slackClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, time.March, 4, 0, 0, 0, 0, account.TimezoneLocation)
+	archive, err := newConversationArchiveForDay(conversation, slackClient, account, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.MessageCount != 1 || archive.MessageGroups[0].Author.ID != "U2" {
+		t.Errorf("Unexpected archive: %+v", archive)
+	}
+
+	if _, err := getConversationFromRef("channel", "C404", slackClient); err == nil {
+		t.Errorf("Expected an error for an unknown channel")
+	}
+}
+
+// Mailer that keeps the messages that it's asked to send.
+type testMailer struct {
+	messages []*MailMessage
+}
+
+func (m *testMailer) Send(c context.Context, message *MailMessage) error {
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func TestSendConversationArchiveThreading(t *testing.T) {
+	account, _ := initTestFakeSlack(t)
+	previousMailer := mailer
+	t.Cleanup(func() { mailer = previousMailer })
+	sentMailer := &testMailer{}
+	mailer = sentMailer
+	c := context.Background()
+	slackClient := account.NewSlackClient(c)
+	conversation, err := getConversationFromRef("channel", "C1", slackClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The 3rd has no messages, so it's not sent and the 4th's archive is the
+	// first in the thread. The 5th's replies to it, even though the 6th's
+	// (also empty) was attempted in between.
+	for _, dayOfMonth := range []int{3, 4, 6, 5} {
+		day := time.Date(2026, time.March, dayOfMonth, 0, 0, 0, 0, account.TimezoneLocation)
+		if _, err := sendConversationArchive(conversation, account, CadenceDaily, ArchivePeriod{day, day}, false, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sentMailer.messages) != 2 {
+		t.Fatalf("Expected two archives to be sent, got %d", len(sentMailer.messages))
+	}
+	first, second := sentMailer.messages[0], sentMailer.messages[1]
+	rootMessageId := "<C1.T1@slack-archive.appspotmail.com>"
+	if first.Headers.Get("In-Reply-To") != rootMessageId {
+		t.Errorf("Expected the first archive to reply to the root, got %q", first.Headers.Get("In-Reply-To"))
+	}
+	if second.Headers.Get("In-Reply-To") != first.MessageId ||
+		second.Headers.Get("References") != rootMessageId+" "+first.MessageId {
+		t.Errorf("Expected the second archive to reply to %s, got %v", first.MessageId, second.Headers)
+	}
+
+	// Resending gives the archive a new Message-ID (mail servers would drop
+	// it otherwise), and later archives reply to that one.
+	day := time.Date(2026, time.March, 4, 0, 0, 0, 0, account.TimezoneLocation)
+	if _, err := sendConversationArchive(conversation, account, CadenceDaily, ArchivePeriod{day, day}, true, c); err != nil {
+		t.Fatal(err)
+	}
+	resent := sentMailer.messages[2]
+	if resent.MessageId == first.MessageId || !strings.HasSuffix(resent.Headers.Get("References"), " "+first.MessageId) {
+		t.Errorf("Unexpected resent archive %s with %v", resent.MessageId, resent.Headers)
+	}
+	if previousMessageId, err := previousArchiveMessageId(c, account.SlackUserId, "C1", "2026-03-05"); err != nil || previousMessageId != resent.MessageId {
+		t.Errorf("Expected the resent archive to be logged, got %q, %v", previousMessageId, err)
+	}
+}
+
+func TestSendConversationArchiveExcludedNotStored(t *testing.T) {
+	account, fake := initTestFakeSlack(t)
+	c := context.Background()
+	// Only the deploy bot's message is left on the 4th.
+	botMessages := make([]*fakeSlackMessage, 0)
+	for _, message := range fake.messages["C1"] {
+		if message.Timestamp == "1772643600.000400" {
+			botMessages = append(botMessages, message)
+		}
+	}
+	fake.messages["C1"] = botMessages
+	account.ConversationFilters.ExcludeBotOnly = true
+	conversation, err := getConversationFromRef("channel", "C1", account.NewSlackClient(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, time.March, 4, 0, 0, 0, 0, account.TimezoneLocation)
+	sent, err := sendConversationArchive(conversation, account, CadenceDaily, ArchivePeriod{day, day}, false, c)
+	if err != nil || sent {
+		t.Fatalf("Expected the bot-only archive to be excluded, got %v, %v", sent, err)
+	}
+	messages, err := messageStore.Search(c, account.SlackUserId, &MessageSearchQuery{Text: "deploy"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("Excluded archive's messages were stored: %+v", messages)
+	}
+}
+
+func TestBackfillConversationsHandler(t *testing.T) {
+	account, _ := initTestFakeSlack(t)
+	r, w, state := newTestSignedInRequest(t, "GET", "/account/backfill/conversations", url.Values{}, account)
+	state.SlackClient = account.NewSlackClient(context.Background())
+	if e := backfillConversationsHandler(w, r, state); e != nil {
+		t.Fatal(e)
+	}
+	var conversations []map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&conversations); err != nil {
+		t.Fatal(err)
+	}
+	if len(conversations) != 3 || conversations[0]["Value"] == "" || conversations[0]["Name"] == "" {
+		t.Errorf("Unexpected conversations: %v", conversations)
+	}
+}
+
+func TestStartBackfillKeepsArchiveState(t *testing.T) {
+	account := initTestApp(t)
+	c := context.Background()
+	t.Cleanup(func() {
+		for len(localTasks) > 0 {
+			<-localTasks
+		}
+	})
+	// Recorded by an archive task after the request's account was loaded.
+	lastArchivedEndTime := time.Date(2026, time.March, 4, 0, 0, 0, 0, time.UTC)
+	if err := recordScheduledArchiveRun(c, account.SlackUserId, lastArchivedEndTime); err != nil {
+		t.Fatal(err)
+	}
+	backfill := &BackfillState{StartDate: "2026-01-01", EndDate: "2026-01-31", NextDate: "2026-01-01"}
+	if err := startBackfill(c, account, backfill); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := getAccount(c, account.SlackUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Backfill != *backfill {
+		t.Errorf("Backfill not saved: %+v", stored.Backfill)
+	}
+	if !stored.LastArchivedEndTime.Equal(lastArchivedEndTime) {
+		t.Errorf("Concurrent archive state was overwritten: %s", stored.LastArchivedEndTime)
+	}
+}
+
+func TestSendArchiveDigestThreading(t *testing.T) {
+	account, _ := initTestFakeSlack(t)
+	previousMailer := mailer
+	t.Cleanup(func() { mailer = previousMailer })
+	sentMailer := &testMailer{}
+	mailer = sentMailer
+	c := context.Background()
+
+	// The digest on the 7th (covering the 6th) is empty and the one on the
+	// 8th is weekly after a cadence change, but it still replies to the last
+	// one that was sent, on the 5th.
+	for _, dayOfMonth := range []int{5, 7, 8} {
+		day := time.Date(2026, time.March, dayOfMonth, 0, 0, 0, 0, account.TimezoneLocation)
+		if dayOfMonth == 8 {
+			account.Cadence = CadenceWeekly
+			account.CadenceWeekday = time.Sunday
+		}
+		if _, err := sendArchiveDigest(account, day, false, false, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sentMailer.messages) != 2 {
+		t.Fatalf("Expected two digests to be sent, got %d", len(sentMailer.messages))
+	}
+	first, second := sentMailer.messages[0], sentMailer.messages[1]
+	if second.Headers.Get("In-Reply-To") != first.MessageId {
+		t.Errorf("Expected the second digest to reply to %s, got %v", first.MessageId, second.Headers)
+	}
+}
+
+func TestSendScheduledArchiveCatchUpLimit(t *testing.T) {
+	account, _ := initTestFakeSlack(t)
+	c := context.Background()
+	conversations, err := getConversations(account.NewSlackClient(c), account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every conversation is a year behind, which is far more archives than a
+	// run sends.
+	lastEndTime := currentArchiveDay(account).AddDate(-1, 0, 0)
+	for _, conversation := range conversations.AllConversations {
+		account.setConversationLastArchivedEndTime(conversation.Id(), lastEndTime)
+	}
+	if err := account.Put(c); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for len(localTasks) > 0 {
+			<-localTasks
+		}
+	})
+	if err := sendScheduledArchive(c, account.SlackUserId); err != nil {
+		t.Fatal(err)
+	}
+	if len(localTasks) != CatchUpMaxArchivesPerRun {
+		t.Fatalf("Expected %d archives to be enqueued, got %d", CatchUpMaxArchivesPerRun, len(localTasks))
+	}
+	// The oldest ones are sent first.
+	task := <-localTasks
+	if startDate := task.args[5].String(); startDate != nextArchiveDay(lastEndTime, account.TimezoneLocation).Format(ArchiveDayFormat) {
+		t.Errorf("Unexpected first archive start date: %s", startDate)
+	}
+}
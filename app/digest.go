@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// All of the (non-empty) conversation archives that are due on a day, sent as
+// a single email for accounts that have CombinedDigest set.
+type ArchiveDigest struct {
+	ConversationArchives []*ConversationArchive
+	// Start of the day that the digest is sent on, in the account's timezone.
+	Day time.Time
+	// End times of all of the archives that were checked (including empty
+	// ones), keyed by conversation ID.
+	archivedEndTimes map[string]time.Time
+}
+
+// newArchiveDigest builds the archives of the account's conversations
+// (respecting DirectMessagesOnly and ConversationFilters) for the digest sent
+// on day. If onlyDue is set, conversations whose cadence doesn't have an
+// archive due on day are skipped, otherwise the most recent period of each
+// cadence is included.
+func newArchiveDigest(slackClient *slack.Client, account *Account, day time.Time, onlyDue bool) (*ArchiveDigest, error) {
+	conversations, err := getConversations(slackClient, account)
+	if err != nil {
+		return nil, err
+	}
+	digest := &ArchiveDigest{
+		ConversationArchives: make([]*ConversationArchive, 0),
+		Day:                  day,
+		archivedEndTimes:     make(map[string]time.Time),
+	}
+	for _, conversation := range includedConversations(conversations.AllConversations, account) {
+		cadence := account.CadenceForConversation(conversation.Id())
+		if onlyDue && !cadenceDue(cadence, account.CadenceWeekday, day) {
+			continue
+		}
+		startDay, endDay := cadenceRange(cadence, day)
+		archive, err := newConversationArchiveForDays(
+			conversation, slackClient, account, startDay, endDay)
+		if err != nil {
+			return nil, err
+		}
+		archive.Cadence = cadence
+		digest.archivedEndTimes[conversation.Id()] = archive.EndTime
+		if !archive.Empty() && !account.ConversationFilters.ExcludesArchive(archive) {
+			digest.ConversationArchives = append(digest.ConversationArchives, archive)
+		}
+	}
+	return digest, nil
+}
+
+func (digest *ArchiveDigest) Empty() bool {
+	return len(digest.ConversationArchives) == 0
+}
+
+func (digest *ArchiveDigest) MessageCount() int {
+	count := 0
+	for _, archive := range digest.ConversationArchives {
+		count += archive.MessageCount
+	}
+	return count
+}
+
+func (digest *ArchiveDigest) ConversationCount() int {
+	return len(digest.ConversationArchives)
+}
+
+// DisplayDate covers all of the digest's archives, which may span more than a
+// day if some conversations have a weekly or monthly cadence.
+func (digest *ArchiveDigest) DisplayDate() string {
+	if digest.Empty() {
+		return archiveDisplayDate(digest.Day, digest.Day)
+	}
+	startTime := digest.ConversationArchives[0].StartTime
+	endTime := digest.ConversationArchives[0].EndTime
+	for _, archive := range digest.ConversationArchives[1:] {
+		if archive.StartTime.Before(startTime) {
+			startTime = archive.StartTime
+		}
+		if archive.EndTime.After(endTime) {
+			endTime = archive.EndTime
+		}
+	}
+	return archiveDisplayDate(startTime, endTime)
+}
+
+// sendArchiveDigest sends the digest for day, returning the number of
+// conversations that it included (zero if it was empty and thus not sent).
+// Like sendConversationArchive, it returns ErrArchiveAlreadySent if the
+// digest was already sent, unless force is set.
+func sendArchiveDigest(account *Account, day time.Time, onlyDue bool, force bool, c context.Context) (int, error) {
+	slackClient := account.NewSlackClient(c)
+	emailAddress, err := account.GetDigestEmailAddress(slackClient)
+	if err != nil {
+		return 0, err
+	}
+	date := day.Format(ArchiveDayFormat)
+	logPeriod := ArchivePeriod{day, day}
+	if err := checkArchiveLog(c, account.SlackUserId, ArchiveLogDigestConversationId, logPeriod, force); err != nil {
+		return 0, err
+	}
+	if emailAddress == "disabled" {
+		return 0, nil
+	}
+	digest, err := newArchiveDigest(slackClient, account, day, onlyDue)
+	if err != nil {
+		return 0, err
+	}
+	if digest.Empty() {
+		return 0, recordConversationsArchived(c, account.SlackUserId, digest.archivedEndTimes)
+	}
+	// Excluded archives are left out of the digest, so they're not stored
+	// either.
+	storeArchiveMessages(c, account, digest.ConversationArchives...)
+	attachments := archiveMailAttachments(c, account, digest.ConversationArchives...)
+	var data = map[string]interface{}{
+		"ArchiveDigest": digest,
+	}
+	var digestHtml bytes.Buffer
+	if err := templates["digest-email"].Execute(&digestHtml, data); err != nil {
+		return 0, err
+	}
+	team, err := slackClient.GetTeamInfo()
+	if err != nil {
+		return 0, err
+	}
+	previousMessageId, err := previousArchiveMessageId(
+		c, account.SlackUserId, ArchiveLogDigestConversationId, date)
+	if err != nil {
+		return 0, err
+	}
+	sender := archiveSender(team.Name)
+	messageId, headers := digestThreadHeaders(team.ID, account, day, previousMessageId, sender)
+	digestMessage := &MailMessage{
+		Sender:      sender,
+		To:          []string{emailAddress},
+		Subject:     fmt.Sprintf("%s Digest", team.Name),
+		Body:        digest.PlainText() + "\n" + emailFooterPlainText(),
+		HTMLBody:    digestHtml.String(),
+		Date:        day,
+		MessageId:   messageId,
+		Headers:     headers,
+		Attachments: attachments,
+	}
+	if force {
+		markArchiveResent(digestMessage, time.Now())
+	}
+	archiveMailer := account.ArchiveMailer()
+	err = archiveMailer.Send(c, digestMessage)
+	if err != nil {
+		return 0, err
+	}
+	err = logSentArchive(c, account, ArchiveLogDigestConversationId, logPeriod, digest.MessageCount(),
+		sentMessageId(archiveMailer, digestMessage))
+	if err != nil {
+		return 0, err
+	}
+	return digest.ConversationCount(), recordConversationsArchived(c, account.SlackUserId, digest.archivedEndTimes)
+}
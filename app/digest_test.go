@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestArchiveDigest(t *testing.T) {
+	account := initTestApp(t)
+	templates := loadTemplates()
+	newArchive := func(id string, name string, texts ...string) *ConversationArchive {
+		channel := &slack.Channel{}
+		channel.ID = id
+		channel.Name = name
+		group := &MessageGroup{Author: testAlice}
+		for _, text := range texts {
+			group.Messages = append(group.Messages, newTestMessage(account, slack.Msg{Timestamp: "1772665200", Text: text}))
+		}
+		return &ConversationArchive{
+			Conversation:  &ChannelConversation{channel},
+			MessageGroups: []*MessageGroup{group},
+			MessageCount:  len(texts),
+			StartTime:     time.Date(2026, time.March, 4, 0, 0, 0, 0, time.UTC),
+			EndTime:       time.Date(2026, time.March, 4, 23, 59, 59, 0, time.UTC),
+		}
+	}
+	general := newArchive("C1", "general", "hello", "world")
+	random := newArchive("C2", "random", "weekly")
+	random.StartTime = time.Date(2026, time.February, 26, 0, 0, 0, 0, time.UTC)
+	digest := &ArchiveDigest{
+		ConversationArchives: []*ConversationArchive{general, random},
+		Day:                  time.Date(2026, time.March, 5, 0, 0, 0, 0, time.UTC),
+	}
+
+	if digest.MessageCount() != 3 || digest.ConversationCount() != 2 {
+		t.Errorf("Unexpected counts: %d messages in %d conversations",
+			digest.MessageCount(), digest.ConversationCount())
+	}
+	if expected := random.DisplayDate(); digest.DisplayDate() != expected {
+		t.Errorf("DisplayDate: got %q, want %q", digest.DisplayDate(), expected)
+	}
+
+	var buffer bytes.Buffer
+	if err := templates["digest-email"].Execute(&buffer, map[string]interface{}{"ArchiveDigest": digest}); err != nil {
+		t.Fatal(err)
+	}
+	html := buffer.String()
+	for _, expected := range []string{
+		"3 messages in 2 conversations",
+		"<a href=\"#conversation-C1\"",
+		"(2 messages)",
+		"<a href=\"#conversation-C2\"",
+		"(1 message)",
+		"<a name=\"conversation-C1\" id=\"conversation-C1\">",
+		"<a name=\"conversation-C2\" id=\"conversation-C2\">",
+		"weekly",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected %q in %s", expected, html)
+		}
+	}
+
+	plainText := digest.PlainText()
+	for _, expected := range []string{
+		"Slack Digest\n3 messages in 2 conversations from " + digest.DisplayDate() + "\n\n" +
+			"    #general (2 messages)\n" +
+			"    #random (1 message)\n\n\n" +
+			"#general Archive\n",
+		"#random Archive\n1 message from " + random.DisplayDate(),
+	} {
+		if !strings.Contains(plainText, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, plainText)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"strconv"
 	"strings"
 
 	"github.com/slack-go/slack"
@@ -54,3 +55,20 @@ func getEmojiHtml(shortName string, slackClient *slack.Client) (string, error) {
 	}
 	return "", errors.New(fmt.Sprintf("Emoji '%s' not found", shortName))
 }
+
+// Plain text equivalent of getEmojiHtml. Custom emoji are images, so they're
+// left as their :short_name:.
+func getEmojiText(shortName string) string {
+	if emoji, ok := emojiByShortName[shortName]; ok {
+		var text strings.Builder
+		for _, codePointHex := range strings.Split(emoji.UnicodeCodePointHex, "-") {
+			codePoint, err := strconv.ParseInt(codePointHex, 16, 32)
+			if err != nil {
+				return fmt.Sprintf(":%s:", shortName)
+			}
+			text.WriteRune(rune(codePoint))
+		}
+		return text.String()
+	}
+	return fmt.Sprintf(":%s:", shortName)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Helpers shared by the different ways of exporting archives (as files that
+// can be downloaded or written by the command-line tools).
+
+// Limit on the number of days that a single export can cover.
+const ExportMaxDays = BackfillMaxDays
+
+// Exports that are downloaded from the web are built while the request waits,
+// so they're limited to a month. Longer ones can be made from the command
+// line.
+const WebExportMaxDays = 31
+
+// Exports that are downloaded from the web are buffered in memory (App Engine
+// responses can be at most 32 MB), so their total size is limited too.
+const WebExportMaxBytes = 30 * 1024 * 1024
+
+var ErrExportTooLarge = errors.New("The export is too large, choose fewer days or use the command line")
+
+// exportTooLargeMessage is shown instead of ErrExportTooLarge's when a web
+// export can be made by a command-line tool.
+func exportTooLargeMessage(command string) string {
+	return fmt.Sprintf("The export is too large, choose fewer days or use the %s command.", command)
+}
+
+// Destination for exports that are made up of multiple files. Paths are
+// slash-separated and relative to the root of the export.
+type ExportWriter interface {
+	WriteFile(path string, data []byte) error
+}
+
+type DirectoryExportWriter struct {
+	directory string
+}
+
+func (w *DirectoryExportWriter) WriteFile(path string, data []byte) error {
+	fullPath := filepath.Join(w.directory, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fullPath, data, 0644)
+}
+
+// Passes files on to another writer until their total size reaches a limit,
+// after which ErrExportTooLarge is returned.
+type LimitedExportWriter struct {
+	writer         ExportWriter
+	remainingBytes int
+}
+
+func newLimitedExportWriter(writer ExportWriter, maxBytes int) *LimitedExportWriter {
+	return &LimitedExportWriter{writer: writer, remainingBytes: maxBytes}
+}
+
+func (w *LimitedExportWriter) WriteFile(path string, data []byte) error {
+	if len(data) > w.remainingBytes {
+		return ErrExportTooLarge
+	}
+	w.remainingBytes -= len(data)
+	return w.writer.WriteFile(path, data)
+}
+
+// Passes writes on to another writer until their total size reaches a limit,
+// after which ErrExportTooLarge is returned (for exports that are a single
+// file).
+type LimitedWriter struct {
+	writer         io.Writer
+	remainingBytes int
+}
+
+func newLimitedWriter(writer io.Writer, maxBytes int) *LimitedWriter {
+	return &LimitedWriter{writer: writer, remainingBytes: maxBytes}
+}
+
+func (w *LimitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.remainingBytes {
+		return 0, ErrExportTooLarge
+	}
+	w.remainingBytes -= len(p)
+	return w.writer.Write(p)
+}
+
+// Writes files into a zip archive, which is closed by the caller.
+type ZipExportWriter struct {
+	zip *zip.Writer
+}
+
+func (w *ZipExportWriter) WriteFile(path string, data []byte) error {
+	file, err := w.zip.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	return err
+}
+
+// parseExportDays validates the (inclusive) range of days that an export
+// covers. Dates are in ArchiveDayFormat, and today can be included (unlike
+// with backfills), since it may be useful to export what's there so far.
+func parseExportDays(startDate string, endDate string, account *Account) (time.Time, time.Time, error) {
+	startDay, err := parseArchiveDay(startDate, account)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Malformed start date: %s", startDate)
+	}
+	endDay, err := parseArchiveDay(endDate, account)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("Malformed end date: %s", endDate)
+	}
+	if endDay.Before(startDay) {
+		return time.Time{}, time.Time{}, errors.New("The end date must not be before the start date")
+	}
+	if daysBetween(startDate, endDate)+1 > ExportMaxDays {
+		return time.Time{}, time.Time{}, fmt.Errorf("At most %d days can be exported at once", ExportMaxDays)
+	}
+	return startDay, endDay, nil
+}
+
+// parseWebExportDays is like parseExportDays, but for exports that are
+// downloaded from the web (see WebExportMaxDays).
+func parseWebExportDays(startDate string, endDate string, account *Account) (time.Time, time.Time, error) {
+	startDay, endDay, err := parseExportDays(startDate, endDate, account)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if daysBetween(startDate, endDate)+1 > WebExportMaxDays {
+		return time.Time{}, time.Time{}, fmt.Errorf(
+			"At most %d days can be downloaded at once, use the command line for longer exports", WebExportMaxDays)
+	}
+	return startDay, endDay, nil
+}
+
+// exportFileName returns a name for a file with the conversation's archives.
+func exportFileName(conversation Conversation, startDay time.Time, endDay time.Time, extension string) string {
+	return fmt.Sprintf("%s-%s-%s.%s", conversationFileName(conversation),
+		startDay.Format(ArchiveDayFormat), endDay.Format(ArchiveDayFormat), extension)
+}
+
+// conversationFileName strips characters from the conversation's name that
+// aren't safe in file names (e.g. the # of channels), falling back to its ID
+// if there's nothing left.
+func conversationFileName(conversation Conversation) string {
+	var name strings.Builder
+	for _, r := range conversation.Name() {
+		if isSafeFileNameRune(r) {
+			name.WriteRune(r)
+		}
+	}
+	if name.Len() == 0 {
+		return conversation.Id()
+	}
+	return name.String()
+}
+
+// uniqueConversationFileName disambiguates conversationFileName with the
+// conversation's ID if another conversation (recorded in usedNames) has the
+// same one.
+func uniqueConversationFileName(conversation Conversation, usedNames map[string]bool) string {
+	name := conversationFileName(conversation)
+	if usedNames[name] {
+		name += "-" + conversation.Id()
+	}
+	usedNames[name] = true
+	return name
+}
+
+// exportedFileName returns a name for a saved copy of a Slack file, prefixed
+// with its ID so that files with the same name don't collide.
+func exportedFileName(file *slack.File) string {
+	var name strings.Builder
+	name.WriteString(file.ID)
+	name.WriteString("-")
+	for _, r := range file.Name {
+		if isSafeFileNameRune(r) || r == '.' {
+			name.WriteRune(r)
+		}
+	}
+	return name.String()
+}
+
+func isSafeFileNameRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_'
+}
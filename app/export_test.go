@@ -0,0 +1,130 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseExportDays(t *testing.T) {
+	account := initTestApp(t)
+	startDay, endDay, err := parseExportDays("2026-03-01", "2026-03-31", account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !startDay.Equal(time.Date(2026, time.March, 1, 0, 0, 0, 0, account.TimezoneLocation)) ||
+		!endDay.Equal(time.Date(2026, time.March, 31, 0, 0, 0, 0, account.TimezoneLocation)) {
+		t.Errorf("Unexpected days: %s to %s", startDay, endDay)
+	}
+	for _, dates := range [][2]string{
+		{"March 1", "2026-03-31"},
+		{"2026-03-01", ""},
+		{"2026-03-31", "2026-03-01"},
+		{"2024-01-01", "2026-03-01"},
+	} {
+		if _, _, err := parseExportDays(dates[0], dates[1], account); err == nil {
+			t.Errorf("Expected an error for %v", dates)
+		}
+	}
+}
+
+func TestParseWebExportDays(t *testing.T) {
+	account := initTestApp(t)
+	if _, _, err := parseWebExportDays("2026-03-01", "2026-03-31", account); err != nil {
+		t.Errorf("A month should be allowed: %v", err)
+	}
+	for _, dates := range [][2]string{
+		{"2026-03-01", "2026-04-01"},
+		{"2026-03-31", "2026-03-01"},
+	} {
+		if _, _, err := parseWebExportDays(dates[0], dates[1], account); err == nil {
+			t.Errorf("Expected an error for %v", dates)
+		}
+	}
+}
+
+func TestExportFileName(t *testing.T) {
+	startDay := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	endDay := time.Date(2026, time.March, 31, 0, 0, 0, 0, time.UTC)
+	if actual := exportFileName(newTestChannelConversation("C1", "team-eng_2"), startDay, endDay, "mbox"); actual != "team-eng_2-2026-03-01-2026-03-31.mbox" {
+		t.Errorf("Got %q", actual)
+	}
+	if actual := exportFileName(newTestChannelConversation("C1", "日本"), startDay, endDay, "zip"); actual != "C1-2026-03-01-2026-03-31.zip" {
+		t.Errorf("Got %q", actual)
+	}
+}
+
+// ExportWriter that keeps files in memory, keyed by path.
+type testExportWriter map[string][]byte
+
+func (w testExportWriter) WriteFile(path string, data []byte) error {
+	w[path] = data
+	return nil
+}
+
+func TestLimitedExportWriter(t *testing.T) {
+	files := make(testExportWriter)
+	writer := newLimitedExportWriter(files, 10)
+	if err := writer.WriteFile("a.html", []byte("123456")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteFile("b.html", []byte("12345")); err != ErrExportTooLarge {
+		t.Errorf("Expected ErrExportTooLarge, got %v", err)
+	}
+	if err := writer.WriteFile("c.html", []byte("1234")); err != nil {
+		t.Errorf("Files that fit in what's left should be written: %v", err)
+	}
+	if len(files) != 2 || files["b.html"] != nil {
+		t.Errorf("Unexpected files: %v", files)
+	}
+}
+
+func TestLimitedWriter(t *testing.T) {
+	var output bytes.Buffer
+	writer := newLimitedWriter(&output, 10)
+	if _, err := writer.Write([]byte("123456")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write([]byte("12345")); err != ErrExportTooLarge {
+		t.Errorf("Expected ErrExportTooLarge, got %v", err)
+	}
+	if output.String() != "123456" {
+		t.Errorf("Unexpected output: %q", output.String())
+	}
+}
+
+func TestDirectoryExportWriter(t *testing.T) {
+	directory := t.TempDir()
+	writer := &DirectoryExportWriter{directory}
+	if err := writer.WriteFile("general/2026-03-04.html", []byte("<html>")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(directory, "general", "2026-03-04.html"))
+	if err != nil || string(data) != "<html>" {
+		t.Errorf("Got %q, %v", data, err)
+	}
+}
+
+func TestZipExportWriter(t *testing.T) {
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+	writer := &ZipExportWriter{zipWriter}
+	for _, path := range []string{"index.html", "general/index.html"} {
+		if err := writer.WriteFile(path, []byte(path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipReader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zipReader.File) != 2 || zipReader.File[1].Name != "general/index.html" {
+		t.Errorf("Unexpected files: %+v", zipReader.File)
+	}
+}
@@ -0,0 +1,333 @@
+//go:build standalone
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+// In-process fake of the Slack Web API methods that the app uses, serving a
+// workspace from a fixture file. Lists are returned in small pages (regardless
+// of the requested limit), so that tests also cover following pagination.
+// Only used in the standalone build, since the Slack client's requests go
+// through the caching transport, which logs via the platform hooks.
+
+const (
+	FakeSlackToken           = "xoxp-test"
+	FakeSlackDefaultPageSize = 2
+)
+
+type fakeSlackFixtures struct {
+	AuthUserId    string          `json:"auth_user_id"`
+	Team          slack.TeamInfo  `json:"team"`
+	Users         []slack.User    `json:"users"`
+	Bots          []slack.Bot     `json:"bots"`
+	Conversations []slack.Channel `json:"conversations"`
+	// Member IDs, keyed by conversation ID.
+	Members map[string][]string `json:"members"`
+	// Messages (including thread replies) keyed by conversation ID, oldest
+	// first. They're served as is, so they can have fields that the client
+	// doesn't know about.
+	Messages map[string][]json.RawMessage `json:"messages"`
+	// Custom emoji URLs, keyed by name.
+	Emoji map[string]string `json:"emoji"`
+	Files []slack.File      `json:"files"`
+}
+
+type fakeSlackMessage struct {
+	Timestamp       string `json:"ts"`
+	ThreadTimestamp string `json:"thread_ts"`
+	data            json.RawMessage
+}
+
+func (m *fakeSlackMessage) IsReply() bool {
+	return m.ThreadTimestamp != "" && m.ThreadTimestamp != m.Timestamp
+}
+
+type fakeSlack struct {
+	*httptest.Server
+	fixtures fakeSlackFixtures
+	messages map[string][]*fakeSlackMessage
+	// Maximum number of items that are returned per page.
+	PageSize int
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+// newFakeSlack starts a fake that serves the fixtures in fixturesPath (relative
+// to testdata/fake-slack) and points Slack clients at it until the test ends.
+func newFakeSlack(t *testing.T, fixturesPath string) *fakeSlack {
+	fixturesBytes, err := ioutil.ReadFile("testdata/fake-slack/" + fixturesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSlack{
+		messages: make(map[string][]*fakeSlackMessage),
+		PageSize: FakeSlackDefaultPageSize,
+		calls:    make(map[string]int),
+	}
+	if err := json.Unmarshal(fixturesBytes, &f.fixtures); err != nil {
+		t.Fatalf("Could not parse %s: %s", fixturesPath, err)
+	}
+	for conversationId, rawMessages := range f.fixtures.Messages {
+		for _, rawMessage := range rawMessages {
+			message := &fakeSlackMessage{data: rawMessage}
+			if err := json.Unmarshal(rawMessage, message); err != nil {
+				t.Fatalf("Could not parse message in %s: %s", conversationId, err)
+			}
+			f.messages[conversationId] = append(f.messages[conversationId], message)
+		}
+	}
+	f.Server = httptest.NewServer(f)
+	previousApiUrl := slackApiUrl
+	slackApiUrl = f.URL + "/api/"
+	t.Cleanup(func() {
+		slackApiUrl = previousApiUrl
+		f.Close()
+	})
+	return f
+}
+
+// Calls returns the number of times that the method was called.
+func (f *fakeSlack) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	f.mu.Lock()
+	f.calls[method]++
+	f.mu.Unlock()
+
+	var response map[string]interface{}
+	var errorCode string
+	if r.FormValue("token") != FakeSlackToken {
+		errorCode = "invalid_auth"
+	} else {
+		response, errorCode = f.call(method, r)
+	}
+	if errorCode != "" {
+		response = map[string]interface{}{"ok": false, "error": errorCode}
+	} else {
+		response["ok"] = true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// call handles a method, returning either its response or an error code.
+func (f *fakeSlack) call(method string, r *http.Request) (map[string]interface{}, string) {
+	switch method {
+	case "auth.test":
+		return map[string]interface{}{
+			"url":     "https://" + f.fixtures.Team.Domain + ".slack.com/",
+			"team":    f.fixtures.Team.Name,
+			"team_id": f.fixtures.Team.ID,
+			"user_id": f.fixtures.AuthUserId,
+		}, ""
+	case "team.info":
+		return map[string]interface{}{"team": f.fixtures.Team}, ""
+	case "users.list":
+		start, end, nextCursor := f.page(r, len(f.fixtures.Users))
+		return map[string]interface{}{
+			"members":           f.fixtures.Users[start:end],
+			"response_metadata": map[string]string{"next_cursor": nextCursor},
+		}, ""
+	case "users.info":
+		for i := range f.fixtures.Users {
+			if f.fixtures.Users[i].ID == r.FormValue("user") {
+				return map[string]interface{}{"user": f.fixtures.Users[i]}, ""
+			}
+		}
+		return nil, "user_not_found"
+	case "bots.info":
+		for i := range f.fixtures.Bots {
+			if f.fixtures.Bots[i].ID == r.FormValue("bot") {
+				return map[string]interface{}{"bot": f.fixtures.Bots[i]}, ""
+			}
+		}
+		return nil, "bot_not_found"
+	case "users.conversations", "conversations.list":
+		conversations := f.conversationsOfTypes(r.FormValue("types"))
+		start, end, nextCursor := f.page(r, len(conversations))
+		return map[string]interface{}{
+			"channels":          conversations[start:end],
+			"response_metadata": map[string]string{"next_cursor": nextCursor},
+		}, ""
+	case "conversations.info":
+		conversation := f.conversation(r.FormValue("channel"))
+		if conversation == nil {
+			return nil, "channel_not_found"
+		}
+		return map[string]interface{}{"channel": conversation}, ""
+	case "conversations.members":
+		if f.conversation(r.FormValue("channel")) == nil {
+			return nil, "channel_not_found"
+		}
+		members := f.fixtures.Members[r.FormValue("channel")]
+		start, end, nextCursor := f.page(r, len(members))
+		return map[string]interface{}{
+			"members":           members[start:end],
+			"response_metadata": map[string]string{"next_cursor": nextCursor},
+		}, ""
+	case "conversations.history":
+		return f.history(r)
+	case "conversations.replies":
+		return f.replies(r)
+	case "emoji.list":
+		return map[string]interface{}{"emoji": f.fixtures.Emoji}, ""
+	case "files.info":
+		for i := range f.fixtures.Files {
+			if f.fixtures.Files[i].ID == r.FormValue("file") {
+				return map[string]interface{}{
+					"file":     f.fixtures.Files[i],
+					"comments": []slack.Comment{},
+					"paging":   slack.Paging{Count: 0, Total: 0, Page: 1, Pages: 1},
+				}, ""
+			}
+		}
+		return nil, "file_not_found"
+	}
+	return nil, "unknown_method"
+}
+
+// page returns the range of the page that starts at the request's cursor (an
+// offset into the list) and the cursor of the next one (empty for the last
+// page).
+func (f *fakeSlack) page(r *http.Request, count int) (int, int, string) {
+	start, _ := strconv.Atoi(r.FormValue("cursor"))
+	if start > count {
+		start = count
+	}
+	pageSize := f.PageSize
+	if limit, err := strconv.Atoi(r.FormValue("limit")); err == nil && limit > 0 && limit < pageSize {
+		pageSize = limit
+	}
+	end := start + pageSize
+	if end >= count {
+		return start, count, ""
+	}
+	return start, end, strconv.Itoa(end)
+}
+
+func (f *fakeSlack) conversation(id string) *slack.Channel {
+	for i := range f.fixtures.Conversations {
+		if f.fixtures.Conversations[i].ID == id {
+			return &f.fixtures.Conversations[i]
+		}
+	}
+	return nil
+}
+
+// conversationsOfTypes filters conversations by the comma-separated list of
+// types (all public channels if there are none, like the real API).
+func (f *fakeSlack) conversationsOfTypes(types string) []slack.Channel {
+	if types == "" {
+		types = "public_channel"
+	}
+	conversations := make([]slack.Channel, 0)
+	for _, conversation := range f.fixtures.Conversations {
+		var conversationType string
+		switch {
+		case conversation.IsMpIM:
+			conversationType = "mpim"
+		case conversation.IsIM:
+			conversationType = "im"
+		case conversation.IsGroup || conversation.IsPrivate:
+			conversationType = "private_channel"
+		default:
+			conversationType = "public_channel"
+		}
+		for _, t := range strings.Split(types, ",") {
+			if t == conversationType {
+				conversations = append(conversations, conversation)
+				break
+			}
+		}
+	}
+	return conversations
+}
+
+// inRange checks the message's timestamp against the request's oldest and
+// latest parameters.
+func inRange(message *fakeSlackMessage, r *http.Request) bool {
+	timestamp, _ := strconv.ParseFloat(message.Timestamp, 64)
+	inclusive := r.FormValue("inclusive") == "1"
+	if oldest, err := strconv.ParseFloat(r.FormValue("oldest"), 64); err == nil {
+		if timestamp < oldest || (timestamp == oldest && !inclusive) {
+			return false
+		}
+	}
+	if latest, err := strconv.ParseFloat(r.FormValue("latest"), 64); err == nil {
+		if timestamp > latest || (timestamp == latest && !inclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// history returns the top-level messages in the range, newest first.
+func (f *fakeSlack) history(r *http.Request) (map[string]interface{}, string) {
+	if f.conversation(r.FormValue("channel")) == nil {
+		return nil, "channel_not_found"
+	}
+	messages := make([]json.RawMessage, 0)
+	for _, message := range f.messages[r.FormValue("channel")] {
+		if !message.IsReply() && inRange(message, r) {
+			messages = append([]json.RawMessage{message.data}, messages...)
+		}
+	}
+	start, end, nextCursor := f.page(r, len(messages))
+	return map[string]interface{}{
+		"messages":          messages[start:end],
+		"has_more":          nextCursor != "",
+		"response_metadata": map[string]string{"next_cursor": nextCursor},
+	}, ""
+}
+
+// replies returns the thread's parent message followed by the replies in the
+// range, oldest first.
+func (f *fakeSlack) replies(r *http.Request) (map[string]interface{}, string) {
+	if f.conversation(r.FormValue("channel")) == nil {
+		return nil, "channel_not_found"
+	}
+	threadTimestamp := r.FormValue("ts")
+	var parent json.RawMessage
+	replies := make([]*fakeSlackMessage, 0)
+	for _, message := range f.messages[r.FormValue("channel")] {
+		if message.Timestamp == threadTimestamp {
+			parent = message.data
+		} else if message.ThreadTimestamp == threadTimestamp && inRange(message, r) {
+			replies = append(replies, message)
+		}
+	}
+	if parent == nil {
+		return nil, "thread_not_found"
+	}
+	sort.SliceStable(replies, func(i, j int) bool {
+		return slackTimestampTime(replies[i].Timestamp).Before(slackTimestampTime(replies[j].Timestamp))
+	})
+	messages := []json.RawMessage{parent}
+	for _, reply := range replies {
+		messages = append(messages, reply.data)
+	}
+	start, end, nextCursor := f.page(r, len(messages))
+	return map[string]interface{}{
+		"messages":          messages[start:end],
+		"has_more":          nextCursor != "",
+		"response_metadata": map[string]string{"next_cursor": nextCursor},
+	}, ""
+}
@@ -44,11 +44,31 @@ func (f *FileUrlRef) Encode() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	return encryptString(string(b))
+}
+
+func DecodeFileUrlRef(encoded string) (*FileUrlRef, error) {
+	b, err := decryptString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var f FileUrlRef
+	err = json.Unmarshal([]byte(b), &f)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// encryptString encrypts text with fileUrlRefEncryptionKey (it's also used for
+// other secrets that are stored or sent to clients, e.g. IMAP passwords),
+// returning it in URL-safe base64.
+func encryptString(text string) (string, error) {
 	block, err := aes.NewCipher(fileUrlRefEncryptionKey)
 	if err != nil {
 		return "", err
 	}
-	ciphertext := make([]byte, aes.BlockSize+len(b))
+	ciphertext := make([]byte, aes.BlockSize+len(text))
 	// A random initialization vector with the length of the block size is
 	// prepended to the resulting ciphertext.
 	iv := ciphertext[:aes.BlockSize]
@@ -56,29 +76,27 @@ func (f *FileUrlRef) Encode() (string, error) {
 		return "", err
 	}
 	cfb := cipher.NewCFBEncrypter(block, iv)
-	cfb.XORKeyStream(ciphertext[aes.BlockSize:], []byte(b))
+	cfb.XORKeyStream(ciphertext[aes.BlockSize:], []byte(text))
 	return base64.URLEncoding.EncodeToString(ciphertext), nil
 }
 
-func DecodeFileUrlRef(encoded string) (*FileUrlRef, error) {
+func decryptString(encoded string) (string, error) {
 	ciphertext, err := base64.URLEncoding.DecodeString(encoded)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	block, err := aes.NewCipher(fileUrlRefEncryptionKey)
+	if err != nil {
+		return "", err
+	}
 	size := block.BlockSize()
-	if len(ciphertext) <= block.BlockSize() {
-		return nil, errors.New("malformed encrypted FileUrlRef")
+	if len(ciphertext) < size {
+		return "", errors.New("malformed encrypted string")
 	}
 	// Extract the initialization vector.
 	iv := ciphertext[:size]
 	b := ciphertext[size:]
 	cfb := cipher.NewCFBDecrypter(block, iv)
 	cfb.XORKeyStream(b, b)
-	var f FileUrlRef
-	err = json.Unmarshal(b, &f)
-	if err != nil {
-		return nil, err
-	}
-	return &f, nil
+	return string(b), nil
 }
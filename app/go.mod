@@ -2,14 +2,19 @@ module persistent.info/slack-archive
 
 go 1.18
 
+require (
+	github.com/gorilla/mux v1.2.0
+	github.com/gorilla/sessions v1.1.1
+	github.com/slack-go/slack v0.10.2
+	go.etcd.io/bbolt v1.3.7
+	google.golang.org/appengine v1.6.7
+)
+
 require (
 	github.com/golang/protobuf v1.3.1 // indirect
 	github.com/gorilla/context v1.1.1 // indirect
-	github.com/gorilla/mux v1.2.0 // indirect
 	github.com/gorilla/securecookie v1.1.1 // indirect
-	github.com/gorilla/sessions v1.1.1 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
-	github.com/slack-go/slack v0.10.2 // indirect
 	golang.org/x/net v0.0.0-20190603091049-60506f45cf65 // indirect
-	google.golang.org/appengine v1.6.7 // indirect
+	golang.org/x/sys v0.7.0 // indirect
 )
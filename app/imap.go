@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	ImapSecurityTLS      = "tls"
+	ImapSecurityStartTLS = "starttls"
+	ImapSecurityNone     = "none"
+
+	ImapDateTimeFormat = "02-Jan-2006 15:04:05 -0700"
+)
+
+type ImapConfig struct {
+	Host string
+	Port int
+	// One of "tls" (the default, usually on port 993), "starttls" or "none".
+	Security string
+	Username string
+	// Stored encrypted (see SetPassword), the plain text one is only used when
+	// connecting (and by tests).
+	EncryptedPassword string
+	Password          string `datastore:"-" json:"-"`
+	// Mailbox that archives are appended to, e.g. "Slack" or "[Gmail]/All Mail".
+	Folder string
+	// Whether appended archives should be flagged as \Seen.
+	MarkSeen bool
+}
+
+func (config *ImapConfig) Validate() error {
+	if config.Host == "" {
+		return errors.New("No IMAP server specified")
+	}
+	if config.Username == "" {
+		return errors.New("No IMAP username specified")
+	}
+	if config.Folder == "" {
+		return errors.New("No IMAP folder specified")
+	}
+	if ip := net.ParseIP(config.Host); ip != nil && !isAllowedImapAddress(ip) {
+		return fmt.Errorf("IMAP server %s is not allowed", config.Host)
+	}
+	switch config.Security {
+	case "", ImapSecurityTLS, ImapSecurityStartTLS, ImapSecurityNone:
+		return nil
+	}
+	return fmt.Errorf("Unknown IMAP security mode: %s", config.Security)
+}
+
+func (config *ImapConfig) SetPassword(password string) error {
+	encryptedPassword, err := encryptString(password)
+	if err != nil {
+		return err
+	}
+	config.EncryptedPassword = encryptedPassword
+	return nil
+}
+
+// password returns the plain text password, decrypting it if needed.
+func (config *ImapConfig) password() (string, error) {
+	if config.Password != "" || config.EncryptedPassword == "" {
+		return config.Password, nil
+	}
+	return decryptString(config.EncryptedPassword)
+}
+
+// The IMAP server comes from user settings, so connections to loopback,
+// private and link-local addresses are refused (unless the deployment allows
+// them), so that it can't be used to reach internal services.
+func isAllowedImapAddress(ip net.IP) bool {
+	if mailConfig.ImapAllowPrivateHosts {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() || imapSharedAddressSpace.Contains(ip))
+}
+
+// Carrier-grade NAT range (RFC 6598), which net.IP.IsPrivate doesn't cover.
+var imapSharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Checks the address that is actually connected to (after DNS resolution),
+// so that host names that resolve to internal addresses are refused too.
+func checkImapDialAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isAllowedImapAddress(ip) {
+		return fmt.Errorf("IMAP server address %s is not allowed", host)
+	}
+	return nil
+}
+
+// Mailer that delivers messages by appending them directly to an IMAP mailbox
+// instead of sending them, so that they don't get bounced or filtered. The
+// message's Date is used as the internal date.
+type ImapMailer struct {
+	config ImapConfig
+}
+
+func (m *ImapMailer) Send(c context.Context, message *MailMessage) error {
+	messageBytes, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	date := message.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	client, err := dialImap(c, m.config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	password, err := m.config.password()
+	if err != nil {
+		return fmt.Errorf("Could not decrypt IMAP password: %s", err.Error())
+	}
+	if err := client.Login(m.config.Username, password); err != nil {
+		return err
+	}
+	var flags []string
+	if m.config.MarkSeen {
+		flags = append(flags, "\\Seen")
+	}
+	if err := client.Append(m.config.Folder, flags, date, messageBytes); err != nil {
+		return err
+	}
+	return client.Logout()
+}
+
+// Minimal IMAP4rev1 client, supporting only what's needed to append messages.
+type ImapClient struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	tagId   int
+	timeout time.Duration
+}
+
+func dialImap(c context.Context, config ImapConfig) (*ImapClient, error) {
+	security := config.Security
+	if security == "" {
+		security = ImapSecurityTLS
+	}
+	port := config.Port
+	if port == 0 {
+		if security == ImapSecurityTLS {
+			port = 993
+		} else {
+			port = 143
+		}
+	}
+	address := net.JoinHostPort(config.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: config.Host}
+	dialer := &net.Dialer{Timeout: time.Second * 30, Control: checkImapDialAddress}
+	var conn net.Conn
+	var err error
+	if security == ImapSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.DialContext(c, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client := &ImapClient{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: time.Second * 60,
+	}
+	greeting, err := client.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		conn.Close()
+		return nil, fmt.Errorf("Unexpected IMAP greeting: %s", greeting)
+	}
+	if security == ImapSecurityStartTLS {
+		if err := client.command("STARTTLS"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		client.conn = tlsConn
+		client.reader = bufio.NewReader(tlsConn)
+	}
+	return client, nil
+}
+
+func (client *ImapClient) Close() error {
+	return client.conn.Close()
+}
+
+func (client *ImapClient) Login(username string, password string) error {
+	return client.command("LOGIN", imapString(username), imapString(password))
+}
+
+func (client *ImapClient) Append(folder string, flags []string, date time.Time, message []byte) error {
+	return client.command(
+		"APPEND",
+		imapString(folder),
+		fmt.Sprintf("(%s)", strings.Join(flags, " ")),
+		imapQuote(date.Format(ImapDateTimeFormat)),
+		imapLiteral(message))
+}
+
+func (client *ImapClient) Logout() error {
+	return client.command("LOGOUT")
+}
+
+// Command arguments are either atoms/quoted strings (passed through as-is) or
+// literals, which are sent after the server's continuation request.
+type imapLiteral []byte
+
+func imapQuote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return "\"" + s + "\""
+}
+
+// Quoted strings can only contain 7-bit characters without CR or LF, anything
+// else needs to be sent as a literal.
+func imapString(s string) interface{} {
+	for _, r := range s {
+		if r > 0x7f || r == '\r' || r == '\n' || r == 0 {
+			return imapLiteral(s)
+		}
+	}
+	return imapQuote(s)
+}
+
+func (client *ImapClient) command(name string, args ...interface{}) error {
+	client.tagId++
+	tag := fmt.Sprintf("a%d", client.tagId)
+	client.conn.SetDeadline(time.Now().Add(client.timeout))
+	line := tag + " " + name
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case string:
+			line += " " + arg
+		case imapLiteral:
+			line += fmt.Sprintf(" {%d}\r\n", len(arg))
+			if _, err := io.WriteString(client.conn, line); err != nil {
+				return err
+			}
+			if err := client.waitForContinuation(tag); err != nil {
+				return err
+			}
+			if _, err := client.conn.Write(arg); err != nil {
+				return err
+			}
+			line = ""
+		}
+	}
+	if _, err := io.WriteString(client.conn, line+"\r\n"); err != nil {
+		return err
+	}
+	for {
+		response, err := client.readLine()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(response, tag+" ") {
+			return checkImapStatus(name, strings.TrimPrefix(response, tag+" "))
+		}
+		// Untagged responses (and continuations) are ignored.
+	}
+}
+
+func (client *ImapClient) waitForContinuation(tag string) error {
+	for {
+		response, err := client.readLine()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(response, "+") {
+			return nil
+		}
+		if strings.HasPrefix(response, tag+" ") {
+			return checkImapStatus("literal", strings.TrimPrefix(response, tag+" "))
+		}
+	}
+}
+
+func checkImapStatus(command string, status string) error {
+	if strings.HasPrefix(status, "OK") {
+		return nil
+	}
+	return fmt.Errorf("IMAP %s failed: %s", command, status)
+}
+
+// Reads a response line, including any literals that it contains.
+func (client *ImapClient) readLine() (string, error) {
+	var result strings.Builder
+	for {
+		line, err := client.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		result.WriteString(line)
+		if !strings.HasSuffix(line, "}") {
+			return result.String(), nil
+		}
+		openIndex := strings.LastIndex(line, "{")
+		if openIndex == -1 {
+			return result.String(), nil
+		}
+		literalLength, err := strconv.Atoi(line[openIndex+1 : len(line)-1])
+		if err != nil {
+			return result.String(), nil
+		}
+		literal := make([]byte, literalLength)
+		if _, err := io.ReadFull(client.reader, literal); err != nil {
+			return "", err
+		}
+		result.Write(literal)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testImapAppend struct {
+	folder  string
+	flags   string
+	date    string
+	message string
+}
+
+// Minimal IMAP server stand-in that accepts a single LOGIN and records
+// APPENDed messages.
+type testImapServer struct {
+	listener net.Listener
+	username string
+	password string
+	appends  chan testImapAppend
+}
+
+func newTestImapServer(t *testing.T, username string, password string) *testImapServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server is on a loopback address.
+	mailConfig.ImapAllowPrivateHosts = true
+	t.Cleanup(func() { mailConfig.ImapAllowPrivateHosts = false })
+	server := &testImapServer{
+		listener: listener,
+		username: username,
+		password: password,
+		appends:  make(chan testImapAppend, 10),
+	}
+	go server.serve()
+	return server
+}
+
+func (server *testImapServer) Config() ImapConfig {
+	host, portString, _ := net.SplitHostPort(server.listener.Addr().String())
+	port, _ := strconv.Atoi(portString)
+	return ImapConfig{
+		Host:     host,
+		Port:     port,
+		Security: ImapSecurityNone,
+		Username: server.username,
+		Password: server.password,
+		Folder:   "Slack",
+	}
+}
+
+func (server *testImapServer) Close() {
+	server.listener.Close()
+}
+
+func (server *testImapServer) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.handle(conn)
+	}
+}
+
+var testImapCommandRegexp = regexp.MustCompile(`^(\S+) (\S+) ?(.*)$`)
+var testImapAppendRegexp = regexp.MustCompile(`^"?([^"]*)"? \(([^)]*)\) "([^"]*)" $`)
+var testImapQuotedRegexp = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+var testImapLiteralRegexp = regexp.MustCompile(`\{(\d+)\}$`)
+
+func (server *testImapServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK IMAP4rev1 test server ready\r\n")
+	loggedIn := false
+	for {
+		line, literals, err := readTestImapCommand(reader, conn)
+		if err != nil {
+			return
+		}
+		match := testImapCommandRegexp.FindStringSubmatch(line)
+		if match == nil {
+			fmt.Fprint(conn, "* BAD malformed command\r\n")
+			continue
+		}
+		tag, command, args := match[1], strings.ToUpper(match[2]), match[3]
+		switch command {
+		case "LOGIN":
+			username, password := "", ""
+			// Arguments are either quoted strings or literals.
+			quoted := testImapQuotedRegexp.FindAllStringSubmatch(args, -1)
+			values := make([]string, 0, 2)
+			for _, q := range quoted {
+				values = append(values, strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(q[1]))
+			}
+			values = append(values, literals...)
+			if len(values) == 2 {
+				username, password = values[0], values[1]
+			}
+			if username == server.username && password == server.password {
+				loggedIn = true
+				fmt.Fprintf(conn, "%s OK LOGIN completed\r\n", tag)
+			} else {
+				fmt.Fprintf(conn, "%s NO [AUTHENTICATIONFAILED] invalid credentials\r\n", tag)
+			}
+		case "APPEND":
+			appendMatch := testImapAppendRegexp.FindStringSubmatch(args)
+			if !loggedIn || appendMatch == nil || len(literals) != 1 {
+				fmt.Fprintf(conn, "%s BAD APPEND %q\r\n", tag, args)
+				continue
+			}
+			server.appends <- testImapAppend{
+				folder:  appendMatch[1],
+				flags:   appendMatch[2],
+				date:    appendMatch[3],
+				message: literals[0],
+			}
+			fmt.Fprintf(conn, "%s OK [APPENDUID 1 1] APPEND completed\r\n", tag)
+		case "LOGOUT":
+			fmt.Fprint(conn, "* BYE logging out\r\n")
+			fmt.Fprintf(conn, "%s OK LOGOUT completed\r\n", tag)
+			return
+		default:
+			fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+		}
+	}
+}
+
+// Reads a command line, requesting continuations for (and collecting) any
+// literals. Literals are stripped from the returned line.
+func readTestImapCommand(reader *bufio.Reader, conn net.Conn) (string, []string, error) {
+	var line strings.Builder
+	var literals []string
+	for {
+		part, err := reader.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		part = strings.TrimRight(part, "\r\n")
+		match := testImapLiteralRegexp.FindStringSubmatch(part)
+		if match == nil {
+			line.WriteString(part)
+			return line.String(), literals, nil
+		}
+		line.WriteString(strings.TrimSuffix(part, match[0]))
+		length, _ := strconv.Atoi(match[1])
+		fmt.Fprint(conn, "+ Ready for literal data\r\n")
+		literal := make([]byte, length)
+		if _, err := io.ReadFull(reader, literal); err != nil {
+			return "", nil, err
+		}
+		literals = append(literals, string(literal))
+	}
+}
+
+func TestImapMailerAppend(t *testing.T) {
+	server := newTestImapServer(t, "user@example.com", "pässword \"quoted\"")
+	defer server.Close()
+	config := server.Config()
+	config.MarkSeen = true
+
+	date := time.Date(2026, time.March, 4, 23, 59, 59, 0, time.FixedZone("PST", -8*60*60))
+	message := &MailMessage{
+		Sender:   "Team Slack Archive <archive@example.com>",
+		To:       []string{"user@example.com"},
+		Subject:  "#general Archive",
+		HTMLBody: "<p>archive</p>",
+		Date:     date,
+	}
+	mailer := &ImapMailer{config}
+	if err := mailer.Send(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case appended := <-server.appends:
+		if appended.folder != "Slack" {
+			t.Errorf("Folder: got %q", appended.folder)
+		}
+		if appended.flags != "\\Seen" {
+			t.Errorf("Flags: got %q", appended.flags)
+		}
+		if appended.date != "04-Mar-2026 23:59:59 -0800" {
+			t.Errorf("Date: got %q", appended.date)
+		}
+		if !strings.Contains(appended.message, "Date: Wed, 04 Mar 2026 23:59:59 -0800\r\n") ||
+			!strings.Contains(appended.message, "<p>archive</p>") {
+			t.Errorf("Message: got %q", appended.message)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Message was not appended")
+	}
+}
+
+func TestImapMailerUnseenByDefault(t *testing.T) {
+	server := newTestImapServer(t, "user", "password")
+	defer server.Close()
+
+	mailer := &ImapMailer{server.Config()}
+	message := &MailMessage{
+		Sender:   "archive@example.com",
+		To:       []string{"user@example.com"},
+		Subject:  "Archive",
+		HTMLBody: "<p>archive</p>",
+	}
+	if err := mailer.Send(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	appended := <-server.appends
+	if appended.flags != "" {
+		t.Errorf("Flags: got %q, want none", appended.flags)
+	}
+}
+
+func TestImapMailerLoginFailure(t *testing.T) {
+	server := newTestImapServer(t, "user", "password")
+	defer server.Close()
+
+	config := server.Config()
+	config.Password = "wrong"
+	mailer := &ImapMailer{config}
+	err := mailer.Send(context.Background(), &MailMessage{
+		Sender:   "archive@example.com",
+		To:       []string{"user@example.com"},
+		HTMLBody: "<p>archive</p>",
+	})
+	if err == nil || !strings.Contains(err.Error(), "AUTHENTICATIONFAILED") {
+		t.Errorf("Expected login failure, got %v", err)
+	}
+}
+
+func TestImapMailerEncryptedPassword(t *testing.T) {
+	fileUrlRefEncryptionKey = []byte("0123456789abcdef")
+	server := newTestImapServer(t, "user", "password")
+	defer server.Close()
+
+	config := server.Config()
+	config.Password = ""
+	if err := config.SetPassword("password"); err != nil {
+		t.Fatal(err)
+	}
+	mailer := &ImapMailer{config}
+	if err := mailer.Send(context.Background(), &MailMessage{
+		Sender:   "archive@example.com",
+		To:       []string{"user@example.com"},
+		HTMLBody: "<p>archive</p>",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-server.appends
+}
+
+func TestImapMailerPrivateAddress(t *testing.T) {
+	server := newTestImapServer(t, "user", "password")
+	defer server.Close()
+	mailConfig.ImapAllowPrivateHosts = false
+
+	mailer := &ImapMailer{server.Config()}
+	err := mailer.Send(context.Background(), &MailMessage{
+		Sender:   "archive@example.com",
+		To:       []string{"user@example.com"},
+		HTMLBody: "<p>archive</p>",
+	})
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Expected loopback address to be refused, got %v", err)
+	}
+
+	for _, test := range []struct {
+		ip      string
+		allowed bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	} {
+		if allowed := isAllowedImapAddress(net.ParseIP(test.ip)); allowed != test.allowed {
+			t.Errorf("isAllowedImapAddress(%s): got %t, want %t", test.ip, allowed, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MailTransportSmtp = "smtp"
+	MailTransportFile = "file"
+	MailTransportLog  = "log"
+
+	SmtpSecurityStartTLS = "starttls"
+	SmtpSecurityTLS      = "tls"
+	SmtpSecurityNone     = "none"
+)
+
+type MailConfig struct {
+	// One of "smtp", "file" or "log". If empty, App Engine's mail API is used
+	// when running on App Engine, and messages are logged when running
+	// standalone.
+	Transport string
+	// Archives are sent from "<team name> Slack Archive <ArchiveSenderAddress>".
+	ArchiveSenderAddress string
+	// Sender and recipient of error reports.
+	AdminSender       string
+	AdminEmailAddress string
+	Smtp              SmtpConfig
+	// Directory that the "file" transport writes .eml files to.
+	FileDropDirectory string
+	// Whether users' IMAP settings may point at loopback or private network
+	// addresses, e.g. for self-hosted deployments next to their mail server.
+	ImapAllowPrivateHosts bool
+}
+
+type SmtpConfig struct {
+	Host string
+	Port int
+	// One of "starttls" (the default), "tls" (implicit TLS, usually on port
+	// 465) or "none".
+	Security string
+	Username string
+	Password string
+}
+
+// Not all deployments need custom mail settings, so the config file is
+// optional and these are the defaults.
+var defaultMailConfig = MailConfig{
+	ArchiveSenderAddress: "archive@slack-archive.appspotmail.com",
+	AdminSender:          "Slack Archive Admin <admin@slack-archive.appspotmail.com>",
+	AdminEmailAddress:    "mihai.parparita@gmail.com",
+}
+
+func loadMailConfig() MailConfig {
+	config := defaultMailConfig
+	configBytes, err := ioutil.ReadFile("config/mail.json")
+	if os.IsNotExist(err) {
+		return config
+	}
+	if err != nil {
+		log.Panicf("Could not read mail config: %s", err.Error())
+	}
+	err = json.Unmarshal(configBytes, &config)
+	if err != nil {
+		log.Panicf("Could not parse mail config %s: %s", configBytes, err.Error())
+	}
+	return config
+}
+
+// Returns the Mailer for the configured transport, or nil if the platform's
+// default should be used.
+func newMailerFromConfig(config MailConfig) (Mailer, error) {
+	switch config.Transport {
+	case "":
+		return nil, nil
+	case MailTransportSmtp:
+		if config.Smtp.Host == "" {
+			return nil, errors.New("No SMTP host configured")
+		}
+		return &SmtpMailer{config.Smtp}, nil
+	case MailTransportFile:
+		if config.FileDropDirectory == "" {
+			return nil, errors.New("No file drop directory configured")
+		}
+		return &FileDropMailer{config.FileDropDirectory}, nil
+	case MailTransportLog:
+		return &LogMailer{}, nil
+	}
+	return nil, fmt.Errorf("Unknown mail transport: %s", config.Transport)
+}
+
+type MailMessage struct {
+	Sender   string
+	To       []string
+	Subject  string
+	Body     string
+	HTMLBody string
+	// Defaults to the time that the message is serialized.
+	Date time.Time
+	// Generated (randomly) if not set.
+	MessageId string
+	// Additional headers, e.g. for threading.
+	Headers mail.Header
+	// Attachments with a ContentId are inline (referenced from the HTML body
+	// via cid: URLs).
+	Attachments []*MailAttachment
+}
+
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	// Without angle brackets.
+	ContentId string
+}
+
+type Mailer interface {
+	Send(c context.Context, message *MailMessage) error
+}
+
+// Implemented by mailers that only accept some attachments, so that the files
+// that they'd reject can be linked to instead.
+type AttachmentFilteringMailer interface {
+	Mailer
+	AllowsAttachment(name string) bool
+}
+
+// Implemented by mailers that can't set the Message-ID (and generate their own
+// instead), so that MailMessage.MessageId isn't recorded as the one that was
+// sent.
+type MessageIdGeneratingMailer interface {
+	Mailer
+	GeneratesMessageIds() bool
+}
+
+// sentMessageId returns the Message-ID that the message was sent with by
+// mailer, or an empty string if it's not known.
+func sentMessageId(mailer Mailer, message *MailMessage) string {
+	if generator, ok := mailer.(MessageIdGeneratingMailer); ok && generator.GeneratesMessageIds() {
+		return ""
+	}
+	return message.MessageId
+}
+
+// Serializes the message in RFC 5322 format. The body is a
+// multipart/alternative if it has both a plain text and HTML version, wrapped
+// in a multipart/related if there are inline attachments and a
+// multipart/mixed if there are regular ones.
+func (message *MailMessage) Bytes() ([]byte, error) {
+	messageId := message.MessageId
+	if messageId == "" {
+		var err error
+		messageId, err = newMessageId(message.Sender)
+		if err != nil {
+			return nil, err
+		}
+	}
+	recipients := make([]string, len(message.To))
+	for i, to := range message.To {
+		recipients[i] = formatAddress(to)
+	}
+
+	date := message.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var buffer bytes.Buffer
+	writeMailHeader(&buffer, "From", formatAddress(message.Sender))
+	writeMailHeader(&buffer, "To", strings.Join(recipients, ", "))
+	writeMailHeader(&buffer, "Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	writeMailHeader(&buffer, "Date", date.Format(time.RFC1123Z))
+	writeMailHeader(&buffer, "Message-ID", messageId)
+	headerNames := make([]string, 0, len(message.Headers))
+	for name := range message.Headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	for _, name := range headerNames {
+		for _, value := range message.Headers[name] {
+			writeMailHeader(&buffer, name, value)
+		}
+	}
+	writeMailHeader(&buffer, "MIME-Version", "1.0")
+
+	body := message.bodyEntity()
+	for _, name := range mailEntityHeaderNames {
+		if value := body.header().Get(name); value != "" {
+			writeMailHeader(&buffer, name, value)
+		}
+	}
+	io.WriteString(&buffer, "\r\n")
+	if err := body.writeBody(&buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (message *MailMessage) bodyEntity() mailEntity {
+	var body mailEntity
+	if message.Body != "" && message.HTMLBody != "" {
+		body = newMultipartMailEntity("alternative",
+			&textMailEntity{"text/plain", message.Body},
+			&textMailEntity{"text/html", message.HTMLBody})
+	} else if message.HTMLBody != "" {
+		body = &textMailEntity{"text/html", message.HTMLBody}
+	} else {
+		body = &textMailEntity{"text/plain", message.Body}
+	}
+	var inlineEntities, attachmentEntities []mailEntity
+	for _, attachment := range message.Attachments {
+		if attachment.ContentId != "" {
+			inlineEntities = append(inlineEntities, &attachmentMailEntity{attachment})
+		} else {
+			attachmentEntities = append(attachmentEntities, &attachmentMailEntity{attachment})
+		}
+	}
+	if len(inlineEntities) > 0 {
+		body = newMultipartMailEntity("related", append([]mailEntity{body}, inlineEntities...)...)
+	}
+	if len(attachmentEntities) > 0 {
+		body = newMultipartMailEntity("mixed", append([]mailEntity{body}, attachmentEntities...)...)
+	}
+	return body
+}
+
+// A MIME entity, i.e. Content-* headers and a body.
+type mailEntity interface {
+	header() textproto.MIMEHeader
+	writeBody(w io.Writer) error
+}
+
+// Order that entity headers are written in at the top level of the message
+// (multipart.Writer sorts them for nested parts).
+var mailEntityHeaderNames = []string{
+	"Content-Type", "Content-Disposition", "Content-Id", "Content-Transfer-Encoding",
+}
+
+type textMailEntity struct {
+	contentType string
+	text        string
+}
+
+func (e *textMailEntity) header() textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", e.contentType+"; charset=utf-8")
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return header
+}
+
+func (e *textMailEntity) writeBody(w io.Writer) error {
+	return writeQuotedPrintable(w, e.text)
+}
+
+type attachmentMailEntity struct {
+	attachment *MailAttachment
+}
+
+func (e *attachmentMailEntity) header() textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader)
+	contentType := e.attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"name": e.attachment.Name}))
+	disposition := "attachment"
+	if e.attachment.ContentId != "" {
+		disposition = "inline"
+		header.Set("Content-Id", "<"+e.attachment.ContentId+">")
+	}
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": e.attachment.Name}))
+	header.Set("Content-Transfer-Encoding", "base64")
+	return header
+}
+
+// Base64 lines are limited to 76 characters (RFC 2045), each of which encodes
+// 57 bytes.
+const (
+	Base64LineLength = 76
+	Base64LineBytes  = 57
+)
+
+func (e *attachmentMailEntity) writeBody(w io.Writer) error {
+	encoded := base64.StdEncoding.EncodeToString(e.attachment.Data)
+	for len(encoded) > Base64LineLength {
+		if _, err := io.WriteString(w, encoded[:Base64LineLength]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[Base64LineLength:]
+	}
+	_, err := io.WriteString(w, encoded+"\r\n")
+	return err
+}
+
+// base64EncodedLength returns the size of an attachment's body (as written
+// by writeBody), including line breaks.
+func base64EncodedLength(dataLength int) int {
+	encodedLength := base64.StdEncoding.EncodedLen(dataLength)
+	lineCount := (encodedLength + Base64LineLength - 1) / Base64LineLength
+	if lineCount == 0 {
+		lineCount = 1
+	}
+	return encodedLength + lineCount*2
+}
+
+// maxBase64DataLength is the inverse of base64EncodedLength: the most data
+// whose encoding fits in encodedLength.
+func maxBase64DataLength(encodedLength int) int {
+	lineCount := encodedLength / (Base64LineLength + 2)
+	dataLength := lineCount * Base64LineBytes
+	if remainder := encodedLength%(Base64LineLength+2) - 2; remainder > 0 {
+		dataLength += remainder / 4 * 3
+	}
+	return dataLength
+}
+
+type multipartMailEntity struct {
+	subtype  string
+	boundary string
+	parts    []mailEntity
+}
+
+func newMultipartMailEntity(subtype string, parts ...mailEntity) *multipartMailEntity {
+	// The boundary needs to be known before the body is written, so generate
+	// one up front.
+	boundary := multipart.NewWriter(ioutil.Discard).Boundary()
+	return &multipartMailEntity{subtype, boundary, parts}
+}
+
+func (e *multipartMailEntity) header() textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Type", fmt.Sprintf("multipart/%s; boundary=%s", e.subtype, e.boundary))
+	return header
+}
+
+func (e *multipartMailEntity) writeBody(w io.Writer) error {
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(e.boundary); err != nil {
+		return err
+	}
+	for _, part := range e.parts {
+		partWriter, err := writer.CreatePart(part.header())
+		if err != nil {
+			return err
+		}
+		if err := part.writeBody(partWriter); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func writeMailHeader(w io.Writer, name string, value string) {
+	fmt.Fprintf(w, "%s: %s\r\n", name, value)
+}
+
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qpWriter := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qpWriter, text); err != nil {
+		return err
+	}
+	return qpWriter.Close()
+}
+
+// Normalizes "Name <address>" strings, RFC 2047-encoding the name if needed.
+func formatAddress(address string) string {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return address
+	}
+	return parsed.String()
+}
+
+// archiveSender is the address that a team's archives (and digests) are sent
+// from. Team names can have commas, quotes or angle brackets, so the name is
+// quoted (or encoded) as needed.
+func archiveSender(teamName string) string {
+	sender := mail.Address{Name: teamName + " Slack Archive", Address: mailConfig.ArchiveSenderAddress}
+	return sender.String()
+}
+
+func parseEmailAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Address, nil
+}
+
+// Domain to use for Message-IDs and List-IDs, so that they're globally unique.
+func emailAddressDomain(address string) string {
+	if parsedAddress, err := parseEmailAddress(address); err == nil {
+		if atIndex := strings.LastIndex(parsedAddress, "@"); atIndex != -1 {
+			return strings.ToLower(parsedAddress[atIndex+1:])
+		}
+	}
+	return "slack-archive"
+}
+
+func newMessageId(sender string) (string, error) {
+	domain := emailAddressDomain(sender)
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(randomBytes), domain), nil
+}
+
+type SmtpMailer struct {
+	config SmtpConfig
+}
+
+func (m *SmtpMailer) Send(c context.Context, message *MailMessage) error {
+	messageBytes, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	senderAddress, err := parseEmailAddress(message.Sender)
+	if err != nil {
+		return fmt.Errorf("Invalid sender %s: %s", message.Sender, err.Error())
+	}
+
+	port := m.config.Port
+	security := m.config.Security
+	if security == "" {
+		security = SmtpSecurityStartTLS
+	}
+	if port == 0 {
+		if security == SmtpSecurityTLS {
+			port = 465
+		} else {
+			port = 587
+		}
+	}
+	address := net.JoinHostPort(m.config.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: m.config.Host}
+	dialer := &net.Dialer{Timeout: time.Second * 30}
+	var conn net.Conn
+	switch security {
+	case SmtpSecurityTLS:
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	case SmtpSecurityStartTLS, SmtpSecurityNone:
+		conn, err = dialer.DialContext(c, "tcp", address)
+	default:
+		return fmt.Errorf("Unknown SMTP security mode: %s", security)
+	}
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if security == SmtpSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server %s does not support STARTTLS", address)
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if m.config.Username != "" {
+		auth := smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+		if err = client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(senderAddress); err != nil {
+		return err
+	}
+	for _, to := range message.To {
+		toAddress, err := parseEmailAddress(to)
+		if err != nil {
+			return fmt.Errorf("Invalid recipient %s: %s", to, err.Error())
+		}
+		if err = client.Rcpt(toAddress); err != nil {
+			return err
+		}
+	}
+	dataWriter, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = dataWriter.Write(messageBytes); err != nil {
+		return err
+	}
+	if err = dataWriter.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Writes each message as an .eml file in a directory, for delivery by some
+// other process (or for debugging).
+type FileDropMailer struct {
+	directory string
+}
+
+func (m *FileDropMailer) Send(c context.Context, message *MailMessage) error {
+	messageBytes, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.directory, 0700); err != nil {
+		return err
+	}
+	randomBytes := make([]byte, 4)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return err
+	}
+	fileName := fmt.Sprintf("%s-%s.eml",
+		time.Now().UTC().Format("20060102-150405"), hex.EncodeToString(randomBytes))
+	// Write to a temporary name first so that a process watching the
+	// directory never sees partial messages.
+	tempPath := filepath.Join(m.directory, "."+fileName+".tmp")
+	if err := ioutil.WriteFile(tempPath, messageBytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, filepath.Join(m.directory, fileName))
+}
+
+// Logs messages instead of sending them, the equivalent of running
+// dev_appserver.py without --enable_sendmail.
+type LogMailer struct{}
+
+func (m *LogMailer) Send(c context.Context, message *MailMessage) error {
+	logInfof(c, "Not sending mail from %s to %s with subject \"%s\" (%d bytes of HTML, %d attachments)",
+		message.Sender, strings.Join(message.To, ", "), message.Subject, len(message.HTMLBody), len(message.Attachments))
+	return nil
+}
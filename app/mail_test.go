@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMailMessageBytesAlternative(t *testing.T) {
+	message := &MailMessage{
+		Sender:   "Équipe Slack Archive <archive@example.com>",
+		To:       []string{"user@example.com"},
+		Subject:  "#général Archive",
+		Body:     "plain body",
+		HTMLBody: "<b>html body</b>",
+	}
+	messageBytes, err := message.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(messageBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != message.Subject {
+		t.Errorf("Subject: got %q (%v), want %q", subject, err, message.Subject)
+	}
+	from, err := parsed.Header.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Name != "Équipe Slack Archive" || from[0].Address != "archive@example.com" {
+		t.Errorf("From: got %v (%v)", from, err)
+	}
+	if !strings.HasSuffix(parsed.Header.Get("Message-ID"), "@example.com>") {
+		t.Errorf("Message-ID: got %s", parsed.Header.Get("Message-ID"))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type: got %s (%v)", mediaType, err)
+	}
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, expected := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", message.Body},
+		{"text/html; charset=utf-8", message.HTMLBody},
+	} {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(part)
+		if part.Header.Get("Content-Type") != expected.contentType || string(body) != expected.body {
+			t.Errorf("Part: got %s %q, want %s %q",
+				part.Header.Get("Content-Type"), body, expected.contentType, expected.body)
+		}
+	}
+}
+
+func TestFileDropMailer(t *testing.T) {
+	directory := t.TempDir()
+	mailer, err := newMailerFromConfig(MailConfig{Transport: MailTransportFile, FileDropDirectory: directory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &MailMessage{
+		Sender:   "archive@example.com",
+		To:       []string{"user@example.com"},
+		Subject:  "Archive",
+		HTMLBody: "<p>body</p>",
+	}
+	if err := mailer.Send(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	fileNames, err := filepath.Glob(filepath.Join(directory, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fileNames) != 1 || filepath.Ext(fileNames[0]) != ".eml" {
+		t.Fatalf("Expected a single .eml file, got %v", fileNames)
+	}
+	messageBytes, _ := ioutil.ReadFile(fileNames[0])
+	parsed, err := mail.ReadMessage(bytes.NewReader(messageBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(parsed.Body)
+	if parsed.Header.Get("Content-Type") != "text/html; charset=utf-8" || string(body) != message.HTMLBody {
+		t.Errorf("Got %s %q", parsed.Header.Get("Content-Type"), body)
+	}
+}
+
+func TestMailMessageBytesHeaders(t *testing.T) {
+	message := &MailMessage{
+		Sender:    "archive@example.com",
+		To:        []string{"user@example.com"},
+		HTMLBody:  "<b>html body</b>",
+		MessageId: "<20260301.C123.T456@example.com>",
+		Headers: mail.Header{
+			"In-Reply-To": {"<20260228.C123.T456@example.com>"},
+			"List-Id":     {"\"#general Archive\" <c123.t456.example.com>"},
+		},
+	}
+	messageBytes, err := message.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(messageBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header.Get("Message-ID") != message.MessageId {
+		t.Errorf("Message-ID: got %q", parsed.Header.Get("Message-ID"))
+	}
+	for name, values := range message.Headers {
+		if parsed.Header.Get(name) != values[0] {
+			t.Errorf("%s: got %q, want %q", name, parsed.Header.Get(name), values[0])
+		}
+	}
+}
+
+// Mailer that generates its own Message-IDs, like App Engine's mail API.
+type messageIdGeneratingMailer struct {
+	LogMailer
+}
+
+func (m *messageIdGeneratingMailer) GeneratesMessageIds() bool {
+	return true
+}
+
+func TestSentMessageId(t *testing.T) {
+	message := &MailMessage{MessageId: "<20260304.C1.T1@example.com>"}
+	if messageId := sentMessageId(&LogMailer{}, message); messageId != message.MessageId {
+		t.Errorf("Got %q", messageId)
+	}
+	if messageId := sentMessageId(&messageIdGeneratingMailer{}, message); messageId != "" {
+		t.Errorf("Generated Message-ID: got %q", messageId)
+	}
+}
+
+func TestArchiveSender(t *testing.T) {
+	initTestApp(t)
+	for _, teamName := range []string{"Example", "Smith, Jones & Co", "The \"Best\" <Team>", "Équipe"} {
+		sender := archiveSender(teamName)
+		parsed, err := mail.ParseAddress(sender)
+		if err != nil {
+			t.Errorf("Could not parse sender for %q: %s", teamName, err)
+			continue
+		}
+		if parsed.Name != teamName+" Slack Archive" || parsed.Address != defaultMailConfig.ArchiveSenderAddress {
+			t.Errorf("Sender for %q: got %q <%s>", teamName, parsed.Name, parsed.Address)
+		}
+	}
+}
+
+func TestMailMessageBytesAttachments(t *testing.T) {
+	message := &MailMessage{
+		Sender:   "archive@example.com",
+		To:       []string{"user@example.com"},
+		Body:     "plain body",
+		HTMLBody: "<img src=\"cid:thumbnail-F1@slack-archive\">",
+		Attachments: []*MailAttachment{
+			{Name: "F1.png", ContentType: "image/png", Data: []byte("png data"), ContentId: "thumbnail-F1@slack-archive"},
+			{Name: "report.pdf", ContentType: "application/pdf", Data: []byte("pdf data")},
+		},
+	}
+	messageBytes, err := message.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(messageBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expected structure:
+	// multipart/mixed
+	//   multipart/related
+	//     multipart/alternative
+	//     image/png (inline)
+	//   application/pdf (attachment)
+	mixedParts := readTestMultipart(t, parsed.Header.Get("Content-Type"), parsed.Body, "multipart/mixed")
+	if len(mixedParts) != 2 {
+		t.Fatalf("Expected 2 mixed parts, got %d", len(mixedParts))
+	}
+	relatedParts := readTestMultipart(t, mixedParts[0].header.Get("Content-Type"),
+		bytes.NewReader(mixedParts[0].body), "multipart/related")
+	if len(relatedParts) != 2 {
+		t.Fatalf("Expected 2 related parts, got %d", len(relatedParts))
+	}
+	alternativeParts := readTestMultipart(t, relatedParts[0].header.Get("Content-Type"),
+		bytes.NewReader(relatedParts[0].body), "multipart/alternative")
+	if len(alternativeParts) != 2 || string(alternativeParts[1].body) != message.HTMLBody {
+		t.Errorf("Unexpected alternative parts: %v", alternativeParts)
+	}
+	for _, test := range []struct {
+		part        testMailPart
+		attachment  *MailAttachment
+		disposition string
+		contentId   string
+	}{
+		{relatedParts[1], message.Attachments[0], "inline", "<thumbnail-F1@slack-archive>"},
+		{mixedParts[1], message.Attachments[1], "attachment", ""},
+	} {
+		mediaType, params, _ := mime.ParseMediaType(test.part.header.Get("Content-Type"))
+		if mediaType != test.attachment.ContentType || params["name"] != test.attachment.Name {
+			t.Errorf("%s: Content-Type: got %s", test.attachment.Name, test.part.header.Get("Content-Type"))
+		}
+		disposition, params, _ := mime.ParseMediaType(test.part.header.Get("Content-Disposition"))
+		if disposition != test.disposition || params["filename"] != test.attachment.Name {
+			t.Errorf("%s: Content-Disposition: got %s", test.attachment.Name, test.part.header.Get("Content-Disposition"))
+		}
+		if test.part.header.Get("Content-Id") != test.contentId {
+			t.Errorf("%s: Content-Id: got %s", test.attachment.Name, test.part.header.Get("Content-Id"))
+		}
+		// multipart.Reader does not decode base64, only quoted-printable.
+		data, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(test.part.body), "\r\n", ""))
+		if err != nil || string(data) != string(test.attachment.Data) {
+			t.Errorf("%s: data: got %q (%v)", test.attachment.Name, data, err)
+		}
+	}
+}
+
+type testMailPart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func readTestMultipart(t *testing.T, contentType string, body io.Reader, expectedMediaType string) []testMailPart {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != expectedMediaType {
+		t.Fatalf("Content-Type: got %s (%v), want %s", mediaType, err, expectedMediaType)
+	}
+	reader := multipart.NewReader(body, params["boundary"])
+	var parts []testMailPart
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		partBody, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, testMailPart{part.Header, partBody})
+	}
+	return parts
+}
+
+func TestBase64EncodedLength(t *testing.T) {
+	for _, dataLength := range []int{0, 1, 5, 56, 57, 58, 114, 1000, 1024 * 1024} {
+		entity := &attachmentMailEntity{&MailAttachment{Name: "a.bin", Data: make([]byte, dataLength)}}
+		var body bytes.Buffer
+		if err := entity.writeBody(&body); err != nil {
+			t.Fatal(err)
+		}
+		if actual := base64EncodedLength(dataLength); actual != body.Len() {
+			t.Errorf("base64EncodedLength(%d): got %d, want %d", dataLength, actual, body.Len())
+		}
+		if actual := maxBase64DataLength(body.Len()); actual < dataLength || base64EncodedLength(actual) > body.Len() {
+			t.Errorf("maxBase64DataLength(%d): got %d for %d bytes of data", body.Len(), actual, dataLength)
+		}
+	}
+}
@@ -1,23 +1,21 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	log_ "log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/delay"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/mail"
-	"google.golang.org/appengine/urlfetch"
-
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/slack-go/slack"
@@ -32,17 +30,48 @@ var styles map[string]template.CSS
 var templates map[string]*Template
 var fileUrlRefEncryptionKey []byte
 var emojiByShortName map[string]*Emoji
+var accountStore AccountStore
+var archiveLog ArchiveLogStore
+var messageStore MessageStore
+var mailer Mailer
+var mailConfig MailConfig
+var cache Cache
 
 func main() {
-	styles = loadStyles()
-	templates = loadTemplates()
+	// Commands set up what they need themselves, so that e.g. archiving with
+	// a token works without the server's config or database.
+	if runCommandLine() {
+		return
+	}
+	initPlatform()
+	initRendering()
+	if configuredMailer, err := newMailerFromConfig(mailConfig); err != nil {
+		log_.Panicf("Could not initialize mail transport: %s", err.Error())
+	} else if configuredMailer != nil {
+		mailer = configuredMailer
+	}
 	timezones = initTimezones()
 	sessionStore, sessionConfig = initSession()
 	slackOAuthConfig = initSlackOAuthConfig()
 	fileUrlRefEncryptionKey = loadFileUrlRefEncryptionKey()
+
+	http.Handle("/", router)
+
+	runServer()
+}
+
+// initRendering loads what's needed to render archives, which the
+// command-line tools share with the server.
+func initRendering() {
+	mailConfig = loadMailConfig()
+	styles = loadStyles()
+	templates = loadTemplates()
 	emojiByShortName = loadEmoji()
+	router = initRouter()
+}
 
-	router = mux.NewRouter()
+func initRouter() *mux.Router {
+	router := mux.NewRouter()
 	router.Handle("/", AppHandler(indexHandler)).Name("index")
 
 	router.Handle("/session/sign-in", AppHandler(signInHandler)).Name("sign-in").Methods("POST")
@@ -52,16 +81,27 @@ func main() {
 	router.Handle("/archive/send", SignedInAppHandler(sendArchiveHandler)).Name("send-archive").Methods("POST")
 	router.Handle("/archive/cron", AppHandler(archiveCronHandler))
 	router.Handle("/archive/conversation/send", SignedInAppHandler(sendConversationArchiveHandler)).Name("send-conversation-archive").Methods("POST")
+	router.Handle("/archive/conversation/cadence", SignedInAppHandler(saveConversationCadenceHandler)).Name("save-conversation-cadence").Methods("POST")
+	router.Handle("/archive/conversation/included", SignedInAppHandler(saveConversationIncludedHandler)).Name("save-conversation-included").Methods("POST")
 	router.Handle("/archive/conversation/{type}/{ref}", SignedInAppHandler(conversationArchiveHandler)).Name("conversation-archive")
+	router.Handle("/archive/conversation/{type}/{ref}/mbox", SignedInAppHandler(exportConversationMboxHandler)).Name("export-conversation-mbox")
+	router.Handle("/search", SignedInAppHandler(searchHandler)).Name("search")
 	router.Handle("/archive/file-thumbnail/{ref}", AppHandler(archiveFileThumbnailHandler)).Name("archive-file-thumbnail")
 
 	router.Handle("/account/settings", SignedInAppHandler(settingsHandler)).Name("settings").Methods("GET")
 	router.Handle("/account/settings", SignedInAppHandler(saveSettingsHandler)).Name("save-settings").Methods("POST")
+	router.Handle("/account/catch-up", SignedInAppHandler(catchUpHandler)).Name("catch-up").Methods("POST")
+	router.Handle("/account/backfill", SignedInAppHandler(startBackfillHandler)).Name("start-backfill").Methods("POST")
+	router.Handle("/account/backfill/cancel", SignedInAppHandler(cancelBackfillHandler)).Name("cancel-backfill").Methods("POST")
+	router.Handle("/account/backfill/conversations", SignedInAppHandler(backfillConversationsHandler)).Name("backfill-conversations").Methods("GET")
+	router.Handle("/account/export-site", SignedInAppHandler(exportSiteHandler)).Name("export-site").Methods("GET")
+	router.Handle("/account/export-slack", SignedInAppHandler(exportSlackHandler)).Name("export-slack").Methods("GET")
+	router.Handle("/account/export-markdown", SignedInAppHandler(exportMarkdownHandler)).Name("export-markdown").Methods("GET")
 	router.Handle("/account/delete", SignedInAppHandler(deleteAccountHandler)).Name("delete-account").Methods("POST")
 
-	http.Handle("/", router)
+	router.Handle("/admin/backfill", AdminAppHandler(adminBackfillHandler)).Methods("POST")
 
-	appengine.Main()
+	return router
 }
 
 func indexHandler(w http.ResponseWriter, r *http.Request) *AppError {
@@ -73,7 +113,7 @@ func indexHandler(w http.ResponseWriter, r *http.Request) *AppError {
 		}
 		return templates["index-signed-out"].Render(w, data)
 	}
-	c := appengine.NewContext(r)
+	c := newContext(r)
 	account, err := getAccount(c, userId)
 	if account == nil {
 		// Can't look up the account, session cookie must be invalid, clear it.
@@ -107,13 +147,15 @@ func indexHandler(w http.ResponseWriter, r *http.Request) *AppError {
 
 	var settingsSummary = map[string]interface{}{
 		"EmailAddress":      emailAddress,
-		"ConversationCount": len(conversations.AllConversations),
+		"ConversationCount": len(includedConversations(conversations.AllConversations, account)),
 	}
 	var data = map[string]interface{}{
-		"User":            user,
-		"Team":            team,
-		"Conversations":   conversations,
-		"SettingsSummary": settingsSummary,
+		"User":                user,
+		"Team":                team,
+		"Conversations":       conversations,
+		"ConversationFilters": &account.ConversationFilters,
+		"ArchiveGaps":         archiveGaps(account, conversations.AllConversations),
+		"SettingsSummary":     settingsSummary,
 	}
 	return templates["index"].Render(w, data, &AppSignedInState{
 		Account:        account,
@@ -169,8 +211,8 @@ func signOutHandler(w http.ResponseWriter, r *http.Request) *AppError {
 }
 
 func slackOAuthCallbackHandler(w http.ResponseWriter, r *http.Request) *AppError {
-	c := appengine.NewContext(r)
-	httpClient := urlfetch.Client(c)
+	c := newContext(r)
+	httpClient := &http.Client{Transport: newTransport(c)}
 
 	code := r.FormValue("code")
 	redirectUrl := AbsolutePathUrl(r.URL.Path)
@@ -181,7 +223,7 @@ func slackOAuthCallbackHandler(w http.ResponseWriter, r *http.Request) *AppError
 		return InternalError(err, "Could not exchange OAuth code")
 	}
 
-	slackClient := slack.New(token)
+	slackClient := newSlackClient(c, token)
 	authTest, err := slackClient.AuthTest()
 	if err != nil {
 		return SlackFetchError(err, "user")
@@ -206,15 +248,16 @@ func slackOAuthCallbackHandler(w http.ResponseWriter, r *http.Request) *AppError
 		}
 	}
 	if !isAllowedTeam {
-		log.Warningf(c, "Non-whitelisted team %s used", authTest.Team)
+		logWarningf(c, "Non-whitelisted team %s used", authTest.Team)
 		return templates["team-not-on-whitelist"].Render(w, map[string]interface{}{})
 	}
 
 	account, err := getAccount(c, authTest.UserID)
-	if err != nil && err != datastore.ErrNoSuchEntity {
+	if err != nil && err != ErrNoSuchAccount {
 		return InternalError(err, "Could not look up user")
 	}
-	if account == nil {
+	newAccount := account == nil
+	if newAccount {
 		timezoneName := ""
 		if user, err := slackClient.GetUserInfo(authTest.UserID); err == nil && len(user.TZ) > 0 {
 			if _, err := time.LoadLocation(user.TZ); err == nil {
@@ -228,15 +271,27 @@ func slackOAuthCallbackHandler(w http.ResponseWriter, r *http.Request) *AppError
 			TimezoneName:  timezoneName,
 		}
 	}
-	account.ApiToken = token
 	// Persist the default email address now, both to avoid additional lookups
 	// later and to have a way to contact the user if they ever revoke their
 	// OAuth token.
 	emailAddress, err := account.GetDigestEmailAddress(slackClient)
-	if err == nil && len(emailAddress) > 0 {
-		account.DigestEmailAddress = emailAddress
+	if err != nil {
+		emailAddress = ""
+	}
+	update := func(account *Account) error {
+		account.ApiToken = token
+		if len(emailAddress) > 0 {
+			account.DigestEmailAddress = emailAddress
+		}
+		return nil
+	}
+	if newAccount {
+		update(account)
+		err = account.Put(c)
+	} else {
+		// Existing accounts may be updated by archive tasks at the same time.
+		err = accountStore.Update(c, account.SlackUserId, update)
 	}
-	err = account.Put(c)
 	if err != nil {
 		return InternalError(err, "Could not save user")
 	}
@@ -267,143 +322,409 @@ func conversationArchiveHandler(w http.ResponseWriter, r *http.Request, state *A
 		return SlackFetchError(err, "conversation")
 	}
 
-	archive, err := newConversationArchive(conversation, state.SlackClient, state.Account, r.FormValue("dev") == "1")
+	// Show what the archive would cover if it were sent now (unless in dev
+	// mode, when the last 24 hours are shown).
+	var archive *ConversationArchive
+	var logEntry *ArchiveLogEntry
+	cadence := state.Account.CadenceForConversation(conversation.Id())
+	if r.FormValue("dev") == "1" {
+		archive, err = newConversationArchive(conversation, state.SlackClient, state.Account, true)
+	} else {
+		startDay, endDay := cadenceRange(cadence, currentArchiveDay(state.Account))
+		archive, err = newConversationArchiveForDays(conversation, state.SlackClient, state.Account, startDay, endDay)
+		logEntry, _ = sentArchiveLogEntry(
+			newContext(r), state.Account.SlackUserId, conversation.Id(), ArchivePeriod{startDay, endDay})
+	}
 	if err != nil {
 		return SlackFetchError(err, "archive")
 	}
 
+	cadenceOverride := ""
+	for _, override := range state.Account.ConversationCadences {
+		if override.ConversationId == conversation.Id() {
+			cadenceOverride = override.Cadence
+		}
+	}
 	var data = map[string]interface{}{
 		"Conversation":        conversation,
 		"ConversationType":    conversationType,
 		"ConversationRef":     ref,
 		"ConversationArchive": archive,
+		"CadenceOverride":     cadenceOverride,
+		"DefaultCadence":      state.Account.CadenceDisplayName(),
+		"ArchiveLogEntry":     logEntry,
+		"TimezoneLocation":    state.Account.TimezoneLocation,
+		"ExportMaxDate":       currentArchiveDay(state.Account).Format(ArchiveDayFormat),
+		"ExportMaxDays":       WebExportMaxDays,
 	}
 	return templates["conversation-archive-page"].Render(w, data, state)
 }
 
+// The export is buffered, so that an error page can still be shown if
+// fetching one of the days fails.
+func exportConversationMboxHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
+	vars := mux.Vars(r)
+	conversation, err := getConversationFromRef(vars["type"], vars["ref"], state.SlackClient)
+	if err != nil {
+		return SlackFetchError(err, "conversation")
+	}
+	startDay, endDay, err := parseWebExportDays(r.FormValue("start_date"), r.FormValue("end_date"), state.Account)
+	if err != nil {
+		return BadRequest(err, err.Error())
+	}
+	var mbox bytes.Buffer
+	perThread := r.FormValue("per_thread") == "true"
+	_, err = exportConversationMbox(newLimitedWriter(&mbox, WebExportMaxBytes),
+		conversation, state.Account, startDay, endDay, perThread, newContext(r))
+	if err == ErrExportTooLarge {
+		return BadRequest(err, exportTooLargeMessage("export-mbox"))
+	}
+	if err != nil {
+		return SlackFetchError(err, "archives")
+	}
+	w.Header().Set("Content-Type", "application/mbox")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(
+		"attachment; filename=\"%s\"", exportFileName(conversation, startDay, endDay, "mbox")))
+	w.Write(mbox.Bytes())
+	return nil
+}
+
+func saveConversationCadenceHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
+	conversationType := r.FormValue("conversation_type")
+	ref := r.FormValue("conversation_ref")
+	conversation, err := getConversationFromRef(conversationType, ref, state.SlackClient)
+	if err != nil {
+		return SlackFetchError(err, "conversation")
+	}
+	cadence := r.FormValue("cadence")
+	if cadence != "" && !isValidCadence(cadence) {
+		err := fmt.Errorf("Unknown cadence: %s", cadence)
+		return BadRequest(err, err.Error())
+	}
+	c := newContext(r)
+	err = accountStore.Update(c, state.Account.SlackUserId, func(account *Account) error {
+		account.SetConversationCadence(conversation.Id(), cadence)
+		return nil
+	})
+	if err != nil {
+		return InternalError(err, "Could not save user")
+	}
+	state.AddFlash("Archive frequency saved.")
+	return RedirectToRoute("conversation-archive", "type", conversationType, "ref", ref)
+}
+
+func saveConversationIncludedHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
+	conversationId := r.FormValue("conversation_id")
+	if conversationId == "" {
+		return BadRequest(errors.New("Missing conversation_id"), "Missing conversation")
+	}
+	included := r.FormValue("included")
+	if included != "" && included != "true" && included != "false" {
+		err := fmt.Errorf("Malformed included value: %s", included)
+		return BadRequest(err, err.Error())
+	}
+	c := newContext(r)
+	err := accountStore.Update(c, state.Account.SlackUserId, func(account *Account) error {
+		account.ConversationFilters.SetIncluded(conversationId, included)
+		return nil
+	})
+	if err != nil {
+		return InternalError(err, "Could not save user")
+	}
+	return RedirectToRoute("index")
+}
+
 func sendArchiveHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
-	c := appengine.NewContext(r)
-	sentCount, err := sendArchive(state.Account, c)
+	c := newContext(r)
+	force := r.FormValue("force") == "true"
+	if state.Account.CombinedDigest {
+		conversationCount, err := sendArchiveDigest(
+			state.Account, currentArchiveDay(state.Account), false, force, c)
+		if err == ErrArchiveAlreadySent {
+			state.AddFlash("Today's digest was already sent, check \"Resend\" to send it again.")
+			return RedirectToRoute("index")
+		}
+		if err != nil {
+			return InternalError(err, "Could not send digest")
+		}
+		if conversationCount > 0 {
+			state.AddFlash(fmt.Sprintf("%s a digest of %s!",
+				state.Account.ArchiveDeliveryVerb(), pluralize(conversationCount, "conversation")))
+		} else {
+			state.AddFlash("No digest was sent, it was either empty or disabled.")
+		}
+		return RedirectToRoute("index")
+	}
+	sentCount, alreadySentCount, err := sendArchive(state.Account, force, c)
 	if err != nil {
 		return InternalError(err, "Could not send archive")
 	}
 	if sentCount > 0 {
+		verb := state.Account.ArchiveDeliveryVerb()
 		if sentCount == 1 {
-			state.AddFlash("Emailed 1 archive!")
+			state.AddFlash(fmt.Sprintf("%s 1 archive!", verb))
 		} else {
-			state.AddFlash(fmt.Sprintf("Emailed %d archives!", sentCount))
+			state.AddFlash(fmt.Sprintf("%s %d archives!", verb, sentCount))
 		}
+	} else if alreadySentCount > 0 {
+		state.AddFlash(fmt.Sprintf("No archives were sent, %s had already been sent (check \"Resend\" to send them again).",
+			pluralize(alreadySentCount, "archive")))
 	} else {
 		state.AddFlash("No archives were sent, they were either all empty or disabled.")
 	}
 	return RedirectToRoute("index")
 }
 
+// catchUpHandler runs the scheduled send for the account, which also sends
+// any archives that were missed.
+func catchUpHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
+	c := newContext(r)
+	err := sendArchiveFunc.Call(c, state.Account.SlackUserId)
+	if err != nil {
+		return InternalError(err, "Could not enqueue missed archives")
+	}
+	state.AddFlash("Missed archives will be sent shortly.")
+	return RedirectToRoute("index")
+}
+
 func archiveCronHandler(w http.ResponseWriter, r *http.Request) *AppError {
-	c := appengine.NewContext(r)
-	accounts, err := getAllAccounts(c)
+	c := newContext(r)
+	err := runArchiveCron(c)
 	if err != nil {
 		return InternalError(err, "Could not look up accounts")
 	}
+	fmt.Fprint(w, "Done")
+	return nil
+}
+
+func runArchiveCron(c context.Context) error {
+	accounts, err := getAllAccounts(c)
+	if err != nil {
+		return err
+	}
 	for _, account := range accounts {
 		now := time.Now().In(account.TimezoneLocation)
 		oneHourAgo := now.Add(-time.Hour)
 		if now.Day() != oneHourAgo.Day() {
-			log.Infof(c, "Enqueing task for %s...", account.SlackUserId)
+			logInfof(c, "Enqueing task for %s...", account.SlackUserId)
 			sendArchiveFunc.Call(c, account.SlackUserId)
 		}
+		if err := resumeStalledBackfill(c, &account); err != nil {
+			logErrorf(c, "Could not resume backfill for %s: %s", account.SlackUserId, err.Error())
+		}
 	}
-	fmt.Fprint(w, "Done")
 	return nil
 }
 
-var sendArchiveFunc = delay.Func(
-	"sendArchive",
-	func(c context.Context, slackUserId string) error {
-		log.Infof(c, "Sending digest for %s...", slackUserId)
-		account, err := getAccount(c, slackUserId)
-		if err != nil {
-			log.Errorf(c, "  Error looking up account: %s", err.Error())
-			return err
+var sendArchiveFunc = newTaskFunc("sendArchive", sendScheduledArchive)
+
+// sendScheduledArchive sends the digest or enqueues the conversation archives
+// that are due today, along with ones that were missed.
+func sendScheduledArchive(c context.Context, slackUserId string) error {
+	logInfof(c, "Sending digest for %s...", slackUserId)
+	account, err := getAccount(c, slackUserId)
+	if err != nil {
+		logErrorf(c, "  Error looking up account: %s", err.Error())
+		return err
+	}
+	today := currentArchiveDay(account)
+	if account.CombinedDigest {
+		// Digests that were missed (e.g. because the cron didn't run) are
+		// sent too.
+		days := missedDigestDays(account.LastArchivedEndTime, today)
+		if len(days) > CatchUpMaxArchivesPerRun {
+			logInfof(c, "  Leaving %d missed digests for later runs.", len(days)-CatchUpMaxArchivesPerRun)
+			days = days[:CatchUpMaxArchivesPerRun]
 		}
-		slackClient := account.NewSlackClient(c)
-		conversations, err := getConversations(slackClient, account)
-		if err != nil {
-			log.Errorf(c, "  Error looking up conversations: %s", err.Error())
-			if !appengine.IsDevAppServer() {
-				sendArchiveErrorMail(err, c, slackUserId)
+		for _, day := range days {
+			conversationCount, err := sendArchiveDigest(account, day, true, false, c)
+			if err == ErrArchiveAlreadySent {
+				logInfof(c, "  Not sent, digest for %s was already sent.", day.Format(ArchiveDayFormat))
+			} else if err != nil {
+				logErrorf(c, "  Error sending digest: %s", err.Error())
+				if !isDevServer() {
+					sendArchiveErrorMail(err, c, slackUserId)
+				}
+				return err
+			} else if conversationCount > 0 {
+				logInfof(c, "  Sent digest of %d conversations for %s.",
+					conversationCount, day.Format(ArchiveDayFormat))
+			} else {
+				logInfof(c, "  Not sent, digest for %s was empty.", day.Format(ArchiveDayFormat))
 			}
-			return err
+			err = recordScheduledArchiveRun(c, slackUserId, day.Add(-time.Second))
+			if err != nil {
+				logErrorf(c, "  Error recording digest: %s", err.Error())
+				return err
+			}
+		}
+		return nil
+	}
+	slackClient := account.NewSlackClient(c)
+	conversations, err := getConversations(slackClient, account)
+	if err != nil {
+		logErrorf(c, "  Error looking up conversations: %s", err.Error())
+		if !isDevServer() {
+			sendArchiveErrorMail(err, c, slackUserId)
+		}
+		return err
+	}
+	if len(conversations.AllConversations) > 0 {
+		included := includedConversations(conversations.AllConversations, account)
+		duePeriods := make(map[string][]ArchivePeriod)
+		skippedEndTimes := make(map[string]time.Time)
+		for _, conversation := range included {
+			cadence := account.CadenceForConversation(conversation.Id())
+			lastEndTime := account.ConversationLastArchivedEndTime(conversation.Id())
+			// Includes the archive that's due today, and any that were
+			// missed before it.
+			periods := missedArchivePeriods(cadence, account.CadenceWeekday, lastEndTime, today)
+			if len(periods) > 0 && (lastEndTime.IsZero() || !continuesArchives(lastEndTime, periods[0].StartDay)) {
+				// New conversations start with the archive that's due
+				// today, and ones that are too far behind skip the days
+				// past CatchUpMaxDays. Recorded (before the archives are
+				// enqueued) so that the archives that are sent continue
+				// from the last archived time, and so that if the first
+				// one fails later runs catch up on it.
+				skippedEndTimes[conversation.Id()] = periods[0].StartDay.Add(-time.Second)
+			}
+			duePeriods[conversation.Id()] = periods
 		}
-		if len(conversations.AllConversations) > 0 {
-			for _, conversation := range conversations.AllConversations {
-				conversationType, ref := conversation.ToRef()
+		if len(skippedEndTimes) > 0 {
+			if err := recordConversationsArchived(c, slackUserId, skippedEndTimes); err != nil {
+				logErrorf(c, "  Error recording skipped archives: %s", err.Error())
+				return err
+			}
+		}
+		enqueuedCount := 0
+		deferredCount := 0
+		for _, conversation := range included {
+			periods := duePeriods[conversation.Id()]
+			if remaining := CatchUpMaxArchivesPerRun - enqueuedCount; len(periods) > remaining {
+				deferredCount += len(periods) - remaining
+				periods = periods[:remaining]
+			}
+			cadence := account.CadenceForConversation(conversation.Id())
+			conversationType, ref := conversation.ToRef()
+			for _, period := range periods {
 				sendConversationArchiveFunc.Call(
-					c, account.SlackUserId, conversationType, ref)
+					c, account.SlackUserId, conversationType, ref,
+					cadence, period.StartDay.Format(ArchiveDayFormat))
+				enqueuedCount++
 			}
-			log.Infof(c, "  Enqueued %d conversation archives.", len(conversations.AllConversations))
-		} else {
-			log.Infof(c, "  Not sent, no conversations found.")
 		}
-		return nil
-	})
+		logInfof(c, "  Enqueued %d conversation archives.", enqueuedCount)
+		if deferredCount > 0 {
+			logInfof(c, "  Leaving %d missed archives for later runs.", deferredCount)
+		}
+	} else {
+		logInfof(c, "  Not sent, no conversations found.")
+	}
+	if err := recordScheduledArchiveRun(c, slackUserId, today.Add(-time.Second)); err != nil {
+		logErrorf(c, "  Error recording run: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// The archive covers the cadence's period (e.g. a week) starting on startDate
+// (in ArchiveDayFormat). The task name changed along with its arguments (see
+// TaskFunc), legacySendConversationArchiveFunc handles the old ones.
+var sendConversationArchiveFunc = newTaskFunc(
+	"sendConversationPeriodArchive", sendConversationArchiveTask)
 
-var sendConversationArchiveFunc = delay.Func(
+// Tasks that were queued before archives had cadences, with the original
+// arguments. They always covered the previous day.
+var legacySendConversationArchiveFunc = newTaskFunc(
 	"sendConversationArchive",
 	func(c context.Context, slackUserId string, conversationType string, ref string) error {
-		log.Infof(c, "Sending archive for %s conversation %s %s...",
-			slackUserId, conversationType, ref)
 		account, err := getAccount(c, slackUserId)
 		if err != nil {
-			log.Errorf(c, "  Error looking up account: %s", err.Error())
-			return err
-		}
-		slackClient := account.NewSlackClient(c)
-		conversation, err := getConversationFromRef(conversationType, ref, slackClient)
-		if err != nil {
-			log.Errorf(c, "  Error looking up conversation: %s", err.Error())
-			if !appengine.IsDevAppServer() {
-				sendArchiveErrorMail(err, c, slackUserId)
-			}
-			return err
-		}
-		sent, err := sendConversationArchive(conversation, account, c)
-		if err != nil {
-			log.Errorf(c, "  Error sending conversation archive: %s", err.Error())
-			if !appengine.IsDevAppServer() {
-				sendArchiveErrorMail(err, c, slackUserId)
-			}
+			logErrorf(c, "Error looking up account %s: %s", slackUserId, err.Error())
 			return err
 		}
-		if sent {
-			log.Infof(c, "  Sent!")
-		} else {
-			log.Infof(c, "  Not sent, archive was empty.")
+		return sendConversationArchiveTask(c, slackUserId, conversationType, ref,
+			CadenceDaily, previousArchiveDay(account).Format(ArchiveDayFormat))
+	})
+
+func sendConversationArchiveTask(c context.Context, slackUserId string, conversationType string, ref string, cadence string, startDate string) error {
+	logInfof(c, "Sending %s archive for %s conversation %s %s from %s...",
+		cadence, slackUserId, conversationType, ref, startDate)
+	account, err := getAccount(c, slackUserId)
+	if err != nil {
+		logErrorf(c, "  Error looking up account: %s", err.Error())
+		return err
+	}
+	startDay, err := parseArchiveDay(startDate, account)
+	if err != nil || !isValidCadence(cadence) {
+		logErrorf(c, "  Malformed cadence or start date")
+		// Retrying won't help.
+		return nil
+	}
+	slackClient := account.NewSlackClient(c)
+	conversation, err := getConversationFromRef(conversationType, ref, slackClient)
+	if err != nil {
+		logErrorf(c, "  Error looking up conversation: %s", err.Error())
+		if !isDevServer() {
+			sendArchiveErrorMail(err, c, slackUserId)
 		}
+		return err
+	}
+	// Scheduled periods may have been cut short to line up with the cadence
+	// again (see missedArchivePeriods).
+	period := ArchivePeriod{startDay, cadenceEndDay(cadence, account.CadenceWeekday, startDay)}
+	sent, err := sendConversationArchive(conversation, account, cadence, period, false, c)
+	if err == ErrArchiveAlreadySent {
+		logInfof(c, "  Not sent, archive was already sent.")
 		return nil
-	})
+	}
+	if err != nil {
+		logErrorf(c, "  Error sending conversation archive: %s", err.Error())
+		if !isDevServer() {
+			sendArchiveErrorMail(err, c, slackUserId)
+		}
+		return err
+	}
+	if sent {
+		logInfof(c, "  Sent!")
+	} else {
+		logInfof(c, "  Not sent, archive was empty.")
+	}
+	return nil
+}
 
-func sendArchive(account *Account, c context.Context) (int, error) {
+// sendArchive returns the number of archives that were sent, and the number
+// that were skipped because they were already sent.
+func sendArchive(account *Account, force bool, c context.Context) (int, int, error) {
 	slackClient := account.NewSlackClient(c)
 	conversations, err := getConversations(slackClient, account)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	sentCount := 0
-	for _, conversation := range conversations.AllConversations {
-		sent, err := sendConversationArchive(conversation, account, c)
+	alreadySentCount := 0
+	today := currentArchiveDay(account)
+	for _, conversation := range includedConversations(conversations.AllConversations, account) {
+		cadence := account.CadenceForConversation(conversation.Id())
+		startDay, endDay := cadenceRange(cadence, today)
+		sent, err := sendConversationArchive(
+			conversation, account, cadence, ArchivePeriod{startDay, endDay}, force, c)
+		if err == ErrArchiveAlreadySent {
+			alreadySentCount++
+			continue
+		}
 		if err != nil {
-			return sentCount, err
+			return sentCount, alreadySentCount, err
 		}
 		if sent {
 			sentCount++
 		}
 	}
-	return sentCount, nil
+	return sentCount, alreadySentCount, nil
 }
 
 func sendArchiveErrorMail(e error, c context.Context, slackUserId string) {
-	if appengine.IsTimeoutError(e) ||
+	if isTimeoutError(e) ||
 		strings.Contains(e.Error(), "Canceled") ||
 		strings.Contains(e.Error(), "context canceled") ||
 		strings.Contains(e.Error(), "invalid security ticket") ||
@@ -418,15 +739,15 @@ func sendArchiveErrorMail(e error, c context.Context, slackUserId string) {
 		// these errors are transient), we don't want to know about them.
 		return
 	}
-	errorMessage := &mail.Message{
-		Sender:  "Slack Archive Admin <admin@slack-archive.appspotmail.com>",
-		To:      []string{"mihai.parparita@gmail.com"},
+	errorMessage := &MailMessage{
+		Sender:  mailConfig.AdminSender,
+		To:      []string{mailConfig.AdminEmailAddress},
 		Subject: fmt.Sprintf("Slack Archive Send Error for %s", slackUserId),
 		Body:    fmt.Sprintf("Error: %s", e),
 	}
-	err := mail.Send(c, errorMessage)
+	err := mailer.Send(c, errorMessage)
 	if err != nil {
-		log.Errorf(c, "Error %s sending error email.", err.Error())
+		logErrorf(c, "Error %s sending error email.", err.Error())
 	}
 }
 
@@ -437,56 +758,151 @@ func sendConversationArchiveHandler(w http.ResponseWriter, r *http.Request, stat
 	if err != nil {
 		return SlackFetchError(err, "conversation")
 	}
-	c := appengine.NewContext(r)
-	sent, err := sendConversationArchive(conversation, state.Account, c)
+	c := newContext(r)
+	cadence := state.Account.CadenceForConversation(conversation.Id())
+	startDay, endDay := cadenceRange(cadence, currentArchiveDay(state.Account))
+	force := r.FormValue("force") == "true"
+	sent, err := sendConversationArchive(
+		conversation, state.Account, cadence, ArchivePeriod{startDay, endDay}, force, c)
+	if err == ErrArchiveAlreadySent {
+		state.AddFlash("This archive was already sent, check \"Resend\" to send it again.")
+		return RedirectToRoute("conversation-archive", "type", conversationType, "ref", ref)
+	}
 	if err != nil {
 		return InternalError(err, "Could not send conversation archive")
 	}
 	if sent {
-		state.AddFlash("Emailed archive!")
+		state.AddFlash(fmt.Sprintf("%s archive!", state.Account.ArchiveDeliveryVerb()))
 	} else {
 		state.AddFlash("No archive was sent, it was empty or disabled.")
 	}
 	return RedirectToRoute("conversation-archive", "type", conversationType, "ref", ref)
 }
 
-func sendConversationArchive(conversation Conversation, account *Account, c context.Context) (bool, error) {
+// sendConversationArchive sends the archive for period. If the archive log
+// says that it was already sent, ErrArchiveAlreadySent is returned instead,
+// unless force is set.
+func sendConversationArchive(conversation Conversation, account *Account, cadence string, period ArchivePeriod, force bool, c context.Context) (bool, error) {
 	slackClient := account.NewSlackClient(c)
 	emailAddress, err := account.GetDigestEmailAddress(slackClient)
 	if err != nil {
 		return false, err
 	}
+	date := period.StartDay.Format(ArchiveDayFormat)
+	if err := checkArchiveLog(c, account.SlackUserId, conversation.Id(), period, force); err != nil {
+		if err == ErrArchiveAlreadySent {
+			// In case recording it failed when it was sent.
+			recordErr := recordConversationArchived(c, account.SlackUserId, conversation.Id(), period)
+			if recordErr != nil {
+				return false, recordErr
+			}
+		}
+		return false, err
+	}
 	if emailAddress == "disabled" {
-		return false, nil
+		// Recorded as archived, so that re-enabling emails doesn't send all
+		// of the archives since they were disabled.
+		return false, recordConversationArchived(c, account.SlackUserId, conversation.Id(), period)
 	}
-	archive, err := newConversationArchive(conversation, slackClient, account, false)
+	archive, err := newConversationArchiveForDays(
+		conversation, slackClient, account, period.StartDay, period.EndDay)
 	if err != nil {
 		return false, err
 	}
-	if archive.Empty() {
-		return false, nil
+	archive.Cadence = cadence
+	if archive.Empty() || account.ConversationFilters.ExcludesArchive(archive) {
+		return false, recordConversationArchived(c, account.SlackUserId, conversation.Id(), period)
 	}
+	// Only archives that are sent are searchable.
+	storeArchiveMessages(c, account, archive)
+	team, err := slackClient.GetTeamInfo()
+	if err != nil {
+		return false, err
+	}
+	previousMessageId, err := previousArchiveMessageId(c, account.SlackUserId, conversation.Id(), date)
+	if err != nil {
+		return false, err
+	}
+	archiveMessage, err := newConversationArchiveMailMessage(
+		archive, account, team, emailAddress, previousMessageId, c)
+	if err != nil {
+		return false, err
+	}
+	if force {
+		markArchiveResent(archiveMessage, time.Now())
+	}
+	archiveMailer := account.ArchiveMailer()
+	err = archiveMailer.Send(c, archiveMessage)
+	if err != nil {
+		return true, err
+	}
+	err = logSentArchive(c, account, conversation.Id(), period, archive.MessageCount,
+		sentMessageId(archiveMailer, archiveMessage))
+	if err != nil {
+		return true, err
+	}
+	return true, recordConversationArchived(c, account.SlackUserId, conversation.Id(), period)
+}
+
+// newConversationArchiveMailMessage renders the archive's email, which is
+// also what mbox exports are made of. It's threaded as a reply to
+// previousMessageId (see archiveThreadHeaders).
+func newConversationArchiveMailMessage(archive *ConversationArchive, account *Account, team *slack.TeamInfo, emailAddress string, previousMessageId string, c context.Context) (*MailMessage, error) {
+	attachments := archiveMailAttachments(c, account, archive)
 	var data = map[string]interface{}{
 		"ConversationArchive": archive,
 	}
 	var archiveHtml bytes.Buffer
 	if err := templates["conversation-archive-email"].Execute(&archiveHtml, data); err != nil {
-		return false, err
+		return nil, err
 	}
-	team, err := slackClient.GetTeamInfo()
+	sender := archiveSender(team.Name)
+	messageId, headers := archiveThreadHeaders(team.ID, archive, previousMessageId, sender)
+	return &MailMessage{
+		Sender:      sender,
+		To:          []string{emailAddress},
+		Subject:     fmt.Sprintf("%s Archive", archive.Conversation.Name()),
+		Body:        archive.PlainText() + "\n" + emailFooterPlainText(),
+		HTMLBody:    archiveHtml.String(),
+		Date:        archive.EndTime,
+		MessageId:   messageId,
+		Headers:     headers,
+		Attachments: attachments,
+	}, nil
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
+	query, err := newMessageSearchQuery(
+		r.FormValue("q"),
+		r.FormValue("conversation"),
+		r.FormValue("author"),
+		r.FormValue("start_date"),
+		r.FormValue("end_date"),
+		state.Account)
 	if err != nil {
-		return false, err
+		return BadRequest(err, err.Error())
+	}
+	conversations, err := getConversations(state.SlackClient, state.Account)
+	if err != nil {
+		return SlackFetchError(err, "conversations")
 	}
-	sender := fmt.Sprintf(
-		"%s Slack Archive <archive@slack-archive.appspotmail.com>", team.Name)
-	archiveMessage := &mail.Message{
-		Sender:   sender,
-		To:       []string{emailAddress},
-		Subject:  fmt.Sprintf("%s Archive", conversation.Name()),
-		HTMLBody: archiveHtml.String(),
+	var messages []*StoredMessage
+	if !query.Empty() {
+		messages, err = searchMessages(newContext(r), state.Account.SlackUserId, query)
+		if err != nil {
+			return InternalError(err, "Could not search messages")
+		}
+	}
+	var data = map[string]interface{}{
+		"Query":            query,
+		"StartDate":        r.FormValue("start_date"),
+		"EndDate":          r.FormValue("end_date"),
+		"Conversations":    conversations.AllConversations,
+		"Messages":         messages,
+		"MaxResults":       MessageSearchMaxResults,
+		"TimezoneLocation": state.Account.TimezoneLocation,
 	}
-	err = mail.Send(c, archiveMessage)
-	return true, err
+	return templates["search"].Render(w, data, state)
 }
 
 func archiveFileThumbnailHandler(w http.ResponseWriter, r *http.Request) *AppError {
@@ -497,7 +913,7 @@ func archiveFileThumbnailHandler(w http.ResponseWriter, r *http.Request) *AppErr
 		return BadRequest(err, "malformed ref")
 	}
 
-	c := appengine.NewContext(r)
+	c := newContext(r)
 
 	account, err := getAccount(c, ref.SlackUserId)
 	if err != nil {
@@ -513,18 +929,12 @@ func archiveFileThumbnailHandler(w http.ResponseWriter, r *http.Request) *AppErr
 		return SlackFetchError(err, "file")
 	}
 
-	// We're displaying using the Thumb360 dimensions, but prefer the 720 data
-	// (if available) for retina screens.
-	url := file.Thumb720
-	if url == "" {
-		url = file.Thumb360
-	}
-	log.Infof(c, "Proxying %s for %s", url, ref.SlackUserId)
+	url := thumbnailSourceUrl(file)
+	logInfof(c, "Proxying %s for %s", url, ref.SlackUserId)
 	c, cancel := context.WithTimeout(c, time.Second*60)
 	defer cancel()
-	appengineTransport := &urlfetch.Transport{Context: c}
 	cachingTransport := &CachingTransport{
-		Transport: appengineTransport,
+		Transport: newTransport(c),
 		Context:   c,
 	}
 	client := http.Client{Transport: cachingTransport}
@@ -570,30 +980,150 @@ func settingsHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInS
 	if err != nil {
 		return SlackFetchError(err, "user")
 	}
+	weekdays := make([]time.Weekday, 0, 7)
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		weekdays = append(weekdays, weekday)
+	}
 	var data = map[string]interface{}{
 		"Account":             account,
 		"User":                user,
 		"AccountEmailAddress": emailAddress,
 		"Timezones":           timezones,
+
+		"DefaultFileAttachmentMaxFileMegabytes":    DefaultFileAttachmentMaxFileMegabytes,
+		"DefaultFileAttachmentMaxMessageMegabytes": DefaultFileAttachmentMaxMessageMegabytes,
+		"FileAttachmentMaxMessageMegabytesLimit":   FileAttachmentMaxMessageMegabytesLimit,
+
+		"Weekdays":        weekdays,
+		"BackfillMaxDate": previousArchiveDay(account).Format(ArchiveDayFormat),
+		"ExportMaxDate":   currentArchiveDay(account).Format(ArchiveDayFormat),
+		"ExportMaxDays":   WebExportMaxDays,
 	}
 	return templates["settings"].Render(w, data, state)
 }
 
+// The backfill form's conversation choices, as JSON. Fetching them is slow for
+// large workspaces, so the settings page only loads them when the picker is
+// used.
+func backfillConversationsHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
+	conversations, err := getConversations(state.SlackClient, state.Account)
+	if err != nil {
+		return SlackFetchError(err, "conversations")
+	}
+	backfillConversations := make([]map[string]string, 0, len(conversations.AllConversations))
+	for _, conversation := range conversations.AllConversations {
+		conversationType, ref := conversation.ToRef()
+		backfillConversations = append(backfillConversations, map[string]string{
+			"Value": fmt.Sprintf("%s/%s", conversationType, ref),
+			"Name":  conversation.Name(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(backfillConversations); err != nil {
+		return InternalError(err, "Could not write conversations")
+	}
+	return nil
+}
+
 func saveSettingsHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
-	c := appengine.NewContext(r)
-	account := state.Account
+	c := newContext(r)
 
 	timezoneName := r.FormValue("timezone_name")
 	_, err := time.LoadLocation(timezoneName)
 	if err != nil {
 		return BadRequest(err, "Malformed timezone_name value")
 	}
-	account.TimezoneName = timezoneName
 
-	account.DigestEmailAddress = r.FormValue("email_address")
-	account.DirectMessagesOnly = r.FormValue("direct_messages_only") == "true"
+	cadence := r.FormValue("cadence")
+	if cadence == "" {
+		cadence = CadenceDaily
+	}
+	if !isValidCadence(cadence) {
+		err := fmt.Errorf("Unknown cadence: %s", cadence)
+		return BadRequest(err, err.Error())
+	}
+	cadenceWeekday := r.FormValue("cadence_weekday")
+	weekday := 0
+	if cadenceWeekday != "" {
+		weekday, err = strconv.Atoi(cadenceWeekday)
+		if err != nil || weekday < int(time.Sunday) || weekday > int(time.Saturday) {
+			return BadRequest(err, "Malformed cadence_weekday value")
+		}
+	}
+
+	fileAttachments := FileAttachmentConfig{
+		Enabled: r.FormValue("attach_files") == "true",
+	}
+	if maxFileSize := r.FormValue("attach_files_max_file_size"); maxFileSize != "" {
+		fileAttachments.MaxFileMegabytes, err = strconv.Atoi(maxFileSize)
+		if err != nil {
+			return BadRequest(err, "Malformed attach_files_max_file_size value")
+		}
+	}
+	if maxMessageSize := r.FormValue("attach_files_max_message_size"); maxMessageSize != "" {
+		fileAttachments.MaxMessageMegabytes, err = strconv.Atoi(maxMessageSize)
+		if err != nil {
+			return BadRequest(err, "Malformed attach_files_max_message_size value")
+		}
+	}
+	if err = fileAttachments.Validate(); err != nil {
+		return BadRequest(err, err.Error())
+	}
+
+	deliveryMode := r.FormValue("delivery_mode")
+	var imap ImapConfig
+	if deliveryMode == DeliveryModeImap {
+		imap = ImapConfig{
+			Host:     strings.TrimSpace(r.FormValue("imap_host")),
+			Security: r.FormValue("imap_security"),
+			Username: strings.TrimSpace(r.FormValue("imap_username")),
+			Folder:   strings.TrimSpace(r.FormValue("imap_folder")),
+			MarkSeen: r.FormValue("imap_mark_seen") == "true",
+		}
+		if imapPort := r.FormValue("imap_port"); imapPort != "" {
+			imap.Port, err = strconv.Atoi(imapPort)
+			if err != nil {
+				return BadRequest(err, "Malformed imap_port value")
+			}
+		}
+		if err = imap.Validate(); err != nil {
+			return BadRequest(err, err.Error())
+		}
+		if password := r.FormValue("imap_password"); password != "" {
+			if err = imap.SetPassword(password); err != nil {
+				return InternalError(err, "Could not encrypt IMAP password")
+			}
+		}
+	} else {
+		deliveryMode = DeliveryModeEmail
+	}
 
-	err = account.Put(c)
+	// Only the settings in the form are changed, archive tasks may be
+	// updating the account's archive state concurrently.
+	err = accountStore.Update(c, state.Account.SlackUserId, func(account *Account) error {
+		account.TimezoneName = timezoneName
+		account.DigestEmailAddress = r.FormValue("email_address")
+		account.DirectMessagesOnly = r.FormValue("direct_messages_only") == "true"
+		account.CombinedDigest = r.FormValue("combined_digest") == "true"
+		account.ConversationFilters.ExcludedPrefixes = parseChannelPrefixes(r.FormValue("excluded_prefixes"))
+		account.ConversationFilters.ExcludeBotOnly = r.FormValue("exclude_bot_only") == "true"
+		account.EmbedImages = r.FormValue("embed_images") == "true"
+		account.Cadence = cadence
+		if cadenceWeekday != "" {
+			account.CadenceWeekday = time.Weekday(weekday)
+		}
+		account.FileAttachments = fileAttachments
+		account.DeliveryMode = deliveryMode
+		if deliveryMode == DeliveryModeImap {
+			// The password is not shown in the form, an empty value means
+			// that the existing one should be kept.
+			if imap.EncryptedPassword == "" {
+				imap.EncryptedPassword = account.Imap.EncryptedPassword
+			}
+			account.Imap = imap
+		}
+		return nil
+	})
 	if err != nil {
 		return InternalError(err, "Could not save user")
 	}
@@ -602,8 +1132,152 @@ func saveSettingsHandler(w http.ResponseWriter, r *http.Request, state *AppSigne
 	return RedirectToRoute("settings")
 }
 
+func startBackfillHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
+	c := newContext(r)
+	if state.Account.Backfill.InProgress() {
+		err := errors.New("A backfill is already in progress")
+		return BadRequest(err, err.Error())
+	}
+	backfill, err := newBackfillState(
+		state.Account,
+		state.SlackClient,
+		r.FormValue("start_date"),
+		r.FormValue("end_date"),
+		r.FormValue("conversations"))
+	if err != nil {
+		return BadRequest(err, err.Error())
+	}
+	err = startBackfill(c, state.Account, backfill)
+	if err != nil {
+		return InternalError(err, "Could not start backfill")
+	}
+	state.AddFlash(fmt.Sprintf("Backfilling %d days of archives.", backfill.TotalDays()))
+	return RedirectToRoute("settings")
+}
+
+func cancelBackfillHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
+	c := newContext(r)
+	err := accountStore.Update(c, state.Account.SlackUserId, func(account *Account) error {
+		account.Backfill.NextDate = ""
+		return nil
+	})
+	if err != nil {
+		return InternalError(err, "Could not save user")
+	}
+	state.AddFlash("Backfill canceled.")
+	return RedirectToRoute("settings")
+}
+
+// Starts a backfill on behalf of a user. Unlike the settings page, this
+// replaces any backfill that is in progress.
+func adminBackfillHandler(w http.ResponseWriter, r *http.Request) *AppError {
+	c := newContext(r)
+	account, err := getAccount(c, r.FormValue("slack_user_id"))
+	if err != nil {
+		return BadRequest(err, "No such account")
+	}
+	conversations := r.FormValue("conversations")
+	if conversations == "" {
+		conversations = BackfillConversationsAll
+	}
+	backfill, err := newBackfillState(
+		account,
+		account.NewSlackClient(c),
+		r.FormValue("start_date"),
+		r.FormValue("end_date"),
+		conversations)
+	if err != nil {
+		return BadRequest(err, err.Error())
+	}
+	err = startBackfill(c, account, backfill)
+	if err != nil {
+		return InternalError(err, "Could not start backfill")
+	}
+	fmt.Fprintf(w, "Backfilling %d days of %s for %s", backfill.TotalDays(), backfill.ConversationsLabel, account.SlackUserId)
+	return nil
+}
+
+// Like exportConversationMboxHandler, the zip is buffered so that errors can
+// still be reported.
+func exportSiteHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
+	startDay, endDay, err := parseWebExportDays(r.FormValue("start_date"), r.FormValue("end_date"), state.Account)
+	if err != nil {
+		return BadRequest(err, err.Error())
+	}
+	var site bytes.Buffer
+	siteZip := zip.NewWriter(&site)
+	siteWriter := newLimitedExportWriter(&ZipExportWriter{siteZip}, WebExportMaxBytes)
+	_, err = exportSite(siteWriter, state.Account, startDay, endDay, newContext(r))
+	if err == ErrExportTooLarge {
+		return BadRequest(err, err.Error())
+	}
+	if err != nil {
+		return SlackFetchError(err, "archives")
+	}
+	if err := siteZip.Close(); err != nil {
+		return InternalError(err, "Could not write export")
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"slack-archive-%s-%s.zip\"",
+		startDay.Format(ArchiveDayFormat), endDay.Format(ArchiveDayFormat)))
+	w.Write(site.Bytes())
+	return nil
+}
+
+func exportSlackHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
+	startDay, endDay, err := parseWebExportDays(r.FormValue("start_date"), r.FormValue("end_date"), state.Account)
+	if err != nil {
+		return BadRequest(err, err.Error())
+	}
+	var export bytes.Buffer
+	exportZip := zip.NewWriter(&export)
+	exportWriter := newLimitedExportWriter(&ZipExportWriter{exportZip}, WebExportMaxBytes)
+	_, err = exportSlack(exportWriter, state.Account, startDay, endDay, newContext(r))
+	if err == ErrExportTooLarge {
+		return BadRequest(err, exportTooLargeMessage("export-slack"))
+	}
+	if err != nil {
+		return SlackFetchError(err, "messages")
+	}
+	if err := exportZip.Close(); err != nil {
+		return InternalError(err, "Could not write export")
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"slack-export-%s-%s.zip\"",
+		startDay.Format(ArchiveDayFormat), endDay.Format(ArchiveDayFormat)))
+	w.Write(export.Bytes())
+	return nil
+}
+
+func exportMarkdownHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
+	startDay, endDay, err := parseWebExportDays(r.FormValue("start_date"), r.FormValue("end_date"), state.Account)
+	if err != nil {
+		return BadRequest(err, err.Error())
+	}
+	var export bytes.Buffer
+	exportZip := zip.NewWriter(&export)
+	exportWriter := newLimitedExportWriter(&ZipExportWriter{exportZip}, WebExportMaxBytes)
+	perDay := r.FormValue("per_day") == "true"
+	_, err = exportMarkdown(
+		exportWriter, state.Account, startDay, endDay, perDay, MarkdownWebExportFilesMaxBytes, newContext(r))
+	if err == ErrExportTooLarge {
+		return BadRequest(err, err.Error())
+	}
+	if err != nil {
+		return SlackFetchError(err, "archives")
+	}
+	if err := exportZip.Close(); err != nil {
+		return InternalError(err, "Could not write export")
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"slack-archive-markdown-%s-%s.zip\"",
+		startDay.Format(ArchiveDayFormat), endDay.Format(ArchiveDayFormat)))
+	w.Write(export.Bytes())
+	return nil
+}
+
 func deleteAccountHandler(w http.ResponseWriter, r *http.Request, state *AppSignedInState) *AppError {
-	c := appengine.NewContext(r)
+	c := newContext(r)
 	state.Account.Delete(c)
 	state.ClearSession()
 	return RedirectToRoute("index")
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/slack-go/slack"
+)
+
+// Sets up the globals that handlers depend on, with an in-memory account store
+// containing a single account.
+func initTestApp(t *testing.T) *Account {
+	accountStore = newMemoryAccountStore()
+	archiveLog = newMemoryArchiveLogStore()
+	messageStore = newMemoryMessageStore()
+	cache = newMemoryCache()
+	mailConfig = defaultMailConfig
+	sessionConfig = SessionConfig{CookieName: "session", UserIdKey: "user_id"}
+	sessionStore = sessions.NewCookieStore([]byte("test-authentication-key"))
+	router = initRouter()
+
+	account := &Account{
+		SlackUserId:  "U1",
+		ApiToken:     "xoxp-test",
+		TimezoneName: "America/Los_Angeles",
+	}
+	if err := initAccount(account); err != nil {
+		t.Fatal(err)
+	}
+	if err := account.Put(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+// Authors of test messages. They're shared between tests, so they shouldn't be
+// modified (copy them instead).
+var (
+	testAlice = &slack.User{ID: "U1", TeamID: "T1", Name: "alice"}
+	testBob   = &slack.User{ID: "U2", TeamID: "T1", Name: "bob"}
+)
+
+// newTestMessage wraps msg for rendering with the account's settings, with a
+// user lookup that knows about testAlice and testBob.
+func newTestMessage(account *Account, msg slack.Msg) *Message {
+	userLookup := &UserLookup{usersById: map[string]*slack.User{
+		testAlice.ID: testAlice,
+		testBob.ID:   testBob,
+	}}
+	return &Message{Message: &slack.Message{Msg: msg}, userLookup: userLookup, account: account}
+}
+
+func newTestSignedInRequest(t *testing.T, method string, path string, form url.Values, account *Account) (*http.Request, *httptest.ResponseRecorder, *AppSignedInState) {
+	r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	session, err := sessionStore.Get(r, sessionConfig.CookieName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Values[sessionConfig.UserIdKey] = account.SlackUserId
+	state := &AppSignedInState{
+		Account:        account,
+		session:        session,
+		request:        r,
+		responseWriter: w,
+	}
+	return r, w, state
+}
+
+func TestSaveSettingsHandler(t *testing.T) {
+	account := initTestApp(t)
+	form := url.Values{
+		"timezone_name":        {"Europe/London"},
+		"email_address":        {"disabled"},
+		"direct_messages_only": {"true"},
+		"combined_digest":      {"true"},
+		"excluded_prefixes":    {"#alerts-, bot-"},
+		"exclude_bot_only":     {"true"},
+		"embed_images":         {"true"},
+		"cadence":              {"weekly"},
+		"cadence_weekday":      {"5"},
+	}
+	r, w, state := newTestSignedInRequest(t, "POST", "/account/settings", form, account)
+	// Recorded by an archive task after the request's account was loaded.
+	lastArchivedEndTime := time.Date(2026, time.March, 4, 0, 0, 0, 0, time.UTC)
+	if err := recordScheduledArchiveRun(context.Background(), account.SlackUserId, lastArchivedEndTime); err != nil {
+		t.Fatal(err)
+	}
+
+	e := saveSettingsHandler(w, r, state)
+	if e == nil || e.Type != AppErrorTypeRedirect || e.Message != "/account/settings" {
+		t.Fatalf("Expected redirect to settings, got %+v", e)
+	}
+
+	stored, err := getAccount(context.Background(), account.SlackUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.TimezoneName != "Europe/London" || stored.TimezoneLocation.String() != "Europe/London" {
+		t.Errorf("Timezone not saved: %s", stored.TimezoneName)
+	}
+	if stored.DigestEmailAddress != "disabled" {
+		t.Errorf("Email address not saved: %s", stored.DigestEmailAddress)
+	}
+	if !stored.DirectMessagesOnly {
+		t.Errorf("Direct messages only setting not saved")
+	}
+	if !stored.CombinedDigest {
+		t.Errorf("Combined digest setting not saved")
+	}
+	if !reflect.DeepEqual(stored.ConversationFilters.ExcludedPrefixes, []string{"alerts-", "bot-"}) || !stored.ConversationFilters.ExcludeBotOnly {
+		t.Errorf("Conversation filters not saved: %+v", stored.ConversationFilters)
+	}
+	if !stored.EmbedImages {
+		t.Errorf("Embed images setting not saved")
+	}
+	if stored.Cadence != CadenceWeekly || stored.CadenceWeekday != time.Friday {
+		t.Errorf("Cadence not saved: %s %s", stored.Cadence, stored.CadenceWeekday)
+	}
+	if !stored.LastArchivedEndTime.Equal(lastArchivedEndTime) {
+		t.Errorf("Concurrent archive state was overwritten: %s", stored.LastArchivedEndTime)
+	}
+	if w.Result().Header.Get("Set-Cookie") == "" {
+		t.Errorf("Expected flash to be saved in the session cookie")
+	}
+}
+
+func TestSaveSettingsHandlerBadTimezone(t *testing.T) {
+	account := initTestApp(t)
+	form := url.Values{
+		"timezone_name": {"Mars/Olympus_Mons"},
+		"email_address": {"disabled"},
+	}
+	r, w, state := newTestSignedInRequest(t, "POST", "/account/settings", form, account)
+
+	e := saveSettingsHandler(w, r, state)
+	if e == nil || e.Type != AppErrorTypeBadInput {
+		t.Fatalf("Expected bad input error, got %+v", e)
+	}
+	stored, err := getAccount(context.Background(), account.SlackUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.DigestEmailAddress != "" {
+		t.Errorf("Account should not have been modified, got email %s", stored.DigestEmailAddress)
+	}
+}
+
+func TestSaveSettingsHandlerFileAttachments(t *testing.T) {
+	account := initTestApp(t)
+	form := url.Values{
+		"timezone_name":                 {"Europe/London"},
+		"email_address":                 {"disabled"},
+		"attach_files":                  {"true"},
+		"attach_files_max_file_size":    {"2"},
+		"attach_files_max_message_size": {""},
+	}
+	r, w, state := newTestSignedInRequest(t, "POST", "/account/settings", form, account)
+	e := saveSettingsHandler(w, r, state)
+	if e == nil || e.Type != AppErrorTypeRedirect {
+		t.Fatalf("Expected redirect, got %+v", e)
+	}
+	stored, err := getAccount(context.Background(), account.SlackUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := stored.FileAttachments
+	if !config.Enabled || config.MaxFileBytes() != 2*1024*1024 ||
+		config.MaxMessageBytes() != DefaultFileAttachmentMaxMessageMegabytes*1024*1024 {
+		t.Errorf("Unexpected file attachment settings: %+v", config)
+	}
+
+	form.Set("attach_files_max_message_size", "100")
+	r, w, state = newTestSignedInRequest(t, "POST", "/account/settings", form, account)
+	e = saveSettingsHandler(w, r, state)
+	if e == nil || e.Type == AppErrorTypeRedirect {
+		t.Errorf("Expected error for a message size that is too large, got %+v", e)
+	}
+}
+
+func TestSaveSettingsHandlerImap(t *testing.T) {
+	account := initTestApp(t)
+	fileUrlRefEncryptionKey = []byte("0123456789abcdef")
+	account.Imap.EncryptedPassword = "existing-encrypted-password"
+	if err := account.Put(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{
+		"timezone_name":  {"America/Los_Angeles"},
+		"email_address":  {"user@example.com"},
+		"delivery_mode":  {"imap"},
+		"imap_host":      {"imap.example.com"},
+		"imap_port":      {"993"},
+		"imap_security":  {"tls"},
+		"imap_username":  {"user@example.com"},
+		"imap_password":  {""},
+		"imap_folder":    {"Slack"},
+		"imap_mark_seen": {"true"},
+	}
+	r, w, state := newTestSignedInRequest(t, "POST", "/account/settings", form, account)
+
+	e := saveSettingsHandler(w, r, state)
+	if e == nil || e.Type != AppErrorTypeRedirect {
+		t.Fatalf("Expected redirect, got %+v", e)
+	}
+	stored, err := getAccount(context.Background(), account.SlackUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ImapConfig{
+		Host:              "imap.example.com",
+		Port:              993,
+		Security:          "tls",
+		Username:          "user@example.com",
+		EncryptedPassword: "existing-encrypted-password",
+		Folder:            "Slack",
+		MarkSeen:          true,
+	}
+	if stored.DeliveryMode != DeliveryModeImap || stored.Imap != expected {
+		t.Errorf("Got delivery mode %s with %+v", stored.DeliveryMode, stored.Imap)
+	}
+
+	// New passwords are stored encrypted.
+	form.Set("imap_password", "new-password")
+	r, w, state = newTestSignedInRequest(t, "POST", "/account/settings", form, stored)
+	if e := saveSettingsHandler(w, r, state); e == nil || e.Type != AppErrorTypeRedirect {
+		t.Fatalf("Expected redirect, got %+v", e)
+	}
+	stored, err = getAccount(context.Background(), account.SlackUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password, err := stored.Imap.password(); err != nil || password != "new-password" ||
+		strings.Contains(stored.Imap.EncryptedPassword, "new-password") {
+		t.Errorf("Unexpected stored password %q (decrypted to %q, %v)", stored.Imap.EncryptedPassword, password, err)
+	}
+
+	form.Set("imap_host", "192.168.1.1")
+	r, w, state = newTestSignedInRequest(t, "POST", "/account/settings", form, account)
+	if e := saveSettingsHandler(w, r, state); e == nil || e.Type != AppErrorTypeBadInput {
+		t.Errorf("Expected private address to be rejected, got %+v", e)
+	}
+
+	form.Set("imap_host", "imap.example.com")
+	form.Set("imap_folder", "")
+	r, w, state = newTestSignedInRequest(t, "POST", "/account/settings", form, account)
+	if e := saveSettingsHandler(w, r, state); e == nil || e.Type != AppErrorTypeBadInput {
+		t.Errorf("Expected missing folder to be rejected, got %+v", e)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Markdown (CommonMark) rendering of archives, for exports that are kept in
+// git-backed wikis and similar, so the output is meant to diff cleanly. It
+// mirrors the structure of the plain text rendering, with attachments and
+// thread replies as (nested) quotes.
+
+type markdownWriter struct {
+	builder    strings.Builder
+	quoteDepth int
+	// Links to saved copies of files (relative to the page), keyed by file
+	// ID. Other files link to Slack.
+	filePaths map[string]string
+}
+
+// Writes (possibly multi-line) text at the current quote level.
+func (w *markdownWriter) writeLines(text string) {
+	prefix := strings.Repeat("> ", w.quoteDepth)
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			w.builder.WriteString(strings.TrimSuffix(prefix, " "))
+		} else {
+			w.builder.WriteString(prefix)
+			w.builder.WriteString(line)
+		}
+		w.builder.WriteString("\n")
+	}
+}
+
+// Separates blocks (which are otherwise merged into one paragraph).
+func (w *markdownWriter) writeBlankLine() {
+	w.writeLines("")
+}
+
+func (w *markdownWriter) String() string {
+	return w.builder.String()
+}
+
+func textToMarkdown(text string, slackClient *slack.Client) string {
+	return renderMrkdwnMarkdown(parseMrkdwn(text), slackClient)
+}
+
+// Dates are meant to be read in Markdown source too, so the zero-width spaces
+// that are inserted for mail clients are removed.
+func markdownDate(date string) string {
+	return strings.ReplaceAll(date, "\u200b", "")
+}
+
+func (archive *ConversationArchive) Markdown(filePaths map[string]string) string {
+	w := &markdownWriter{filePaths: filePaths}
+	w.writeLines(fmt.Sprintf("# %s Archive", escapeMarkdown(archive.Conversation.Name(), false)))
+	w.writeBlankLine()
+	w.writeLines(fmt.Sprintf("%s from %s",
+		pluralize(archive.MessageCount, "message"), markdownDate(archive.DisplayDate())))
+	if archive.MultiDay() {
+		for _, day := range archive.Days() {
+			w.writeBlankLine()
+			w.writeLines(fmt.Sprintf("## %s", markdownDate(day.DisplayDate())))
+			for _, messageGroup := range day.MessageGroups {
+				w.writeBlankLine()
+				messageGroup.writeMarkdown(w)
+			}
+		}
+	} else {
+		for _, messageGroup := range archive.MessageGroups {
+			w.writeBlankLine()
+			messageGroup.writeMarkdown(w)
+		}
+	}
+	return w.String()
+}
+
+func (mg *MessageGroup) writeMarkdown(w *markdownWriter) {
+	header := fmt.Sprintf("**%s**, %s", escapeMarkdown(mg.Author.Name, false), markdownDate(mg.DisplayTimestamp()))
+	if mg.FromBot() {
+		header += " (bot)"
+	}
+	w.writeLines(header)
+	for _, message := range mg.Messages {
+		w.writeBlankLine()
+		message.writeMarkdown(w)
+	}
+}
+
+func (m *Message) writeMarkdown(w *markdownWriter) {
+	// Blocks are separated by blank lines, but the first one follows the
+	// blank line that the caller wrote.
+	blockCount := 0
+	startBlock := func() {
+		if blockCount > 0 {
+			w.writeBlankLine()
+		}
+		blockCount++
+	}
+	if m.Text != "" {
+		startBlock()
+		w.writeLines(textToMarkdown(m.Text, m.slackClient))
+	}
+	for _, attachment := range m.MessageAttachments() {
+		startBlock()
+		attachment.writeMarkdown(w)
+	}
+	if files := m.MessageFiles(); len(files) > 0 {
+		startBlock()
+		for _, file := range files {
+			link := w.filePaths[file.ID]
+			if link == "" {
+				link = file.Permalink
+			}
+			fileLine := fmt.Sprintf("- [%s](%s)", escapeMarkdown(file.DisplayTitle(), false), markdownLinkDestination(link))
+			if details := file.Details(); details != "" {
+				fileLine += fmt.Sprintf(" (%s)", details)
+			}
+			w.writeLines(fileLine)
+		}
+	}
+	if reactions := m.MessageReactions(); len(reactions) > 0 {
+		startBlock()
+		reactionTexts := make([]string, 0, len(reactions))
+		for _, reaction := range reactions {
+			reactionTexts = append(reactionTexts, fmt.Sprintf("%s %d", getEmojiText(reaction.Name), reaction.Count))
+		}
+		w.writeLines(strings.Join(reactionTexts, " · "))
+	}
+	if m.HasReplies() {
+		startBlock()
+		replyLabel := "replies"
+		if m.ReplyCount == 1 {
+			replyLabel = "reply"
+		}
+		w.writeLines(fmt.Sprintf("%d %s:", m.ReplyCount, replyLabel))
+		w.quoteDepth++
+		for _, replyGroup := range m.ReplyMessageGroups {
+			w.writeBlankLine()
+			replyGroup.writeMarkdown(w)
+		}
+		w.quoteDepth--
+	}
+}
+
+func (a *MessageAttachment) writeMarkdown(w *markdownWriter) {
+	if a.Pretext != "" {
+		w.writeLines(textToMarkdown(a.Pretext, a.slackClient))
+		w.writeBlankLine()
+	}
+	w.quoteDepth++
+	defer func() { w.quoteDepth-- }()
+	lines := make([]string, 0)
+	if a.AuthorName != "" {
+		author := a.AuthorName
+		if a.AuthorSubname != "" {
+			author += " " + a.AuthorSubname
+		}
+		lines = append(lines, fmt.Sprintf("**%s**", escapeMarkdown(author, false)))
+	}
+	if a.Title != "" {
+		title := textToMarkdown(a.Title, a.slackClient)
+		if a.TitleLink != "" {
+			title = fmt.Sprintf("[%s](%s)", title, markdownLinkDestination(a.TitleLink))
+		}
+		lines = append(lines, fmt.Sprintf("**%s**", title))
+	}
+	if a.Text != "" {
+		lines = append(lines, textToMarkdown(a.Text, a.slackClient))
+	}
+	if a.ImageURL != "" {
+		lines = append(lines, fmt.Sprintf("<%s>", a.ImageURL))
+	}
+	for _, field := range a.Fields {
+		lines = append(lines, fmt.Sprintf("**%s**: %s",
+			textToMarkdown(field.Title, a.slackClient),
+			textToMarkdown(field.Value, a.slackClient)))
+	}
+	// Each part is its own paragraph (they may be multi-line).
+	w.writeLines(strings.Join(lines, "\n\n"))
+}
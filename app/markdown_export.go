@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Markdown export of an account's conversations: a file per conversation (or,
+// with the per-day layout, a directory per conversation with a file per day),
+// and an index that links to them. Files that were shared in the messages are
+// downloaded into the export and linked to.
+
+const (
+	MarkdownExportFilesDirectory = "files"
+	// Files that are larger than this keep linking to Slack.
+	MarkdownExportFileMaxBytes = 25 * 1024 * 1024
+	// Limits on the total size of the files that are saved, past which the
+	// rest keep linking to Slack. Downloads from the web leave room for the
+	// pages within WebExportMaxBytes.
+	MarkdownExportFilesMaxBytes    = 1024 * 1024 * 1024
+	MarkdownWebExportFilesMaxBytes = 20 * 1024 * 1024
+)
+
+type markdownExporter struct {
+	writer  ExportWriter
+	account *Account
+	c       context.Context
+	perDay  bool
+	// Paths (relative to the root of the export) of files that were saved,
+	// keyed by their ID. Empty for ones that couldn't be fetched.
+	filePaths map[string]string
+	usedPaths map[string]bool
+	// What's left of the limit on the total size of saved files.
+	remainingFileBytes int
+}
+
+func newMarkdownExporter(writer ExportWriter, account *Account, perDay bool, filesMaxBytes int, c context.Context) *markdownExporter {
+	return &markdownExporter{
+		writer:             writer,
+		account:            account,
+		c:                  c,
+		perDay:             perDay,
+		filePaths:          make(map[string]string),
+		usedPaths:          make(map[string]bool),
+		remainingFileBytes: filesMaxBytes,
+	}
+}
+
+// exportMarkdown writes the account's (included) conversations from startDay
+// through endDay (inclusive). Conversations without messages in that range are
+// left out, and shared files are saved until their total size reaches
+// filesMaxBytes. Returns the number of conversations that were exported.
+func exportMarkdown(writer ExportWriter, account *Account, startDay time.Time, endDay time.Time, perDay bool, filesMaxBytes int, c context.Context) (int, error) {
+	slackClient := account.NewSlackClient(c)
+	team, err := slackClient.GetTeamInfo()
+	if err != nil {
+		return 0, err
+	}
+	conversations, err := getConversations(slackClient, account)
+	if err != nil {
+		return 0, err
+	}
+	e := newMarkdownExporter(writer, account, perDay, filesMaxBytes, c)
+	var index markdownWriter
+	index.writeLines(fmt.Sprintf("# %s Slack Archive", escapeMarkdown(team.Name, false)))
+	index.writeBlankLine()
+	index.writeLines(markdownDate(archiveDisplayDate(startDay, endDay)))
+	index.writeBlankLine()
+	conversationCount := 0
+	for _, conversation := range includedConversations(conversations.AllConversations, account) {
+		archive, err := newConversationArchiveForDays(conversation, slackClient, account, startDay, endDay)
+		if err != nil {
+			return 0, err
+		}
+		storeArchiveMessages(c, account, archive)
+		if archive.Empty() {
+			continue
+		}
+		path, err := e.writeConversation(archive)
+		if err != nil {
+			return 0, err
+		}
+		index.writeLines(fmt.Sprintf("- [%s](%s) (%s)",
+			escapeMarkdown(conversation.Name(), false), markdownLinkDestination(path),
+			pluralize(archive.MessageCount, "message")))
+		conversationCount++
+	}
+	return conversationCount, e.writer.WriteFile("index.md", []byte(index.String()))
+}
+
+// writeConversation writes the conversation's page (or pages, one per day),
+// returning the path that the index should link to.
+func (e *markdownExporter) writeConversation(archive *ConversationArchive) (string, error) {
+	if err := e.saveFiles(archive); err != nil {
+		return "", err
+	}
+	path := uniqueConversationFileName(archive.Conversation, e.usedPaths)
+	if !e.perDay {
+		return path + ".md", e.writePage(path+".md", archive)
+	}
+	var index markdownWriter
+	index.writeLines(fmt.Sprintf("# %s Archive", escapeMarkdown(archive.Conversation.Name(), false)))
+	index.writeBlankLine()
+	for _, dayArchive := range archive.dayArchives() {
+		dayPath := dayArchive.StartTime.Format(ArchiveDayFormat) + ".md"
+		if err := e.writePage(path+"/"+dayPath, dayArchive); err != nil {
+			return "", err
+		}
+		index.writeLines(fmt.Sprintf("- [%s](%s) (%s)",
+			markdownDate(dayArchive.DisplayDate()), dayPath,
+			pluralize(dayArchive.MessageCount, "message")))
+	}
+	return path + "/index.md", e.writer.WriteFile(path+"/index.md", []byte(index.String()))
+}
+
+// saveFiles downloads the files that were shared in the archive's messages
+// (once per file). Files that can't be fetched are logged and link to Slack
+// instead, only failing to write them is an error.
+func (e *markdownExporter) saveFiles(archive *ConversationArchive) error {
+	for _, message := range archive.allMessages() {
+		for i := range message.Files {
+			file := &message.Files[i]
+			if _, ok := e.filePaths[file.ID]; ok || file.URLPrivate == "" {
+				continue
+			}
+			e.filePaths[file.ID] = ""
+			maxBytes := MarkdownExportFileMaxBytes
+			if e.remainingFileBytes < maxBytes {
+				maxBytes = e.remainingFileBytes
+			}
+			if file.Size > maxBytes {
+				continue
+			}
+			data, _, err := fetchSlackFile(e.c, e.account, file.URLPrivate, maxBytes)
+			if err != nil {
+				logWarningf(e.c, "Could not save file %s: %s", file.ID, err.Error())
+				continue
+			}
+			e.remainingFileBytes -= len(data)
+			path := MarkdownExportFilesDirectory + "/" + exportedFileName(file)
+			if err := e.writer.WriteFile(path, data); err != nil {
+				return err
+			}
+			e.filePaths[file.ID] = path
+		}
+	}
+	return nil
+}
+
+func (e *markdownExporter) writePage(path string, archive *ConversationArchive) error {
+	rootPrefix := strings.Repeat("../", strings.Count(path, "/"))
+	filePaths := make(map[string]string)
+	for id, filePath := range e.filePaths {
+		if filePath != "" {
+			filePaths[id] = rootPrefix + filePath
+		}
+	}
+	return e.writer.WriteFile(path, []byte(archive.Markdown(filePaths)))
+}
@@ -0,0 +1,96 @@
+//go:build standalone
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+// Only run in the standalone build, since files are fetched (and failures
+// logged) via the platform hooks.
+func TestMarkdownExporter(t *testing.T) {
+	account := initTestApp(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/report.pdf" || r.Header.Get("Authorization") != "Bearer "+account.ApiToken {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("report data"))
+	}))
+	defer server.Close()
+
+	first := newTestMessage(account, slack.Msg{Timestamp: "1772640000", Text: "First day"})
+	first.Files = []slack.File{
+		{ID: "F1", Name: "Q1 report.pdf", URLPrivate: server.URL + "/report.pdf"},
+		{ID: "F2", Name: "missing.pdf", URLPrivate: server.URL + "/missing.pdf", Permalink: "https://slack.example.com/F2"},
+	}
+	second := newTestMessage(account, slack.Msg{Timestamp: "1772726400", Text: "Second day"})
+	archive := &ConversationArchive{
+		Conversation: newTestChannelConversation("C1", "general"),
+		MessageGroups: []*MessageGroup{
+			{Messages: []*Message{first}, Author: testAlice},
+			{Messages: []*Message{second}, Author: testAlice},
+		},
+		MessageCount: 2,
+	}
+
+	writer := make(testExportWriter)
+	e := newMarkdownExporter(writer, account, true, MarkdownExportFilesMaxBytes, context.Background())
+	path, err := e.writeConversation(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "general/index.md" {
+		t.Errorf("Unexpected path: %q", path)
+	}
+	if data := string(writer["files/F1-Q1report.pdf"]); data != "report data" {
+		t.Errorf("File not saved: %q", data)
+	}
+	firstDay := string(writer["general/2026-03-04.md"])
+	for _, expected := range []string{
+		"First day",
+		"[Q1 report.pdf](../files/F1-Q1report.pdf)",
+		// Files that can't be fetched link to Slack.
+		"[missing.pdf](https://slack.example.com/F2)",
+	} {
+		if !strings.Contains(firstDay, expected) {
+			t.Errorf("Expected %q in the first day's file: %s", expected, firstDay)
+		}
+	}
+	if secondDay := string(writer["general/2026-03-05.md"]); !strings.Contains(secondDay, "Second day") {
+		t.Errorf("Unexpected second day's file: %s", secondDay)
+	}
+	index := string(writer["general/index.md"])
+	for _, expected := range []string{"(2026-03-04.md) (1 message)", "(2026-03-05.md) (1 message)"} {
+		if !strings.Contains(index, expected) {
+			t.Errorf("Expected %q in the conversation index: %s", expected, index)
+		}
+	}
+
+	e = newMarkdownExporter(writer, account, false, MarkdownExportFilesMaxBytes, context.Background())
+	path, err = e.writeConversation(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversation := string(writer[path])
+	if path != "general.md" || !strings.Contains(conversation, "First day") ||
+		!strings.Contains(conversation, "Second day") || !strings.Contains(conversation, "](files/F1-Q1report.pdf)") {
+		t.Errorf("Unexpected conversation file %q: %s", path, conversation)
+	}
+	// Files past the total limit aren't saved.
+	writer = make(testExportWriter)
+	e = newMarkdownExporter(writer, account, false, len("report data")-1, context.Background())
+	if _, err := e.writeConversation(archive); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := writer["files/F1-Q1report.pdf"]; ok || e.filePaths["F1"] != "" {
+		t.Errorf("File should not be saved past the limit: %v", e.filePaths)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestConversationArchiveMarkdown(t *testing.T) {
+	emojiByShortName = map[string]*Emoji{"tada": {UnicodeCodePointHex: "1F389"}}
+	account := &Account{TimezoneLocation: time.UTC}
+
+	reply := newTestMessage(account, slack.Msg{Timestamp: "1772668800", Text: "congrats\n&gt;quoted"})
+	parent := newTestMessage(account, slack.Msg{
+		Timestamp:       "1772665200",
+		ThreadTimestamp: "1772665200",
+		ReplyCount:      1,
+		Text:            "We *shipped*!",
+		Attachments: []slack.Attachment{{
+			Title:     "Release notes",
+			TitleLink: "https://example.com/notes",
+			Text:      "Line 1\nLine 2",
+			Fields:    []slack.AttachmentField{{Title: "Version", Value: "1.0"}},
+		}},
+		Files: []slack.File{
+			{ID: "F1", Title: "screenshot.png", Filetype: "png", Size: 2048},
+			{ID: "F2", Title: "notes.txt", Permalink: "https://slack.example.com/files/F2"},
+		},
+		Reactions: []slack.ItemReaction{{Name: "tada", Count: 2, Users: []string{"U1", "U2"}}},
+	})
+	parent.ReplyMessageGroups = []*MessageGroup{{Messages: []*Message{reply}, Author: testBob}}
+
+	archive := &ConversationArchive{
+		Conversation: newTestChannelConversation("C1", "general"),
+		MessageGroups: []*MessageGroup{
+			{Messages: []*Message{parent}, Author: testAlice},
+		},
+		MessageCount: 2,
+		StartTime:    time.Date(2026, time.March, 4, 0, 0, 0, 0, time.UTC),
+		EndTime:      time.Date(2026, time.March, 4, 23, 59, 59, 0, time.UTC),
+	}
+	expected := "# #general Archive\n" +
+		"\n" +
+		"2 messages from March 4, 2026\n" +
+		"\n" +
+		"**alice**, 11:00pm\n" +
+		"\n" +
+		"We **shipped**!\n" +
+		"\n" +
+		"> **[Release notes](https://example.com/notes)**\n" +
+		">\n" +
+		"> Line 1\\\n" +
+		"> Line 2\n" +
+		">\n" +
+		"> **Version**: 1.0\n" +
+		"\n" +
+		"- [screenshot.png](../files/F1-screenshot.png) (PNG, 2.0 KB)\n" +
+		"- [notes.txt](https://slack.example.com/files/F2)\n" +
+		"\n" +
+		"🎉 2\n" +
+		"\n" +
+		"1 reply:\n" +
+		">\n" +
+		"> **bob**, 12:00am\n" +
+		">\n" +
+		"> congrats\n" +
+		"> > quoted\n"
+	actual := archive.Markdown(map[string]string{"F1": "../files/F1-screenshot.png"})
+	if actual != expected {
+		t.Errorf("Markdown:\n%s\nwant:\n%s", actual, expected)
+	}
+}
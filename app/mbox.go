@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Lines that need to be quoted in mboxrd files, so that they're not mistaken
+// for the "From " lines that separate messages.
+var mboxFromLinePattern = regexp.MustCompile("^>*From ")
+
+// threadArchives splits the archive into one per top-level message (along with
+// its replies).
+func (archive *ConversationArchive) threadArchives() []*ConversationArchive {
+	archives := make([]*ConversationArchive, 0)
+	for _, group := range archive.MessageGroups {
+		for _, message := range group.Messages {
+			messageCount := 1
+			for _, replyGroup := range message.ReplyMessageGroups {
+				messageCount += len(replyGroup.Messages)
+			}
+			archives = append(archives, &ConversationArchive{
+				Conversation: archive.Conversation,
+				MessageGroups: []*MessageGroup{
+					{Messages: []*Message{message}, Author: group.Author},
+				},
+				MessageCount: messageCount,
+				StartTime:    message.TimestampTime(),
+				EndTime:      archive.EndTime,
+				Cadence:      archive.Cadence,
+			})
+		}
+	}
+	return archives
+}
+
+// exportConversationMbox writes the conversation's archives from startDay
+// through endDay (inclusive) to w as an mbox, with each day's archive (or each
+// thread, if perThread is set) as a message. Days without messages are
+// skipped. Returns the number of messages that were written.
+func exportConversationMbox(w io.Writer, conversation Conversation, account *Account, startDay time.Time, endDay time.Time, perThread bool, c context.Context) (int, error) {
+	slackClient := account.NewSlackClient(c)
+	emailAddress, err := account.GetDigestEmailAddress(slackClient)
+	if err != nil {
+		return 0, err
+	}
+	if emailAddress == "disabled" {
+		// Exports are still addressed to the user, even if they don't want
+		// archives emailed to them.
+		user, err := slackClient.GetUserInfo(account.SlackUserId)
+		if err != nil {
+			return 0, err
+		}
+		emailAddress = user.Profile.Email
+	}
+	team, err := slackClient.GetTeamInfo()
+	if err != nil {
+		return 0, err
+	}
+	messageCount := 0
+	previousMessageId := ""
+	for day := startDay; !day.After(endDay); day = day.AddDate(0, 0, 1) {
+		archive, err := newConversationArchiveForDay(conversation, slackClient, account, day)
+		if err != nil {
+			return messageCount, err
+		}
+		storeArchiveMessages(c, account, archive)
+		if archive.Empty() {
+			continue
+		}
+		// Each exported message covers a single day.
+		archive.Cadence = CadenceDaily
+		archives := []*ConversationArchive{archive}
+		if perThread {
+			archives = archive.threadArchives()
+		}
+		for _, archive := range archives {
+			// Each day's message replies to the previous one in the export.
+			message, err := newConversationArchiveMailMessage(
+				archive, account, team, emailAddress, previousMessageId, c)
+			if err != nil {
+				return messageCount, err
+			}
+			if perThread {
+				threadMessage := archive.MessageGroups[0].Messages[0]
+				message.MessageId, message.Headers = threadArchiveThreadHeaders(
+					team.ID, archive, threadMessage.Timestamp, message.Sender)
+				message.Date = archive.StartTime
+			}
+			if err := writeMboxMessage(w, message); err != nil {
+				return messageCount, err
+			}
+			if !perThread {
+				previousMessageId = message.MessageId
+			}
+			messageCount++
+		}
+	}
+	return messageCount, nil
+}
+
+// writeMboxMessage appends the message in the mboxrd format: a "From "
+// separator line, followed by the message (with LF line endings and "From "
+// lines quoted) and a blank line.
+func writeMboxMessage(w io.Writer, message *MailMessage) error {
+	messageBytes, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	sender, err := parseEmailAddress(message.Sender)
+	if err != nil {
+		return err
+	}
+	date := message.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	var mbox strings.Builder
+	fmt.Fprintf(&mbox, "From %s %s\n", sender, date.UTC().Format(time.ANSIC))
+	text := strings.TrimRight(strings.ReplaceAll(string(messageBytes), "\r\n", "\n"), "\n")
+	for _, line := range strings.Split(text, "\n") {
+		if mboxFromLinePattern.MatchString(line) {
+			mbox.WriteString(">")
+		}
+		mbox.WriteString(line)
+		mbox.WriteString("\n")
+	}
+	mbox.WriteString("\n")
+	_, err = io.WriteString(w, mbox.String())
+	return err
+}
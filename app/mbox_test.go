@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestWriteMboxMessage(t *testing.T) {
+	message := &MailMessage{
+		Sender:    "Team Slack Archive <archive@example.com>",
+		To:        []string{"user@example.com"},
+		Subject:   "#general Archive",
+		Body:      "From the team:\n>From before\nhello",
+		Date:      time.Date(2026, time.March, 4, 23, 59, 59, 0, time.FixedZone("PST", -8*60*60)),
+		MessageId: "<20260304.C1.T1@example.com>",
+	}
+	var mbox strings.Builder
+	for i := 0; i < 2; i++ {
+		if err := writeMboxMessage(&mbox, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	text := mbox.String()
+	if !strings.HasPrefix(text, "From archive@example.com Thu Mar  5 07:59:59 2026\n") {
+		t.Errorf("Unexpected separator line: %q", strings.SplitN(text, "\n", 2)[0])
+	}
+	if strings.Count(text, "\nFrom archive@example.com ") != 1 {
+		t.Errorf("Expected a separator before the second message in %q", text)
+	}
+	if strings.Contains(text, "\r") {
+		t.Errorf("Expected LF line endings in %q", text)
+	}
+	for _, expected := range []string{"\n>From the team:\n", "\n>>From before\n", "Message-ID: <20260304.C1.T1@example.com>\n"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %q in %q", expected, text)
+		}
+	}
+	if !strings.HasSuffix(text, "\n\n") {
+		t.Errorf("Expected messages to be followed by a blank line")
+	}
+}
+
+func TestThreadArchives(t *testing.T) {
+	account := &Account{TimezoneLocation: time.UTC}
+	newMessage := func(timestamp string) *Message {
+		return newTestMessage(account, slack.Msg{Timestamp: timestamp})
+	}
+	first := newMessage("1772665200")
+	first.ReplyMessageGroups = []*MessageGroup{{Messages: []*Message{newMessage("1772665300"), newMessage("1772665400")}, Author: testAlice}}
+	second := newMessage("1772665260")
+	third := newMessage("1772668800")
+	endTime := time.Date(2026, time.March, 5, 23, 59, 59, 0, time.UTC)
+	archive := &ConversationArchive{
+		Conversation: newTestChannelConversation("C1", "general"),
+		MessageGroups: []*MessageGroup{
+			{Messages: []*Message{first, second}, Author: testAlice},
+			{Messages: []*Message{third}, Author: testAlice},
+		},
+		EndTime: endTime,
+		Cadence: CadenceDaily,
+	}
+
+	threads := archive.threadArchives()
+	if len(threads) != 3 {
+		t.Fatalf("Expected 3 threads, got %d", len(threads))
+	}
+	for i, expected := range []struct {
+		message      *Message
+		messageCount int
+	}{{first, 3}, {second, 1}, {third, 1}} {
+		thread := threads[i]
+		if thread.MessageGroups[0].Messages[0] != expected.message || thread.MessageGroups[0].Author != testAlice ||
+			thread.MessageCount != expected.messageCount {
+			t.Errorf("Thread %d: got %+v", i, thread)
+		}
+		if !thread.StartTime.Equal(expected.message.TimestampTime()) || !thread.EndTime.Equal(endTime) ||
+			thread.Cadence != CadenceDaily {
+			t.Errorf("Thread %d: unexpected range %s to %s", i, thread.StartTime, thread.EndTime)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Upper bound on the number of messages shown for a search, newest first.
+const MessageSearchMaxResults = 200
+
+// Normalized copy of a message that was fetched for an archive. Messages are
+// kept so that they can still be searched once Slack stops returning them
+// (e.g. past the free plan's 90-day history limit).
+type StoredMessage struct {
+	SlackUserId      string
+	ConversationId   string
+	ConversationName string `datastore:",noindex"`
+	ConversationType string `datastore:",noindex"`
+	ConversationRef  string `datastore:",noindex"`
+	Timestamp        string `datastore:",noindex"`
+	// Timestamp of the parent message, for replies.
+	ThreadTimestamp string `datastore:",noindex"`
+	Time            time.Time
+	AuthorId        string `datastore:",noindex"`
+	AuthorName      string `datastore:",noindex"`
+	// Plain text of the message and its attachments.
+	Text      string           `datastore:",noindex"`
+	Files     []StoredFile     `datastore:",noindex"`
+	Reactions []StoredReaction `datastore:",noindex"`
+	// Words from the text, author name and file names, used as the full-text
+	// index.
+	Terms []string
+}
+
+type StoredFile struct {
+	Id        string
+	Name      string
+	Title     string
+	Permalink string
+}
+
+type StoredReaction struct {
+	Name  string
+	Count int
+}
+
+func messageStoreKey(slackUserId string, conversationId string, timestamp string) string {
+	return slackUserId + "/" + conversationId + "/" + timestamp
+}
+
+func (message *StoredMessage) Key() string {
+	return messageStoreKey(message.SlackUserId, message.ConversationId, message.Timestamp)
+}
+
+func (message *StoredMessage) IsReply() bool {
+	return message.ThreadTimestamp != "" && message.ThreadTimestamp != message.Timestamp
+}
+
+func (message *StoredMessage) ArchiveUrl() string {
+	url, _ := RouteUrl("conversation-archive", "type", message.ConversationType, "ref", message.ConversationRef)
+	return url
+}
+
+func (message *StoredMessage) DisplayTimestamp(location *time.Location) string {
+	return message.Time.In(location).Format(ConversationArchiveDateFormat + " 3:04pm")
+}
+
+func (message *StoredMessage) hasTerms(terms []string) bool {
+	for _, term := range terms {
+		if !containsString(message.Terms, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// Persistence for StoredMessage entities. Put replaces messages with the same
+// key (so edits are picked up when a day is archived again).
+type MessageStore interface {
+	Put(c context.Context, messages []*StoredMessage) error
+	// Search returns the account's messages that match the query, newest
+	// first and at most limit of them.
+	Search(c context.Context, slackUserId string, query *MessageSearchQuery, limit int) ([]*StoredMessage, error)
+	// Removes all of the messages for an account, when it's deleted.
+	DeleteAll(c context.Context, slackUserId string) error
+}
+
+// searchTerms splits text into lowercase words, which are what the index is
+// built from (so only whole words match).
+func searchTerms(text string) []string {
+	terms := make([]string, 0)
+	for _, term := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !containsString(terms, term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// newStoredMessages normalizes the archive's messages (including replies).
+func newStoredMessages(archive *ConversationArchive, account *Account) []*StoredMessage {
+	conversationType, conversationRef := archive.Conversation.ToRef()
+	storedMessages := make([]*StoredMessage, 0, archive.MessageCount)
+	var addGroups func(groups []*MessageGroup)
+	addGroups = func(groups []*MessageGroup) {
+		for _, group := range groups {
+			for _, message := range group.Messages {
+				var w plainTextWriter
+				if message.Text != "" {
+					w.writeLines(textToPlainText(message.Text, message.slackClient))
+				}
+				for _, attachment := range message.MessageAttachments() {
+					attachment.writePlainText(&w)
+				}
+				storedMessage := &StoredMessage{
+					SlackUserId:      account.SlackUserId,
+					ConversationId:   archive.Conversation.Id(),
+					ConversationName: archive.Conversation.Name(),
+					ConversationType: conversationType,
+					ConversationRef:  conversationRef,
+					Timestamp:        message.Timestamp,
+					ThreadTimestamp:  message.ThreadTimestamp,
+					Time:             message.TimestampTime(),
+					AuthorId:         group.Author.ID,
+					AuthorName:       group.Author.Name,
+					Text:             strings.TrimSpace(w.String()),
+				}
+				indexedText := []string{storedMessage.Text, storedMessage.AuthorName}
+				for _, file := range message.Files {
+					storedMessage.Files = append(storedMessage.Files, StoredFile{
+						Id:        file.ID,
+						Name:      file.Name,
+						Title:     file.Title,
+						Permalink: file.Permalink,
+					})
+					indexedText = append(indexedText, file.Name, file.Title)
+				}
+				for _, reaction := range message.Reactions {
+					storedMessage.Reactions = append(storedMessage.Reactions, StoredReaction{
+						Name:  reaction.Name,
+						Count: reaction.Count,
+					})
+				}
+				storedMessage.Terms = searchTerms(strings.Join(indexedText, " "))
+				storedMessages = append(storedMessages, storedMessage)
+				addGroups(message.ReplyMessageGroups)
+			}
+		}
+	}
+	addGroups(archive.MessageGroups)
+	return storedMessages
+}
+
+// storeArchiveMessages saves the archive's messages for searching. Failures
+// are only logged, since they shouldn't prevent the archive from being sent.
+func storeArchiveMessages(c context.Context, account *Account, archives ...*ConversationArchive) {
+	messages := make([]*StoredMessage, 0)
+	for _, archive := range archives {
+		messages = append(messages, newStoredMessages(archive, account)...)
+	}
+	if len(messages) == 0 {
+		return
+	}
+	if err := messageStore.Put(c, messages); err != nil {
+		logWarningf(c, "Could not store %d messages: %s", len(messages), err.Error())
+	}
+}
+
+type MessageSearchQuery struct {
+	Text           string
+	ConversationId string
+	// Slack user name (with or without a leading @) or ID of the author.
+	Author string
+	// Messages from StartTime (inclusive) to EndTime (exclusive), either may
+	// be zero.
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// newMessageSearchQuery parses the search form. Dates are in
+// ArchiveDayFormat (in the account's timezone) and are inclusive.
+func newMessageSearchQuery(text string, conversationId string, author string, startDate string, endDate string, account *Account) (*MessageSearchQuery, error) {
+	query := &MessageSearchQuery{
+		Text:           strings.TrimSpace(text),
+		ConversationId: conversationId,
+		Author:         strings.TrimSpace(author),
+	}
+	if startDate != "" {
+		startDay, err := parseArchiveDay(startDate, account)
+		if err != nil {
+			return nil, fmt.Errorf("Malformed start date: %s", startDate)
+		}
+		query.StartTime = startDay
+	}
+	if endDate != "" {
+		endDay, err := parseArchiveDay(endDate, account)
+		if err != nil {
+			return nil, fmt.Errorf("Malformed end date: %s", endDate)
+		}
+		query.EndTime = endDay.AddDate(0, 0, 1)
+	}
+	if !query.StartTime.IsZero() && !query.EndTime.IsZero() && !query.StartTime.Before(query.EndTime) {
+		return nil, fmt.Errorf("The start date (%s) is after the end date (%s)", startDate, endDate)
+	}
+	return query, nil
+}
+
+func (query *MessageSearchQuery) Empty() bool {
+	return len(searchTerms(query.Text)) == 0 && query.ConversationId == "" &&
+		query.Author == "" && query.StartTime.IsZero() && query.EndTime.IsZero()
+}
+
+func (query *MessageSearchQuery) Matches(message *StoredMessage) bool {
+	if !message.hasTerms(searchTerms(query.Text)) {
+		return false
+	}
+	if query.ConversationId != "" && message.ConversationId != query.ConversationId {
+		return false
+	}
+	if query.Author != "" {
+		author := strings.TrimPrefix(query.Author, "@")
+		if !strings.EqualFold(author, message.AuthorName) && author != message.AuthorId {
+			return false
+		}
+	}
+	if !query.StartTime.IsZero() && message.Time.Before(query.StartTime) {
+		return false
+	}
+	if !query.EndTime.IsZero() && !message.Time.Before(query.EndTime) {
+		return false
+	}
+	return true
+}
+
+// searchMessages returns the account's stored messages that match the query,
+// newest first and at most MessageSearchMaxResults of them.
+func searchMessages(c context.Context, slackUserId string, query *MessageSearchQuery) ([]*StoredMessage, error) {
+	return messageStore.Search(c, slackUserId, query, MessageSearchMaxResults)
+}
+
+// newestMatchingMessages is used by stores that look up candidates by term
+// and then check the rest of the query in memory.
+func newestMatchingMessages(candidates []*StoredMessage, query *MessageSearchQuery, limit int) []*StoredMessage {
+	messages := make([]*StoredMessage, 0)
+	for _, message := range candidates {
+		if query.Matches(message) {
+			messages = append(messages, message)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Time.After(messages[j].Time)
+	})
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages
+}
+
+// Non-persistent MessageStore, messages are lost when the process exits.
+type MemoryMessageStore struct {
+	mu       sync.Mutex
+	messages map[string]StoredMessage
+	// Keys of messages, keyed by user ID and term.
+	index map[string]map[string]bool
+}
+
+func newMemoryMessageStore() *MemoryMessageStore {
+	return &MemoryMessageStore{
+		messages: make(map[string]StoredMessage),
+		index:    make(map[string]map[string]bool),
+	}
+}
+
+func (s *MemoryMessageStore) Put(c context.Context, messages []*StoredMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, message := range messages {
+		key := message.Key()
+		if previous, ok := s.messages[key]; ok {
+			for _, term := range previous.Terms {
+				delete(s.index[message.SlackUserId+"/"+term], key)
+			}
+		}
+		s.messages[key] = *message
+		for _, term := range message.Terms {
+			indexKey := message.SlackUserId + "/" + term
+			if s.index[indexKey] == nil {
+				s.index[indexKey] = make(map[string]bool)
+			}
+			s.index[indexKey][key] = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryMessageStore) Search(c context.Context, slackUserId string, query *MessageSearchQuery, limit int) ([]*StoredMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	candidates := make([]*StoredMessage, 0)
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		for key, message := range s.messages {
+			if strings.HasPrefix(key, slackUserId+"/") {
+				message := message
+				candidates = append(candidates, &message)
+			}
+		}
+	} else {
+		for key := range s.index[slackUserId+"/"+terms[0]] {
+			message := s.messages[key]
+			candidates = append(candidates, &message)
+		}
+	}
+	return newestMatchingMessages(candidates, query, limit), nil
+}
+
+func (s *MemoryMessageStore) DeleteAll(c context.Context, slackUserId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.messages {
+		if strings.HasPrefix(key, slackUserId+"/") {
+			delete(s.messages, key)
+		}
+	}
+	for key := range s.index {
+		if strings.HasPrefix(key, slackUserId+"/") {
+			delete(s.index, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+//go:build standalone
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	BoltMessagesBucket     = "Messages"
+	BoltMessageIndexBucket = "MessageIndex"
+)
+
+// MessageStore backed by the same bbolt database as BoltAccountStore.
+// Messages are stored as JSON keyed by messageStoreKey, and the index has an
+// (empty) entry for each term of each message, keyed by
+// <user ID>/<term>/<conversation ID>/<timestamp>.
+type BoltMessageStore struct {
+	db *bolt.DB
+}
+
+func newBoltMessageStore(db *bolt.DB) (*BoltMessageStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{BoltMessagesBucket, BoltMessageIndexBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltMessageStore{db}, nil
+}
+
+func boltMessageIndexKey(message *StoredMessage, term string) []byte {
+	return []byte(message.SlackUserId + "/" + term + "/" + message.ConversationId + "/" + message.Timestamp)
+}
+
+func (s *BoltMessageStore) Put(c context.Context, messages []*StoredMessage) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		messagesBucket := tx.Bucket([]byte(BoltMessagesBucket))
+		indexBucket := tx.Bucket([]byte(BoltMessageIndexBucket))
+		for _, message := range messages {
+			key := []byte(message.Key())
+			if previousBytes := messagesBucket.Get(key); previousBytes != nil {
+				previous := new(StoredMessage)
+				if err := json.Unmarshal(previousBytes, previous); err != nil {
+					return err
+				}
+				for _, term := range previous.Terms {
+					if err := indexBucket.Delete(boltMessageIndexKey(previous, term)); err != nil {
+						return err
+					}
+				}
+			}
+			messageBytes, err := json.Marshal(message)
+			if err != nil {
+				return err
+			}
+			if err := messagesBucket.Put(key, messageBytes); err != nil {
+				return err
+			}
+			for _, term := range message.Terms {
+				if err := indexBucket.Put(boltMessageIndexKey(message, term), []byte{}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltMessageStore) Search(c context.Context, slackUserId string, query *MessageSearchQuery, limit int) ([]*StoredMessage, error) {
+	terms := searchTerms(query.Text)
+	conversationId := query.ConversationId
+	messages := make([]*StoredMessage, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		messagesBucket := tx.Bucket([]byte(BoltMessagesBucket))
+		keep := func(messageBytes []byte) error {
+			message := new(StoredMessage)
+			if err := json.Unmarshal(messageBytes, message); err != nil {
+				return err
+			}
+			messages = append(messages, message)
+			return nil
+		}
+		if len(terms) == 0 {
+			prefix := []byte(slackUserId + "/")
+			if conversationId != "" {
+				prefix = []byte(messageStoreKey(slackUserId, conversationId, ""))
+			}
+			cursor := messagesBucket.Cursor()
+			for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+				if err := keep(v); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		// Only the first term is looked up in the index, the rest are
+		// checked against the candidate messages.
+		prefix := []byte(slackUserId + "/" + terms[0] + "/")
+		if conversationId != "" {
+			prefix = append(prefix, []byte(conversationId+"/")...)
+		}
+		cursor := tx.Bucket([]byte(BoltMessageIndexBucket)).Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			conversationAndTimestamp := string(k[len(slackUserId)+len(terms[0])+2:])
+			messageBytes := messagesBucket.Get([]byte(slackUserId + "/" + conversationAndTimestamp))
+			if messageBytes == nil {
+				continue
+			}
+			if err := keep(messageBytes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newestMatchingMessages(messages, query, limit), nil
+}
+
+func (s *BoltMessageStore) DeleteAll(c context.Context, slackUserId string) error {
+	prefix := []byte(slackUserId + "/")
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{BoltMessagesBucket, BoltMessageIndexBucket} {
+			cursor := tx.Bucket([]byte(name)).Cursor()
+			for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Seek(prefix) {
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,21 @@
+//go:build standalone
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltMessageStore(t *testing.T) {
+	db, err := openBoltDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err := newBoltMessageStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMessageStore(t, store)
+}
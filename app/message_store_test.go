@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func newTestStoredMessage(slackUserId string, conversationId string, timestamp string, text string) *StoredMessage {
+	return &StoredMessage{
+		SlackUserId:    slackUserId,
+		ConversationId: conversationId,
+		Timestamp:      timestamp,
+		Text:           text,
+		Terms:          searchTerms(text),
+	}
+}
+
+func storedMessageKeys(messages []*StoredMessage) []string {
+	keys := make([]string, 0, len(messages))
+	for _, message := range messages {
+		keys = append(keys, message.Key())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func testMessageStore(t *testing.T, store MessageStore) {
+	c := context.Background()
+	err := store.Put(c, []*StoredMessage{
+		newTestStoredMessage("U1", "C1", "100.1", "Deploy is done"),
+		newTestStoredMessage("U1", "C1", "100.2", "deploy failed again"),
+		newTestStoredMessage("U1", "C2", "100.3", "Lunch?"),
+		newTestStoredMessage("U10", "C1", "100.4", "deploy from another account"),
+	})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	for _, test := range []struct {
+		terms          []string
+		conversationId string
+		expected       []string
+	}{
+		{[]string{"deploy"}, "", []string{"U1/C1/100.1", "U1/C1/100.2"}},
+		{[]string{"deploy", "failed"}, "", []string{"U1/C1/100.2"}},
+		{[]string{"failed", "deploy"}, "", []string{"U1/C1/100.2"}},
+		{[]string{"deploy"}, "C2", []string{}},
+		{[]string{"dinner"}, "", []string{}},
+		{nil, "", []string{"U1/C1/100.1", "U1/C1/100.2", "U1/C2/100.3"}},
+		{nil, "C2", []string{"U1/C2/100.3"}},
+	} {
+		query := &MessageSearchQuery{Text: strings.Join(test.terms, " "), ConversationId: test.conversationId}
+		messages, err := store.Search(c, "U1", query, MessageSearchMaxResults)
+		if err != nil {
+			t.Fatalf("Search(%v, %q): %v", test.terms, test.conversationId, err)
+		}
+		if actual := storedMessageKeys(messages); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("Search(%v, %q): got %v, want %v", test.terms, test.conversationId, actual, test.expected)
+		}
+	}
+
+	// The newest messages are returned when there are more than the limit.
+	newest := newTestStoredMessage("U1", "C1", "200.1", "deploy again")
+	newest.Time = time.Date(2026, time.March, 4, 9, 0, 0, 0, time.UTC)
+	if err := store.Put(c, []*StoredMessage{newest}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	messages, err := store.Search(c, "U1", &MessageSearchQuery{Text: "deploy"}, 1)
+	if err != nil || len(messages) != 1 || messages[0].Timestamp != "200.1" {
+		t.Errorf("Search with limit: got %v, %v", storedMessageKeys(messages), err)
+	}
+
+	// Edited messages replace the previous version (and its terms).
+	err = store.Put(c, []*StoredMessage{newTestStoredMessage("U1", "C1", "100.2", "deploy succeeded")})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if messages, _ := store.Search(c, "U1", &MessageSearchQuery{Text: "failed"}, 10); len(messages) != 0 {
+		t.Errorf("Edited message still matches its old text: %v", storedMessageKeys(messages))
+	}
+	messages, _ = store.Search(c, "U1", &MessageSearchQuery{Text: "succeeded"}, 10)
+	if len(messages) != 1 || messages[0].Text != "deploy succeeded" {
+		t.Errorf("Edited message not found: %+v", messages)
+	}
+
+	if err := store.DeleteAll(c, "U1"); err != nil {
+		t.Fatalf("DeleteAll: %v", err)
+	}
+	if messages, _ := store.Search(c, "U1", &MessageSearchQuery{}, 10); len(messages) != 0 {
+		t.Errorf("Messages not deleted: %v", storedMessageKeys(messages))
+	}
+	// Other accounts (even ones with the same ID prefix) are not affected.
+	if messages, _ := store.Search(c, "U10", &MessageSearchQuery{Text: "deploy"}, 10); len(messages) != 1 {
+		t.Errorf("Other account's messages: got %v", storedMessageKeys(messages))
+	}
+}
+
+func TestMemoryMessageStore(t *testing.T) {
+	testMessageStore(t, newMemoryMessageStore())
+}
+
+func TestSearchTerms(t *testing.T) {
+	actual := searchTerms("Deploy #42 to prod-east, then DEPLOY again (café)")
+	expected := []string{"deploy", "42", "to", "prod", "east", "then", "again", "café"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Got %v, want %v", actual, expected)
+	}
+}
+
+func TestNewStoredMessages(t *testing.T) {
+	account := &Account{SlackUserId: "U1", TimezoneLocation: time.UTC}
+	reply := newTestMessage(account, slack.Msg{
+		Timestamp: "1772668800.000200", ThreadTimestamp: "1772665200.000100", Text: "congrats"})
+	parent := newTestMessage(account, slack.Msg{
+		Timestamp:       "1772665200.000100",
+		ThreadTimestamp: "1772665200.000100",
+		Text:            "We shipped!",
+		Attachments:     []slack.Attachment{{Title: "Release notes"}},
+		Files:           []slack.File{{ID: "F1", Name: "screenshot.png", Title: "Launch screenshot"}},
+		Reactions:       []slack.ItemReaction{{Name: "tada", Count: 2}},
+	})
+	parent.ReplyMessageGroups = []*MessageGroup{{Messages: []*Message{reply}, Author: testBob}}
+	archive := &ConversationArchive{
+		Conversation:  newTestChannelConversation("C1", "general"),
+		MessageGroups: []*MessageGroup{{Messages: []*Message{parent}, Author: testAlice}},
+		MessageCount:  1,
+	}
+
+	messages := newStoredMessages(archive, account)
+	if len(messages) != 2 {
+		t.Fatalf("Expected the message and its reply, got %+v", messages)
+	}
+	message := messages[0]
+	if message.Key() != "U1/C1/1772665200.000100" || message.ConversationName != "#general" ||
+		message.ConversationType != "channel" || message.AuthorName != "alice" || message.IsReply() {
+		t.Errorf("Unexpected message: %+v", message)
+	}
+	if !strings.Contains(message.Text, "We shipped!") || !strings.Contains(message.Text, "Release notes") {
+		t.Errorf("Expected message and attachment text, got %q", message.Text)
+	}
+	if !message.Time.Equal(time.Unix(1772665200, 0)) {
+		t.Errorf("Unexpected time: %s", message.Time)
+	}
+	if !reflect.DeepEqual(message.Files, []StoredFile{{Id: "F1", Name: "screenshot.png", Title: "Launch screenshot"}}) {
+		t.Errorf("Unexpected files: %+v", message.Files)
+	}
+	if !reflect.DeepEqual(message.Reactions, []StoredReaction{{Name: "tada", Count: 2}}) {
+		t.Errorf("Unexpected reactions: %+v", message.Reactions)
+	}
+	for _, term := range []string{"shipped", "release", "alice", "launch", "png"} {
+		if !containsString(message.Terms, term) {
+			t.Errorf("Expected %q in terms %v", term, message.Terms)
+		}
+	}
+	if reply := messages[1]; !reply.IsReply() || reply.AuthorId != "U2" || reply.Text != "congrats" {
+		t.Errorf("Unexpected reply: %+v", reply)
+	}
+}
+
+func TestNewMessageSearchQuery(t *testing.T) {
+	account := initTestApp(t)
+	query, err := newMessageSearchQuery(" deploy ", "C1", "@alice", "2026-03-01", "2026-03-02", account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Text != "deploy" || query.ConversationId != "C1" || query.Author != "@alice" {
+		t.Errorf("Unexpected query: %+v", query)
+	}
+	if expected := time.Date(2026, time.March, 3, 0, 0, 0, 0, account.TimezoneLocation); !query.EndTime.Equal(expected) {
+		t.Errorf("End date should be inclusive, got %s", query.EndTime)
+	}
+	for _, dates := range [][2]string{{"March 1", ""}, {"", "2026-13-01"}, {"2026-03-02", "2026-03-01"}} {
+		if _, err := newMessageSearchQuery("", "", "", dates[0], dates[1], account); err == nil {
+			t.Errorf("Expected an error for %v", dates)
+		}
+	}
+	if query, _ := newMessageSearchQuery("  ", "", "", "", "", account); !query.Empty() {
+		t.Errorf("Blank query should be empty: %+v", query)
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	account := initTestApp(t)
+	c := context.Background()
+	day := time.Date(2026, time.March, 4, 9, 0, 0, 0, account.TimezoneLocation)
+	messages := []*StoredMessage{
+		newTestStoredMessage("U1", "C1", "1", "deploy started"),
+		newTestStoredMessage("U1", "C1", "2", "deploy done"),
+		newTestStoredMessage("U1", "C2", "3", "deploy the docs"),
+	}
+	for i, message := range messages {
+		message.Time = day.AddDate(0, 0, i)
+		message.AuthorId = "U2"
+		message.AuthorName = "Bob"
+	}
+	messages[2].AuthorId = "U3"
+	messages[2].AuthorName = "carol"
+	if err := messageStore.Put(c, messages); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		query    MessageSearchQuery
+		expected []string
+	}{
+		{MessageSearchQuery{Text: "Deploy"}, []string{"3", "2", "1"}},
+		{MessageSearchQuery{Text: "deploy done"}, []string{"2"}},
+		{MessageSearchQuery{Text: "deploy", ConversationId: "C1"}, []string{"2", "1"}},
+		{MessageSearchQuery{Author: "@bob"}, []string{"2", "1"}},
+		{MessageSearchQuery{Author: "U3"}, []string{"3"}},
+		{MessageSearchQuery{StartTime: day.AddDate(0, 0, 1), EndTime: day.AddDate(0, 0, 2)}, []string{"2"}},
+	} {
+		results, err := searchMessages(c, account.SlackUserId, &test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := make([]string, 0, len(results))
+		for _, message := range results {
+			actual = append(actual, message.Timestamp)
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%+v: got %v, want %v", test.query, actual, test.expected)
+		}
+	}
+}
+
+func TestSearchTemplate(t *testing.T) {
+	account := initTestApp(t)
+	templates := loadTemplates()
+	message := newTestStoredMessage("U1", "C1", "1", "deploy <done>")
+	message.ConversationName = "#general"
+	message.ConversationType = "channel"
+	message.ConversationRef = "C1"
+	message.AuthorName = "bob"
+	message.Files = []StoredFile{{Name: "log.txt", Permalink: "https://example.slack.com/files/F1"}}
+	var data = map[string]interface{}{
+		"Query":            &MessageSearchQuery{Text: "deploy"},
+		"Conversations":    []Conversation{newTestChannelConversation("C1", "general")},
+		"Messages":         []*StoredMessage{message},
+		"MaxResults":       MessageSearchMaxResults,
+		"TimezoneLocation": account.TimezoneLocation,
+	}
+	var buffer bytes.Buffer
+	if err := templates["search"].ExecuteTemplate(&buffer, "body", data); err != nil {
+		t.Fatal(err)
+	}
+	html := buffer.String()
+	for _, expected := range []string{
+		"value=\"deploy\"",
+		"1 match.",
+		"href=\"/archive/conversation/channel/C1\"",
+		"deploy &lt;done&gt;",
+		"<a href=\"https://example.slack.com/files/F1\">log.txt</a>",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected %q in %s", expected, html)
+		}
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"html/template"
 	"log"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -15,125 +14,96 @@ import (
 
 const (
 	MessageGroupDisplayTimestampFormat = "3:04pm"
-	MessageTextBlockquotePrefix1       = "&gt;"
-	MessageTextBlockquotePrefix2       = ">>>"
-	MessageTextControlRegexp           = "<(.*?)>"
-	MessageTextEmojiRegexp             = ":([a-z0-9_\\-+]+):"
-	MessageTextBoldRegexp              = "\\*([^*]+)\\*"
-	MessageTextItalicRegexp            = "_([^_]+)_"
-	MessageTextStrikethroughRegexp     = "~([^~]+)~"
-	MessageTextInlineCodeRegexp        = "`([^`]+)`"
+
+	MessageFileKindImage   = "image"
+	MessageFileKindSnippet = "snippet"
+	MessageFileKindPdf     = "pdf"
+	MessageFileKindAudio   = "audio"
+	MessageFileKindVideo   = "video"
+	MessageFileKindOther   = "other"
 )
 
-var controlRegexp *regexp.Regexp
-var emojiRegexp *regexp.Regexp
-var boldRegexp *regexp.Regexp
-var italicRegexp *regexp.Regexp
-var strikethroughRegexp *regexp.Regexp
-var inlineCodeRegexp *regexp.Regexp
+var messageFileKindIcons = map[string]string{
+	MessageFileKindImage:   "🖼️",
+	MessageFileKindSnippet: "📝",
+	MessageFileKindPdf:     "📕",
+	MessageFileKindAudio:   "🎵",
+	MessageFileKindVideo:   "🎬",
+	MessageFileKindOther:   "📄",
+}
+
+// Control sequences (<...>) in message text are links, user or channel
+// mentions, or special commands like <!here>.
+type messageControl struct {
+	url  string
+	text string
+	// Set for user and channel mentions, whose text is enough on its own.
+	mention bool
+	// Set for special commands (the URL and text are not).
+	command string
+}
+
+func parseMessageControl(control string, slackClient *slack.Client) messageControl {
+	anchorText := ""
+	pipeIndex := strings.LastIndex(control, "|")
+	if pipeIndex != -1 {
+		anchorText = control[pipeIndex+1:]
+		control = control[:pipeIndex]
+	}
+	mention := false
+	if strings.HasPrefix(control, "@U") {
+		userId := strings.TrimPrefix(control, "@")
+		userLookup, err := newUserLookup(slackClient)
+		if err == nil {
+			user, err := userLookup.GetUser(userId)
+			if err == nil {
+				anchorText = fmt.Sprintf("@%s", user.Name)
+				control = userMentionUrl(user)
+				mention = true
+			} else {
+				log.Printf("Could not render user mention: %s", err)
+			}
+		} else {
+			log.Printf("Could not render user mention: %s", err)
+		}
+	} else if strings.HasPrefix(control, "#C") {
+		channelId := strings.TrimPrefix(control, "#")
+		channel, err := slackClient.GetConversationInfo(channelId, false)
+		if err == nil {
+			anchorText = fmt.Sprintf("#%s", channel.Name)
+			control = channelMentionUrl(channelId)
+			mention = true
+		} else {
+			log.Printf("Could not render channel mention: %s", err)
+		}
+	} else if strings.HasPrefix(control, "!") {
+		return messageControl{command: strings.TrimPrefix(control, "!")}
+	}
+	if anchorText == "" {
+		anchorText = control
+	}
+	return messageControl{url: control, text: anchorText, mention: mention}
+}
+
+func userMentionUrl(user *slack.User) string {
+	return fmt.Sprintf("https://slack.com/app_redirect?team=%s&channel=%s", user.TeamID, user.ID)
+}
 
-func init() {
-	controlRegexp = regexp.MustCompile(MessageTextControlRegexp)
-	emojiRegexp = regexp.MustCompile(MessageTextEmojiRegexp)
-	boldRegexp = regexp.MustCompile(MessageTextBoldRegexp)
-	italicRegexp = regexp.MustCompile(MessageTextItalicRegexp)
-	strikethroughRegexp = regexp.MustCompile(MessageTextStrikethroughRegexp)
-	inlineCodeRegexp = regexp.MustCompile(MessageTextInlineCodeRegexp)
+func channelMentionUrl(channelId string) string {
+	return fmt.Sprintf("https://slack.com/app_redirect?channel=%s", channelId)
 }
 
 func textToHtml(text string, truncate bool, slackClient *slack.Client) template.HTML {
 	if truncate && len(text) > 700 {
 		text = fmt.Sprintf("%s...", text[:700])
 	}
-	lines := strings.Split(text, "\n")
-	if truncate && len(lines) > 5 {
-		lines = append(lines[:5], "...")
-	}
-	htmlPieces := []string{}
-	for i, line := range lines {
-		linePrefix := ""
-		lineSuffix := ""
-		if strings.HasPrefix(line, MessageTextBlockquotePrefix1) ||
-			strings.HasPrefix(line, MessageTextBlockquotePrefix2) {
-			line = strings.TrimPrefix(line, MessageTextBlockquotePrefix1)
-			line = strings.TrimPrefix(line, MessageTextBlockquotePrefix2)
-			if line == "" {
-				// Ensure that even empty blockquote lines get rendered.
-				line = "\u200b"
-			}
-			linePrefix = fmt.Sprintf("<blockquote style='%s'>",
-				Style("message.blockquote"))
-			lineSuffix = "</blockquote>"
-		} else {
-			if i != 0 {
-				lineSuffix = "<br>"
-			}
+	if truncate {
+		lines := strings.Split(text, "\n")
+		if len(lines) > 5 {
+			text = strings.Join(append(lines[:5], "..."), "\n")
 		}
-		line = controlRegexp.ReplaceAllStringFunc(line, func(control string) string {
-			control = control[1 : len(control)-1]
-			anchorText := ""
-			pipeIndex := strings.LastIndex(control, "|")
-			if pipeIndex != -1 {
-				anchorText = control[pipeIndex+1:]
-				control = control[:pipeIndex]
-			}
-			if strings.HasPrefix(control, "@U") {
-				userId := strings.TrimPrefix(control, "@")
-				userLookup, err := newUserLookup(slackClient)
-				if err == nil {
-					user, err := userLookup.GetUser(userId)
-					if err == nil {
-						anchorText = fmt.Sprintf("@%s", user.Name)
-						control = fmt.Sprintf("https://slack.com/app_redirect?team=%s&channel=%s", user.TeamID, userId)
-					} else {
-						log.Printf("Could not render user mention: %s", err)
-					}
-				} else {
-					log.Printf("Could not render user mention: %s", err)
-				}
-			} else if strings.HasPrefix(control, "#C") {
-				channelId := strings.TrimPrefix(control, "#")
-				channel, err := slackClient.GetConversationInfo(channelId, false)
-				if err == nil {
-					anchorText = fmt.Sprintf("#%s", channel.Name)
-					control = fmt.Sprintf("https://slack.com/app_redirect?channel=%s", channelId)
-				} else {
-					log.Printf("Could not render channel mention: %s", err)
-				}
-			} else if strings.HasPrefix(control, "!") {
-				command := strings.TrimPrefix(control, "!")
-				return fmt.Sprintf("<b>@%s</b>", command)
-			}
-			if anchorText == "" {
-				anchorText = control
-			}
-			// Escape underscores to avoid triggering the italics regexp if
-			// a filename has underscores in it.
-			return fmt.Sprintf("<a href='%s' style='%s'>%s</a>",
-				strings.Replace(control, "_", "%5F", -1),
-				Style("message.link"),
-				strings.Replace(anchorText, "_", "&#95;", -1))
-		})
-		line = emojiRegexp.ReplaceAllStringFunc(line, func(emojiString string) string {
-			shortName := emojiString[1 : len(emojiString)-1]
-			if emojiHtml, err := getEmojiHtml(shortName, slackClient); err == nil {
-				return fmt.Sprintf("<span title=\"%s\">%s</span>", emojiString, emojiHtml)
-			}
-			return emojiString
-		})
-		line = boldRegexp.ReplaceAllString(line, "<b>$1</b>")
-		line = italicRegexp.ReplaceAllString(line, "<i>$1</i>")
-		line = strikethroughRegexp.ReplaceAllString(line, "<del>$1</del>")
-		line = inlineCodeRegexp.ReplaceAllString(
-			line,
-			fmt.Sprintf("<code style='%s'>$1</code>", Style("message.code")))
-
-		htmlPieces = append(htmlPieces, linePrefix)
-		// Slack's API claims that all HTML is already escaped
-		htmlPieces = append(htmlPieces, line)
-		htmlPieces = append(htmlPieces, lineSuffix)
 	}
-	return template.HTML(strings.Join(htmlPieces, ""))
+	return template.HTML(renderMrkdwnHtml(parseMrkdwn(text), slackClient))
 }
 
 type Message struct {
@@ -142,6 +112,9 @@ type Message struct {
 	slackClient        *slack.Client
 	userLookup         *UserLookup
 	account            *Account
+	// Files that are included in the archive email (as opposed to linked to),
+	// if any.
+	emailFiles *archiveEmailFiles
 }
 
 func (m *Message) TimestampTime() time.Time {
@@ -157,6 +130,18 @@ func (m *Message) TextHtml() template.HTML {
 	return textToHtml(m.Text, false, m.slackClient)
 }
 
+// Messages from apps (and formatted messages from users) have their content in
+// blocks, with the text only being a fallback. The text is still used if none
+// of the blocks can be rendered (see blocksToHtml for partial fallbacks).
+func (m *Message) BodyHtml() template.HTML {
+	if len(m.Blocks.BlockSet) > 0 {
+		if blocksHtml, ok := blocksToHtml(m.Blocks.BlockSet, m); ok {
+			return template.HTML(blocksHtml)
+		}
+	}
+	return m.TextHtml()
+}
+
 func (m *Message) StylePath() string {
 	if strings.HasPrefix(m.SubType, "channel_") || strings.HasPrefix(m.SubType, "group_") {
 		return "message.automated"
@@ -176,11 +161,18 @@ func (m *Message) MessageAttachments() []*MessageAttachment {
 	return attachments
 }
 
-func (m *Message) MessageFile() *MessageFile {
-	if len(m.Files) > 0 {
-		return &MessageFile{&m.Files[0], m.slackClient, m.account}
+func (m *Message) MessageFiles() []*MessageFile {
+	files := make([]*MessageFile, 0, len(m.Files))
+	for i := range m.Files {
+		file := &MessageFile{File: &m.Files[i], slackClient: m.slackClient, account: m.account}
+		if m.emailFiles != nil {
+			file.contentId = m.emailFiles.thumbnailContentIds[file.ID]
+			file.attachmentName = m.emailFiles.attachmentNames[file.ID]
+			file.thumbnailPath = m.emailFiles.thumbnailPaths[file.ID]
+		}
+		files = append(files, file)
 	}
-	return nil
+	return files
 }
 
 func (m *Message) MessageReactions() []*MessageReaction {
@@ -278,18 +270,35 @@ type MessageFile struct {
 	*slack.File
 	slackClient *slack.Client
 	account     *Account
+	// Set if the thumbnail is embedded in the email.
+	contentId string
+	// Set if the file is attached to the email.
+	attachmentName string
+	// Set if the thumbnail was saved with a site export (relative to the
+	// page).
+	thumbnailPath string
 }
 
-func (f *MessageFile) ThumbnailUrl() (string, error) {
+// ThumbnailUrl returns a cid: URL if the thumbnail was embedded in the email
+// (or a relative one if it was saved with a site export), otherwise an URL
+// that proxies it (since Slack requires authentication for file URLs).
+func (f *MessageFile) ThumbnailUrl() (template.URL, error) {
 	if f.Thumb360 == "" {
 		return "", nil
 	}
+	if f.contentId != "" {
+		return template.URL("cid:" + f.contentId), nil
+	}
+	if f.thumbnailPath != "" {
+		return template.URL(f.thumbnailPath), nil
+	}
 	ref := FileUrlRef{f.ID, f.account.SlackUserId}
 	encodedRef, err := ref.Encode()
 	if err != nil {
 		return "", err
 	}
-	return AbsoluteRouteUrl("archive-file-thumbnail", "ref", encodedRef)
+	thumbnailUrl, err := AbsoluteRouteUrl("archive-file-thumbnail", "ref", encodedRef)
+	return template.URL(thumbnailUrl), err
 }
 
 func (f *MessageFile) ThumbnailWidth() int {
@@ -300,6 +309,81 @@ func (f *MessageFile) ThumbnailHeight() int {
 	return f.Thumb360H
 }
 
+// Used to pick the card that non-image files are rendered with.
+func (f *MessageFile) Kind() string {
+	switch {
+	case f.Thumb360 != "":
+		return MessageFileKindImage
+	case f.Preview != "" || f.PreviewHighlight != "":
+		return MessageFileKindSnippet
+	case f.Filetype == "pdf" || f.Mimetype == "application/pdf":
+		return MessageFileKindPdf
+	case strings.HasPrefix(f.Mimetype, "audio/"):
+		return MessageFileKindAudio
+	case strings.HasPrefix(f.Mimetype, "video/"):
+		return MessageFileKindVideo
+	}
+	return MessageFileKindOther
+}
+
+func (f *MessageFile) Icon() string {
+	return messageFileKindIcons[f.Kind()]
+}
+
+func (f *MessageFile) DisplayTitle() string {
+	if f.Title != "" {
+		return f.Title
+	}
+	return f.Name
+}
+
+func (f *MessageFile) DisplayType() string {
+	if f.PrettyType != "" {
+		return f.PrettyType
+	}
+	return strings.ToUpper(f.Filetype)
+}
+
+func (f *MessageFile) DisplaySize() string {
+	return formatFileSize(f.Size)
+}
+
+// "PDF, 1.2 MB"-style summary of the type and size.
+func (f *MessageFile) Details() string {
+	details := make([]string, 0, 2)
+	if displayType := f.DisplayType(); displayType != "" {
+		details = append(details, displayType)
+	}
+	if f.Size > 0 {
+		details = append(details, f.DisplaySize())
+	}
+	return strings.Join(details, ", ")
+}
+
+// Name of the email attachment that has the file's contents, if it was
+// attached.
+func (f *MessageFile) AttachmentName() string {
+	return f.attachmentName
+}
+
+func formatFileSize(size int) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size) / unit
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		if value < unit || suffix == "GB" {
+			if value < 10 {
+				return fmt.Sprintf("%.1f %s", value, suffix)
+			}
+			return fmt.Sprintf("%.0f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return ""
+}
+
 func (f *MessageFile) PreviewHtml() template.HTML {
 	if f.PreviewHighlight != "" {
 		return template.HTML(f.PreviewHighlight)
@@ -366,6 +450,11 @@ func (mg *MessageGroup) shouldContainMessage(message *Message, messageAuthor *sl
 	if timestampDelta > time.Minute*10 {
 		return false
 	}
+	// Multi-day archives have separators between days, so groups can't span
+	// them.
+	if !isSameDay(message.TimestampTime(), lastMessage.TimestampTime()) {
+		return false
+	}
 	return true
 }
 
@@ -386,7 +475,7 @@ func groupMessages(messages []*slack.Message, slackClient *slack.Client, account
 		return nil, err
 	}
 	for i := range messages {
-		message := &Message{messages[i], []*MessageGroup{}, slackClient, userLookup, account}
+		message := &Message{messages[i], []*MessageGroup{}, slackClient, userLookup, account, nil}
 		if message.Hidden {
 			continue
 		}
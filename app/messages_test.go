@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+func TestFormatFileSize(t *testing.T) {
+	for _, test := range []struct {
+		size     int
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{20 * 1024, "20 KB"},
+		{5 * 1024 * 1024, "5.0 MB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GB"},
+		{2048 * 1024 * 1024 * 1024, "2048 GB"},
+	} {
+		if actual := formatFileSize(test.size); actual != test.expected {
+			t.Errorf("formatFileSize(%d): got %q, want %q", test.size, actual, test.expected)
+		}
+	}
+}
+
+func TestMessageFileKind(t *testing.T) {
+	for _, test := range []struct {
+		file     slack.File
+		expected string
+	}{
+		{slack.File{Mimetype: "image/png", Thumb360: "https://files.example.com/thumb.png"}, MessageFileKindImage},
+		{slack.File{Filetype: "python", Preview: "print('hi')"}, MessageFileKindSnippet},
+		{slack.File{Filetype: "pdf", Mimetype: "application/pdf"}, MessageFileKindPdf},
+		{slack.File{Filetype: "mp3", Mimetype: "audio/mpeg"}, MessageFileKindAudio},
+		{slack.File{Filetype: "mp4", Mimetype: "video/mp4"}, MessageFileKindVideo},
+		{slack.File{Filetype: "zip", Mimetype: "application/zip"}, MessageFileKindOther},
+		// Images without a thumbnail (e.g. still processing) get a card.
+		{slack.File{Filetype: "png", Mimetype: "image/png"}, MessageFileKindOther},
+	} {
+		file := &MessageFile{File: &test.file}
+		if actual := file.Kind(); actual != test.expected {
+			t.Errorf("Kind for %s: got %s, want %s", test.file.Mimetype, actual, test.expected)
+		}
+	}
+}
+
+func TestMessageTemplateFiles(t *testing.T) {
+	account := initTestApp(t)
+	fileUrlRefEncryptionKey = []byte("0123456789abcdef")
+	templates := loadTemplates()
+	message := newTestMessage(account, slack.Msg{
+		Timestamp: "1772668800",
+		Files: []slack.File{
+			{ID: "F1", Title: "first.png", Thumb360: "https://files.example.com/1.png",
+				Thumb360W: 360, Thumb360H: 240, URLPrivate: "https://files.example.com/F1"},
+			{ID: "F2", Title: "second.png", Thumb360: "https://files.example.com/2.png",
+				Thumb360W: 240, Thumb360H: 360, URLPrivate: "https://files.example.com/F2"},
+			{ID: "F3", Name: "report.pdf", Filetype: "pdf", PrettyType: "PDF",
+				Mimetype: "application/pdf", Size: 1258291, URLPrivate: "https://files.example.com/F3"},
+			{ID: "F4", Title: "notes", Filetype: "text", PrettyType: "Plain Text",
+				Preview: "some notes", Size: 10, URLPrivate: "https://files.example.com/F4"},
+		},
+	})
+	var buffer bytes.Buffer
+	if err := templates["conversation-archive-email"].ExecuteTemplate(&buffer, "message", message); err != nil {
+		t.Fatal(err)
+	}
+	html := buffer.String()
+	if count := strings.Count(html, "/archive/file-thumbnail/"); count != 2 {
+		t.Errorf("Expected 2 thumbnails, got %d in %s", count, html)
+	}
+	for _, expected := range []string{
+		"href=\"https://files.example.com/F1\"",
+		"href=\"https://files.example.com/F2\"",
+		"report.pdf</a>",
+		"PDF, 1.2 MB",
+		"📕",
+		"Plain Text, 10 B",
+		"some notes",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected %q in %s", expected, html)
+		}
+	}
+}
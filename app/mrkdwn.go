@@ -0,0 +1,525 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/slack-go/slack"
+)
+
+// Parser for Slack's mrkdwn message formatting
+// (https://api.slack.com/reference/surfaces/formatting), producing a small AST
+// that can then be rendered as HTML (for archive emails and pages), as plain
+// text or as CommonMark (for Markdown exports). Message text from the API
+// already has &, < and > escaped, and text nodes keep that escaping.
+
+type MrkdwnNodeType int
+
+const (
+	MrkdwnText MrkdwnNodeType = iota
+	MrkdwnBold
+	MrkdwnItalic
+	MrkdwnStrikethrough
+	MrkdwnCode
+	MrkdwnCodeBlock
+	// Links, user and channel mentions and special commands (<...>).
+	MrkdwnControl
+	MrkdwnEmoji
+	MrkdwnLineBreak
+	// A single blockquoted line.
+	MrkdwnQuote
+)
+
+const (
+	MrkdwnCodeBlockFence          = "```"
+	MessageTextBlockquotePrefix   = "&gt;"
+	MessageTextBlockquoteAllFence = "&gt;&gt;&gt;"
+	// Not escaped in some (older) messages.
+	MessageTextBlockquoteAllFenceUnescaped = ">>>"
+	MessageTextEmojiRegexp                 = "^:([a-z0-9_\\-+]+):"
+)
+
+type MrkdwnNode struct {
+	Type MrkdwnNodeType
+	// Contents of text, code and control nodes, and the short name of emoji.
+	Text string
+	// Contents of formatting and quote nodes.
+	Children []*MrkdwnNode
+}
+
+var emojiRegexp *regexp.Regexp
+
+func init() {
+	emojiRegexp = regexp.MustCompile(MessageTextEmojiRegexp)
+}
+
+func parseMrkdwn(text string) []*MrkdwnNode {
+	nodes := make([]*MrkdwnNode, 0)
+	quoteAll := false
+	addLines := func(text string) {
+		for i, line := range strings.Split(text, "\n") {
+			if i != 0 {
+				nodes = append(nodes, &MrkdwnNode{Type: MrkdwnLineBreak})
+			}
+			if !quoteAll {
+				if strings.HasPrefix(line, MessageTextBlockquoteAllFence) ||
+					strings.HasPrefix(line, MessageTextBlockquoteAllFenceUnescaped) {
+					quoteAll = true
+					line = strings.TrimPrefix(line, MessageTextBlockquoteAllFence)
+					line = strings.TrimPrefix(line, MessageTextBlockquoteAllFenceUnescaped)
+				} else if strings.HasPrefix(line, MessageTextBlockquotePrefix) {
+					line = strings.TrimPrefix(line, MessageTextBlockquotePrefix)
+					nodes = append(nodes, &MrkdwnNode{
+						Type:     MrkdwnQuote,
+						Children: parseMrkdwnInline(strings.TrimPrefix(line, " ")),
+					})
+					continue
+				} else {
+					if line != "" {
+						nodes = append(nodes, parseMrkdwnInline(line)...)
+					}
+					continue
+				}
+			}
+			nodes = append(nodes, &MrkdwnNode{
+				Type:     MrkdwnQuote,
+				Children: parseMrkdwnInline(strings.TrimPrefix(line, " ")),
+			})
+		}
+	}
+	for {
+		fenceStart := strings.Index(text, MrkdwnCodeBlockFence)
+		if fenceStart == -1 {
+			break
+		}
+		contentStart := fenceStart + len(MrkdwnCodeBlockFence)
+		fenceEnd := strings.Index(text[contentStart:], MrkdwnCodeBlockFence)
+		if fenceEnd == -1 {
+			break
+		}
+		fenceEnd += contentStart
+		if fenceStart > 0 {
+			addLines(strings.TrimSuffix(text[:fenceStart], "\n"))
+		}
+		code := text[contentStart:fenceEnd]
+		code = strings.TrimPrefix(code, "\n")
+		code = strings.TrimSuffix(code, "\n")
+		nodes = append(nodes, &MrkdwnNode{Type: MrkdwnCodeBlock, Text: code})
+		text = strings.TrimPrefix(text[fenceEnd+len(MrkdwnCodeBlockFence):], "\n")
+	}
+	if text != "" {
+		addLines(text)
+	}
+	return nodes
+}
+
+// Parses formatting within a single line.
+func parseMrkdwnInline(text string) []*MrkdwnNode {
+	nodes := make([]*MrkdwnNode, 0)
+	textStart := 0
+	flushText := func(end int) {
+		if end > textStart {
+			nodes = append(nodes, &MrkdwnNode{Type: MrkdwnText, Text: text[textStart:end]})
+		}
+	}
+	for i := 0; i < len(text); {
+		switch c := text[i]; c {
+		case '<':
+			if end := strings.IndexByte(text[i+1:], '>'); end != -1 {
+				flushText(i)
+				nodes = append(nodes, &MrkdwnNode{Type: MrkdwnControl, Text: text[i+1 : i+1+end]})
+				i += end + 2
+				textStart = i
+				continue
+			}
+		case ':':
+			if match := emojiRegexp.FindStringSubmatch(text[i:]); match != nil {
+				flushText(i)
+				nodes = append(nodes, &MrkdwnNode{Type: MrkdwnEmoji, Text: match[1]})
+				i += len(match[0])
+				textStart = i
+				continue
+			}
+		case '`':
+			if end := strings.IndexByte(text[i+1:], '`'); end > 0 {
+				flushText(i)
+				nodes = append(nodes, &MrkdwnNode{Type: MrkdwnCode, Text: text[i+1 : i+1+end]})
+				i += end + 2
+				textStart = i
+				continue
+			}
+		case '*', '_', '~':
+			if canOpenMrkdwnFormatting(text, i) {
+				if end := findMrkdwnFormattingEnd(text, i); end != -1 {
+					flushText(i)
+					nodes = append(nodes, &MrkdwnNode{
+						Type:     mrkdwnFormattingTypes[c],
+						Children: parseMrkdwnInline(text[i+1 : end]),
+					})
+					i = end + 1
+					textStart = i
+					continue
+				}
+			}
+		}
+		i++
+	}
+	flushText(len(text))
+	return nodes
+}
+
+var mrkdwnFormattingTypes = map[byte]MrkdwnNodeType{
+	'*': MrkdwnBold,
+	'_': MrkdwnItalic,
+	'~': MrkdwnStrikethrough,
+}
+
+// Formatting characters only count at word boundaries, so that intraword
+// underscores (snake_case_name) and asterisks (2*3*4) are left alone. Runs of
+// the same character (**, ~~) don't count either.
+func isMrkdwnWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func canOpenMrkdwnFormatting(text string, i int) bool {
+	if i > 0 {
+		previous, _ := utf8.DecodeLastRuneInString(text[:i])
+		if isMrkdwnWordRune(previous) || text[i-1] == text[i] {
+			return false
+		}
+	}
+	return i+1 < len(text) && !unicode.IsSpace(rune(text[i+1])) && text[i+1] != text[i]
+}
+
+// Finds the matching closing delimiter, skipping over controls and code spans
+// (so that formatting can span links but not be closed inside them).
+func findMrkdwnFormattingEnd(text string, start int) int {
+	delimiter := text[start]
+	for i := start + 1; i < len(text); i++ {
+		switch text[i] {
+		case '<':
+			if end := strings.IndexByte(text[i+1:], '>'); end != -1 {
+				i += end + 1
+				continue
+			}
+		case '`':
+			if end := strings.IndexByte(text[i+1:], '`'); end > 0 {
+				i += end + 1
+				continue
+			}
+		case delimiter:
+			if i == start+1 || unicode.IsSpace(rune(text[i-1])) {
+				continue
+			}
+			if i+1 < len(text) {
+				next, _ := utf8.DecodeRuneInString(text[i+1:])
+				if isMrkdwnWordRune(next) || text[i+1] == delimiter {
+					continue
+				}
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+type mrkdwnRenderer struct {
+	slackClient *slack.Client
+}
+
+func renderMrkdwnHtml(nodes []*MrkdwnNode, slackClient *slack.Client) string {
+	var builder strings.Builder
+	renderer := &mrkdwnRenderer{slackClient}
+	renderer.writeHtml(&builder, nodes)
+	return builder.String()
+}
+
+func renderMrkdwnPlainText(nodes []*MrkdwnNode, slackClient *slack.Client) string {
+	var builder strings.Builder
+	renderer := &mrkdwnRenderer{slackClient}
+	renderer.writePlainText(&builder, nodes)
+	return builder.String()
+}
+
+func renderMrkdwnMarkdown(nodes []*MrkdwnNode, slackClient *slack.Client) string {
+	var builder strings.Builder
+	renderer := &mrkdwnRenderer{slackClient}
+	renderer.writeMarkdown(&builder, nodes, true)
+	return builder.String()
+}
+
+func isMrkdwnBlockNode(node *MrkdwnNode) bool {
+	return node.Type == MrkdwnQuote || node.Type == MrkdwnCodeBlock
+}
+
+func (r *mrkdwnRenderer) writeHtml(builder *strings.Builder, nodes []*MrkdwnNode) {
+	for i, node := range nodes {
+		switch node.Type {
+		case MrkdwnText:
+			// Slack's API claims that all HTML is already escaped
+			builder.WriteString(node.Text)
+		case MrkdwnBold:
+			builder.WriteString("<b>")
+			r.writeHtml(builder, node.Children)
+			builder.WriteString("</b>")
+		case MrkdwnItalic:
+			builder.WriteString("<i>")
+			r.writeHtml(builder, node.Children)
+			builder.WriteString("</i>")
+		case MrkdwnStrikethrough:
+			builder.WriteString("<del>")
+			r.writeHtml(builder, node.Children)
+			builder.WriteString("</del>")
+		case MrkdwnCode:
+			fmt.Fprintf(builder, "<code style='%s'>%s</code>",
+				Style("message.code"), r.controlsToText(node.Text))
+		case MrkdwnCodeBlock:
+			fmt.Fprintf(builder, "<pre style='%s'>%s</pre>",
+				Style("message.pre"), r.controlsToText(node.Text))
+		case MrkdwnControl:
+			control := parseMessageControl(node.Text, r.slackClient)
+			if control.command != "" {
+				fmt.Fprintf(builder, "<b>@%s</b>", control.command)
+			} else {
+				fmt.Fprintf(builder, "<a href='%s' style='%s'>%s</a>",
+					strings.Replace(control.url, "'", "%27", -1),
+					Style("message.link"),
+					control.text)
+			}
+		case MrkdwnEmoji:
+			if emojiHtml, err := getEmojiHtml(node.Text, r.slackClient); err == nil {
+				fmt.Fprintf(builder, "<span title=\":%s:\">%s</span>", node.Text, emojiHtml)
+			} else {
+				fmt.Fprintf(builder, ":%s:", node.Text)
+			}
+		case MrkdwnLineBreak:
+			// Block elements already start on their own line.
+			if (i == 0 || !isMrkdwnBlockNode(nodes[i-1])) &&
+				(i == len(nodes)-1 || !isMrkdwnBlockNode(nodes[i+1])) {
+				builder.WriteString("<br>")
+			}
+		case MrkdwnQuote:
+			fmt.Fprintf(builder, "<blockquote style='%s'>", Style("message.blockquote"))
+			if len(node.Children) == 0 {
+				// Ensure that even empty blockquote lines get rendered.
+				builder.WriteString("\u200b")
+			}
+			r.writeHtml(builder, node.Children)
+			builder.WriteString("</blockquote>")
+		}
+	}
+}
+
+func (r *mrkdwnRenderer) writePlainText(builder *strings.Builder, nodes []*MrkdwnNode) {
+	for i, node := range nodes {
+		switch node.Type {
+		case MrkdwnText:
+			builder.WriteString(html.UnescapeString(node.Text))
+		case MrkdwnBold:
+			builder.WriteString("*")
+			r.writePlainText(builder, node.Children)
+			builder.WriteString("*")
+		case MrkdwnItalic:
+			builder.WriteString("_")
+			r.writePlainText(builder, node.Children)
+			builder.WriteString("_")
+		case MrkdwnStrikethrough:
+			builder.WriteString("~")
+			r.writePlainText(builder, node.Children)
+			builder.WriteString("~")
+		case MrkdwnCode:
+			fmt.Fprintf(builder, "`%s`", html.UnescapeString(r.controlsToText(node.Text)))
+		case MrkdwnCodeBlock:
+			if i != 0 && nodes[i-1].Type != MrkdwnLineBreak {
+				builder.WriteString("\n")
+			}
+			fmt.Fprintf(builder, "```\n%s\n```", html.UnescapeString(r.controlsToText(node.Text)))
+			if i != len(nodes)-1 && nodes[i+1].Type != MrkdwnLineBreak {
+				builder.WriteString("\n")
+			}
+		case MrkdwnControl:
+			control := parseMessageControl(node.Text, r.slackClient)
+			text := html.UnescapeString(control.text)
+			url := html.UnescapeString(control.url)
+			if control.command != "" {
+				fmt.Fprintf(builder, "@%s", control.command)
+			} else if control.mention || text == url || url == "mailto:"+text {
+				builder.WriteString(text)
+			} else {
+				fmt.Fprintf(builder, "%s (%s)", text, url)
+			}
+		case MrkdwnEmoji:
+			builder.WriteString(getEmojiText(node.Text))
+		case MrkdwnLineBreak:
+			builder.WriteString("\n")
+		case MrkdwnQuote:
+			builder.WriteString(">")
+			if len(node.Children) != 0 {
+				builder.WriteString(" ")
+			}
+			r.writePlainText(builder, node.Children)
+		}
+	}
+}
+
+// Slack line breaks are hard breaks in CommonMark (a trailing backslash),
+// except next to block elements, and multiple ones start a new paragraph.
+// lineStart is set if the nodes are at the start of a line (so that text that
+// would be read as a block marker is escaped).
+func (r *mrkdwnRenderer) writeMarkdown(builder *strings.Builder, nodes []*MrkdwnNode, lineStart bool) {
+	for i := 0; i < len(nodes); i++ {
+		node := nodes[i]
+		switch node.Type {
+		case MrkdwnText:
+			builder.WriteString(escapeMarkdown(html.UnescapeString(node.Text), lineStart))
+		case MrkdwnBold:
+			builder.WriteString("**")
+			r.writeMarkdown(builder, node.Children, false)
+			builder.WriteString("**")
+		case MrkdwnItalic:
+			builder.WriteString("*")
+			r.writeMarkdown(builder, node.Children, false)
+			builder.WriteString("*")
+		case MrkdwnStrikethrough:
+			builder.WriteString("~~")
+			r.writeMarkdown(builder, node.Children, false)
+			builder.WriteString("~~")
+		case MrkdwnCode:
+			builder.WriteString(markdownCodeSpan(html.UnescapeString(r.controlsToText(node.Text))))
+		case MrkdwnCodeBlock:
+			if !lineStart {
+				builder.WriteString("\n")
+			}
+			fmt.Fprintf(builder, "```\n%s\n```", html.UnescapeString(r.controlsToText(node.Text)))
+			if i != len(nodes)-1 && nodes[i+1].Type != MrkdwnLineBreak {
+				builder.WriteString("\n")
+			}
+		case MrkdwnControl:
+			control := parseMessageControl(node.Text, r.slackClient)
+			text := escapeMarkdown(html.UnescapeString(control.text), false)
+			url := html.UnescapeString(control.url)
+			if control.command != "" {
+				fmt.Fprintf(builder, "**@%s**", control.command)
+			} else if control.mention || url == "" {
+				builder.WriteString(text)
+			} else if html.UnescapeString(control.text) == url {
+				fmt.Fprintf(builder, "<%s>", url)
+			} else {
+				fmt.Fprintf(builder, "[%s](%s)", text, markdownLinkDestination(url))
+			}
+		case MrkdwnEmoji:
+			builder.WriteString(getEmojiText(node.Text))
+		case MrkdwnLineBreak:
+			breakCount := 1
+			for i+1 < len(nodes) && nodes[i+1].Type == MrkdwnLineBreak {
+				breakCount++
+				i++
+			}
+			var previous, next *MrkdwnNode
+			if i-breakCount >= 0 {
+				previous = nodes[i-breakCount]
+			}
+			if i+1 < len(nodes) {
+				next = nodes[i+1]
+			}
+			switch {
+			case previous == nil || next == nil:
+				builder.WriteString("\n")
+			case breakCount > 1 || (previous.Type == MrkdwnQuote && next.Type != MrkdwnQuote):
+				// A blank line also ends quotes (otherwise the next line
+				// would be a continuation of them).
+				builder.WriteString("\n\n")
+			case isMrkdwnBlockNode(previous) && len(previous.Children) == 0,
+				isMrkdwnBlockNode(next) && len(next.Children) == 0,
+				next.Type == MrkdwnQuote && previous.Type != MrkdwnQuote:
+				// Hard breaks can only be within paragraphs (code blocks and
+				// empty quote lines aren't, and quotes start new ones).
+				builder.WriteString("\n")
+			default:
+				builder.WriteString("\\\n")
+			}
+			lineStart = true
+			continue
+		case MrkdwnQuote:
+			builder.WriteString(">")
+			if len(node.Children) != 0 {
+				builder.WriteString(" ")
+			}
+			r.writeMarkdown(builder, node.Children, true)
+		}
+		lineStart = false
+	}
+}
+
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "[", "\\[", "]", "\\]", "<", "\\<", "~", "\\~")
+
+// Matches text at the start of a line that would be read as a quote, heading,
+// list item or thematic break.
+var markdownLineStartPattern = regexp.MustCompile(`^ *(>|#{1,6}( |$)|[+-]( |$)|[0-9]{1,9}[.)]( |$)|[=-]+ *$)`)
+
+// escapeMarkdown escapes characters that would otherwise be read as
+// formatting.
+func escapeMarkdown(text string, lineStart bool) string {
+	text = markdownEscaper.Replace(text)
+	if lineStart {
+		if match := markdownLineStartPattern.FindStringSubmatchIndex(text); match != nil {
+			// Escaping the (first) marker character is enough, after any
+			// digits for ordered list items.
+			markerStart := match[2]
+			for text[markerStart] >= '0' && text[markerStart] <= '9' {
+				markerStart++
+			}
+			text = text[:markerStart] + "\\" + text[markerStart:]
+		}
+	}
+	return text
+}
+
+// markdownCodeSpan uses a longer run of backticks than the code contains, so
+// that it can include them.
+func markdownCodeSpan(code string) string {
+	fence := "`"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		code = " " + code + " "
+	}
+	return fence + code + fence
+}
+
+func markdownLinkDestination(url string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(url)
+}
+
+// Code is shown verbatim, but links and mentions inside it are still encoded
+// as controls, so they're replaced with their text.
+func (r *mrkdwnRenderer) controlsToText(text string) string {
+	var builder strings.Builder
+	for {
+		start := strings.IndexByte(text, '<')
+		if start == -1 {
+			break
+		}
+		end := strings.IndexByte(text[start:], '>')
+		if end == -1 {
+			break
+		}
+		end += start
+		builder.WriteString(text[:start])
+		control := parseMessageControl(text[start+1:end], r.slackClient)
+		if control.command != "" {
+			fmt.Fprintf(&builder, "@%s", control.command)
+		} else {
+			builder.WriteString(control.text)
+		}
+		text = text[end+1:]
+	}
+	builder.WriteString(text)
+	return builder.String()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func initTestEmoji() {
+	emojiByShortName = map[string]*Emoji{
+		"+1":               {UnicodeCodePointHex: "1F44D"},
+		"white_check_mark": {UnicodeCodePointHex: "2705"},
+		"flag-us":          {UnicodeCodePointHex: "1F1FA-1F1F8"},
+	}
+}
+
+func TestTextToHtml(t *testing.T) {
+	initTestEmoji()
+	for _, test := range []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"plain", "just text", "just text"},
+		{"empty text", "", ""},
+		{"bold", "*bold*", "<b>bold</b>"},
+		{"italic", "_italic_", "<i>italic</i>"},
+		{"strikethrough", "~gone~", "<del>gone</del>"},
+		{"inline code", "`code`", "<code style=''>code</code>"},
+		{"multiple", "*one* and *two*", "<b>one</b> and <b>two</b>"},
+		{"mixed", "*b* _i_ ~s~ `c`", "<b>b</b> <i>i</i> <del>s</del> <code style=''>c</code>"},
+		{"surrounded by punctuation", "(*bold*), _italic_.", "(<b>bold</b>), <i>italic</i>."},
+		{"multi-word", "*several bold words*", "<b>several bold words</b>"},
+
+		// Nesting
+		{"italic in bold", "*bold _and italic_*", "<b>bold <i>and italic</i></b>"},
+		{"bold in italic", "_*both*_", "<i><b>both</b></i>"},
+		{"strike in bold", "*a ~b~ c*", "<b>a <del>b</del> c</b>"},
+		{"triple nesting", "~_*all*_~", "<del><i><b>all</b></i></del>"},
+		{"overlapping", "_a *b_ c*", "<i>a *b</i> c*"},
+
+		// Intraword delimiters
+		{"snake case", "snake_case_name", "snake_case_name"},
+		{"snake case in italics", "_snake_case_name_", "<i>snake_case_name</i>"},
+		{"multiplication", "2*3*4", "2*3*4"},
+		{"intraword tilde", "a~b~c", "a~b~c"},
+		{"closing before word", "~strike~through", "~strike~through"},
+		{"unicode intraword", "naïve_ünder_score", "naïve_ünder_score"},
+		{"path", "see /usr/local/my_file_name.txt", "see /usr/local/my_file_name.txt"},
+
+		// Delimiters that don't format
+		{"unclosed", "*bold", "*bold"},
+		{"no content", "**", "**"},
+		{"double", "**not bold**", "**not bold**"},
+		{"space after opener", "* not bold*", "* not bold*"},
+		{"space before closer", "*not bold *", "*not bold *"},
+		{"lone delimiters", "a * b _ c ~ d", "a * b _ c ~ d"},
+		{"formatting does not span lines", "*a\nb*", "*a<br>b*"},
+
+		// Links and other controls
+		{"link", "<https://example.com>", "<a href='https://example.com' style=''>https://example.com</a>"},
+		{"link with text", "<https://example.com|Example>", "<a href='https://example.com' style=''>Example</a>"},
+		{"underscores in link", "<https://example.com/a_b_c|a_b_c.txt>",
+			"<a href='https://example.com/a_b_c' style=''>a_b_c.txt</a>"},
+		{"formatting characters in link", "<https://example.com/*x*|_y_>",
+			"<a href='https://example.com/*x*' style=''>_y_</a>"},
+		{"bold spanning link", "*see <https://example.com|the docs>*",
+			"<b>see <a href='https://example.com' style=''>the docs</a></b>"},
+		{"italic closer inside link is ignored", "_a <https://example.com/b_|c> d_",
+			"<i>a <a href='https://example.com/b_' style=''>c</a> d</i>"},
+		{"quote in link", "<https://example.com/it's>", "<a href='https://example.com/it%27s' style=''>https://example.com/it's</a>"},
+		{"command", "<!here> look", "<b>@here</b> look"},
+		{"command with label", "<!subteam^S123|@team>", "<b>@subteam^S123</b>"},
+		{"unclosed control", "a &lt;b", "a &lt;b"},
+
+		// Code
+		{"formatting in code", "`*not bold*`", "<code style=''>*not bold*</code>"},
+		{"bold spanning code", "*run `a*b`*", "<b>run <code style=''>a*b</code></b>"},
+		{"link in code", "`curl <https://example.com>`", "<code style=''>curl https://example.com</code>"},
+		{"empty code", "``", "``"},
+		{"code block", "```\nfunc main() {\n  *x* = 1\n}\n```",
+			"<pre style=''>func main() {\n  *x* = 1\n}</pre>"},
+		{"inline code block", "before ```code``` after",
+			"before <pre style=''>code</pre> after"},
+		{"code block between lines", "before\n```\ncode\n```\nafter",
+			"before<pre style=''>code</pre>after"},
+		{"two code blocks", "```a```\n```b```", "<pre style=''>a</pre><pre style=''>b</pre>"},
+		{"unclosed code block", "```code", "```code"},
+		{"emoji in code block", "```:+1:```", "<pre style=''>:+1:</pre>"},
+		{"escaped code block", "```a &lt; b```", "<pre style=''>a &lt; b</pre>"},
+
+		// Lines and blockquotes
+		{"lines", "a\nb\nc", "a<br>b<br>c"},
+		{"blank line", "a\n\nb", "a<br><br>b"},
+		{"blockquote", "&gt;quoted", "<blockquote style=''>quoted</blockquote>"},
+		{"blockquote with space", "&gt; quoted", "<blockquote style=''>quoted</blockquote>"},
+		{"blockquote then text", "&gt;quoted\nnot quoted",
+			"<blockquote style=''>quoted</blockquote>not quoted"},
+		{"text then blockquote", "said:\n&gt;quoted",
+			"said:<blockquote style=''>quoted</blockquote>"},
+		{"formatted blockquote", "&gt;*bold* quote",
+			"<blockquote style=''><b>bold</b> quote</blockquote>"},
+		{"empty blockquote", "&gt;", "<blockquote style=''>​</blockquote>"},
+		{"blockquote everything", "&gt;&gt;&gt;all\nof this",
+			"<blockquote style=''>all</blockquote><blockquote style=''>of this</blockquote>"},
+		{"unescaped blockquote everything", ">>>all\nof this",
+			"<blockquote style=''>all</blockquote><blockquote style=''>of this</blockquote>"},
+		{"greater than mid-line", "a &gt; b", "a &gt; b"},
+
+		// Emoji
+		{"emoji", ":+1:", "<span title=\":+1:\">&#x1F44D;</span>"},
+		{"emoji with underscores", "_done :white_check_mark:_",
+			"<i>done <span title=\":white_check_mark:\">&#x2705;</span></i>"},
+		{"multi code point emoji", ":flag-us:", "<span title=\":flag-us:\">&#x1F1FA;&#x1F1F8;</span>"},
+		{"adjacent emoji", ":+1::+1:",
+			"<span title=\":+1:\">&#x1F44D;</span><span title=\":+1:\">&#x1F44D;</span>"},
+
+		// Escaping
+		{"entities", "a &lt; b &amp;&amp; c &gt; d", "a &lt; b &amp;&amp; c &gt; d"},
+	} {
+		if actual := string(textToHtml(test.text, false, nil)); actual != test.expected {
+			t.Errorf("%s: textToHtml(%q):\n got %q\nwant %q", test.name, test.text, actual, test.expected)
+		}
+	}
+}
+
+func TestTextToHtmlTruncate(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"short", "a\nb", "a<br>b"},
+		{"lines", "1\n2\n3\n4\n5\n6\n7", "1<br>2<br>3<br>4<br>5<br>..."},
+	} {
+		if actual := string(textToHtml(test.text, true, nil)); actual != test.expected {
+			t.Errorf("%s: textToHtml(%q, true):\n got %q\nwant %q", test.name, test.text, actual, test.expected)
+		}
+	}
+}
+
+func TestTextToPlainTextFormatting(t *testing.T) {
+	initTestEmoji()
+	for _, test := range []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"formatting is kept", "*b* _i_ ~s~ `c`", "*b* _i_ ~s~ `c`"},
+		{"nested", "*bold _italic_*", "*bold _italic_*"},
+		{"snake case", "snake_case_name", "snake_case_name"},
+		{"link", "*see <https://example.com|the docs>*", "*see the docs (https://example.com)*"},
+		{"bare link", "<https://example.com>", "https://example.com"},
+		{"escaped link", "<https://example.com/?a=1&amp;b=2|A &amp; B>", "A & B (https://example.com/?a=1&b=2)"},
+		{"code block", "before\n```\nif a &lt; b {\n}\n```\nafter", "before\n```\nif a < b {\n}\n```\nafter"},
+		{"inline code block", "before ```code``` after", "before \n```\ncode\n```\n after"},
+		{"escaped code", "`&lt;tag&gt;`", "`<tag>`"},
+		{"quotes", "&gt;a\n&gt;\nb", "> a\n>\nb"},
+		{"quote everything", "&gt;&gt;&gt;a\nb", "> a\n> b"},
+		{"emoji", "nice :+1: :unknown:", "nice 👍 :unknown:"},
+		{"time is not emoji", "at 10:30:45", "at 10:30:45"},
+	} {
+		if actual := textToPlainText(test.text, nil); actual != test.expected {
+			t.Errorf("%s: textToPlainText(%q):\n got %q\nwant %q", test.name, test.text, actual, test.expected)
+		}
+	}
+}
+
+func TestParseMrkdwn(t *testing.T) {
+	actual := parseMrkdwn("*a <https://example.com|b>*\n&gt;`c` :d:")
+	expected := []*MrkdwnNode{
+		{Type: MrkdwnBold, Children: []*MrkdwnNode{
+			{Type: MrkdwnText, Text: "a "},
+			{Type: MrkdwnControl, Text: "https://example.com|b"},
+		}},
+		{Type: MrkdwnLineBreak},
+		{Type: MrkdwnQuote, Children: []*MrkdwnNode{
+			{Type: MrkdwnCode, Text: "c"},
+			{Type: MrkdwnText, Text: " "},
+			{Type: MrkdwnEmoji, Text: "d"},
+		}},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("parseMrkdwn: got %s, want %s", formatMrkdwnNodes(actual), formatMrkdwnNodes(expected))
+	}
+}
+
+func formatMrkdwnNodes(nodes []*MrkdwnNode) string {
+	result := "["
+	for i, node := range nodes {
+		if i != 0 {
+			result += ", "
+		}
+		result += fmt.Sprintf("%d", node.Type)
+		if node.Text != "" {
+			result += fmt.Sprintf("%q", node.Text)
+		}
+		if node.Children != nil {
+			result += formatMrkdwnNodes(node.Children)
+		}
+	}
+	return result + "]"
+}
+
+func TestTextToMarkdown(t *testing.T) {
+	initTestEmoji()
+	for _, test := range []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"plain", "just text", "just text"},
+		{"formatting", "*b* _i_ ~s~ `c`", "**b** *i* ~~s~~ `c`"},
+		{"nested", "*bold _italic_*", "**bold *italic***"},
+		{"escaped characters", "snake_case_name [1] 2*3 &lt;tag&gt; a &amp; b", "snake\\_case\\_name \\[1\\] 2\\*3 \\<tag> a & b"},
+		{"block markers", "# not a heading\n- not a list\n1. not a list\n---", "\\# not a heading\\\n\\- not a list\\\n1\\. not a list\\\n\\---"},
+		{"not block markers", "#hashtag\n+1\n1.0", "#hashtag\\\n+1\\\n1.0"},
+		{"link", "see <https://example.com/a_(b)|the docs>", "see [the docs](https://example.com/a_%28b%29)"},
+		{"bare link", "<https://example.com>", "<https://example.com>"},
+		{"mention command", "<!here> and <!channel>", "**@here** and **@channel**"},
+		{"code block", "before\n```\nif a &lt; b {\n}\n```\nafter", "before\n```\nif a < b {\n}\n```\nafter"},
+		{"line breaks", "a\nb\n\nc", "a\\\nb\n\nc"},
+		{"quotes", "&gt;a\n&gt;b\n&gt;\n&gt;c\nd", "> a\\\n> b\n>\n> c\n\nd"},
+		{"quote after text", "a\n&gt;b", "a\n> b"},
+		{"quoted block markers", "&gt;- item", "> \\- item"},
+		{"emoji", "nice :+1: :unknown:", "nice 👍 :unknown:"},
+	} {
+		if actual := textToMarkdown(test.text, nil); actual != test.expected {
+			t.Errorf("%s: textToMarkdown(%q):\n got %q\nwant %q", test.name, test.text, actual, test.expected)
+		}
+	}
+	for _, test := range []struct{ code, expected string }{
+		{"plain", "`plain`"},
+		{"a`b", "``a`b``"},
+		{"`a", "`` `a ``"},
+	} {
+		if actual := markdownCodeSpan(test.code); actual != test.expected {
+			t.Errorf("markdownCodeSpan(%q): got %q, want %q", test.code, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Plain text rendering of archives, used for the text/plain part of archive
+// emails so that text-only mail clients and search indexers get something
+// readable. It mirrors the structure of the HTML templates.
+
+const PlainTextIndent = "    "
+
+type plainTextWriter struct {
+	builder strings.Builder
+	indent  int
+}
+
+// Writes (possibly multi-line) text at the current indentation level.
+func (w *plainTextWriter) writeLines(text string) {
+	for _, line := range strings.Split(text, "\n") {
+		if line != "" {
+			w.builder.WriteString(strings.Repeat(PlainTextIndent, w.indent))
+			w.builder.WriteString(line)
+		}
+		w.builder.WriteString("\n")
+	}
+}
+
+func (w *plainTextWriter) writeBlankLine() {
+	w.builder.WriteString("\n")
+}
+
+func (w *plainTextWriter) String() string {
+	return w.builder.String()
+}
+
+func textToPlainText(text string, slackClient *slack.Client) string {
+	return renderMrkdwnPlainText(parseMrkdwn(text), slackClient)
+}
+
+func (archive *ConversationArchive) PlainText() string {
+	var w plainTextWriter
+	w.writeLines(fmt.Sprintf("%s Archive", archive.Conversation.Name()))
+	messageCountSuffix := "s"
+	if archive.MessageCount == 1 {
+		messageCountSuffix = ""
+	}
+	w.writeLines(fmt.Sprintf("%d message%s from %s",
+		archive.MessageCount, messageCountSuffix, archive.DisplayDate()))
+	if archive.MultiDay() {
+		for _, day := range archive.Days() {
+			w.writeBlankLine()
+			w.writeLines(fmt.Sprintf("== %s ==", day.DisplayDate()))
+			for _, messageGroup := range day.MessageGroups {
+				w.writeBlankLine()
+				messageGroup.writePlainText(&w)
+			}
+		}
+	} else {
+		for _, messageGroup := range archive.MessageGroups {
+			w.writeBlankLine()
+			messageGroup.writePlainText(&w)
+		}
+	}
+	return w.String()
+}
+
+func (digest *ArchiveDigest) PlainText() string {
+	var w plainTextWriter
+	w.writeLines("Slack Digest")
+	w.writeLines(fmt.Sprintf("%s in %s from %s",
+		pluralize(digest.MessageCount(), "message"),
+		pluralize(digest.ConversationCount(), "conversation"),
+		digest.DisplayDate()))
+	w.writeBlankLine()
+	w.indent++
+	for _, archive := range digest.ConversationArchives {
+		w.writeLines(fmt.Sprintf("%s (%s)",
+			archive.Conversation.Name(), pluralize(archive.MessageCount, "message")))
+	}
+	w.indent--
+	for _, archive := range digest.ConversationArchives {
+		w.writeBlankLine()
+		w.writeBlankLine()
+		w.builder.WriteString(archive.PlainText())
+	}
+	return w.String()
+}
+
+func pluralize(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
+
+func (mg *MessageGroup) writePlainText(w *plainTextWriter) {
+	header := fmt.Sprintf("%s, %s", mg.Author.Name, mg.DisplayTimestamp())
+	if mg.FromBot() {
+		header += " [BOT]"
+	}
+	w.writeLines(header)
+	w.indent++
+	for _, message := range mg.Messages {
+		message.writePlainText(w)
+	}
+	w.indent--
+}
+
+func (m *Message) writePlainText(w *plainTextWriter) {
+	if m.Text != "" {
+		w.writeLines(textToPlainText(m.Text, m.slackClient))
+	}
+	for _, attachment := range m.MessageAttachments() {
+		attachment.writePlainText(w)
+	}
+	for _, file := range m.MessageFiles() {
+		fileLine := fmt.Sprintf("[File: %s] %s", file.DisplayTitle(), file.URLPrivate)
+		if details := file.Details(); details != "" {
+			fileLine += fmt.Sprintf(" (%s)", details)
+		}
+		if attachmentName := file.AttachmentName(); attachmentName != "" {
+			fileLine += fmt.Sprintf(" [Attached as %s]", attachmentName)
+		}
+		w.writeLines(fileLine)
+	}
+	for _, reaction := range m.MessageReactions() {
+		summary, _ := reaction.Summary()
+		w.writeLines(fmt.Sprintf("[%s %d] %s",
+			getEmojiText(reaction.Name), reaction.Count, summary))
+	}
+	if m.HasReplies() {
+		replyLabel := "Replies"
+		if m.ReplyCount == 1 {
+			replyLabel = "Reply"
+		}
+		w.writeLines(fmt.Sprintf("%d %s:", m.ReplyCount, replyLabel))
+		w.indent++
+		for _, replyGroup := range m.ReplyMessageGroups {
+			replyGroup.writePlainText(w)
+		}
+		w.indent--
+	}
+}
+
+func (a *MessageAttachment) writePlainText(w *plainTextWriter) {
+	if a.Pretext != "" {
+		w.writeLines(textToPlainText(a.Pretext, a.slackClient))
+	}
+	w.indent++
+	defer func() { w.indent-- }()
+	if a.AuthorName != "" {
+		author := a.AuthorName
+		if a.AuthorSubname != "" {
+			author += " " + a.AuthorSubname
+		}
+		w.writeLines("| " + author)
+	}
+	if a.Title != "" {
+		title := textToPlainText(a.Title, a.slackClient)
+		if a.TitleLink != "" {
+			title = fmt.Sprintf("%s (%s)", title, a.TitleLink)
+		}
+		w.writeLines("| " + title)
+	}
+	if a.Text != "" {
+		w.writeLines(prefixLines(textToPlainText(a.Text, a.slackClient), "| "))
+	}
+	if a.ImageURL != "" {
+		w.writeLines("| " + a.ImageURL)
+	}
+	for _, field := range a.Fields {
+		w.writeLines(prefixLines(fmt.Sprintf("%s: %s",
+			textToPlainText(field.Title, a.slackClient),
+			textToPlainText(field.Value, a.slackClient)), "| "))
+	}
+}
+
+func prefixLines(text string, prefix string) string {
+	return prefix + strings.Replace(text, "\n", "\n"+prefix, -1)
+}
+
+// Plain text equivalent of the email-footer template.
+func emailFooterPlainText() string {
+	var w plainTextWriter
+	w.writeLines("--")
+	w.writeLines("You are receiving this email because you set up a Slack Archive account.")
+	if settingsUrl, err := AbsoluteRouteUrl("settings"); err == nil {
+		w.writeLines(fmt.Sprintf("Update your email preferences: %s", settingsUrl))
+	}
+	return w.String()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestTextToPlainText(t *testing.T) {
+	emojiByShortName = map[string]*Emoji{
+		"+1":       {UnicodeCodePointHex: "1F44D"},
+		"flag-us":  {UnicodeCodePointHex: "1F1FA-1F1F8"},
+		"bad-data": {UnicodeCodePointHex: "XYZ"},
+	}
+	for _, test := range []struct{ text, expected string }{
+		{"plain", "plain"},
+		{"a &lt;b&gt; &amp; c", "a <b> & c"},
+		{"see <https://example.com/a_b|the docs>", "see the docs (https://example.com/a_b)"},
+		{"<https://example.com>", "https://example.com"},
+		{"<mailto:a@example.com|a@example.com>", "a@example.com"},
+		{"<!here> and <!channel>", "@here and @channel"},
+		{"nice :+1: :flag-us: :custom: :bad-data:", "nice 👍 🇺🇸 :custom: :bad-data:"},
+		{"&gt;quoted\nnot quoted", "> quoted\nnot quoted"},
+		{">>>multi", "> multi"},
+		{"*bold* _italic_", "*bold* _italic_"},
+	} {
+		if actual := textToPlainText(test.text, nil); actual != test.expected {
+			t.Errorf("textToPlainText(%q): got %q, want %q", test.text, actual, test.expected)
+		}
+	}
+}
+
+func TestConversationArchivePlainText(t *testing.T) {
+	emojiByShortName = map[string]*Emoji{"tada": {UnicodeCodePointHex: "1F389"}}
+	account := &Account{TimezoneLocation: time.UTC}
+
+	reply := newTestMessage(account, slack.Msg{Timestamp: "1772668800", Text: "congrats"})
+	parent := newTestMessage(account, slack.Msg{
+		Timestamp:       "1772665200",
+		ThreadTimestamp: "1772665200",
+		ReplyCount:      1,
+		Text:            "We shipped!\n&gt;finally",
+		Attachments: []slack.Attachment{{
+			Title:     "Release notes",
+			TitleLink: "https://example.com/notes",
+			Text:      "Line 1\nLine 2",
+			Fields:    []slack.AttachmentField{{Title: "Version", Value: "1.0"}},
+		}},
+		Files:     []slack.File{{Title: "screenshot.png", URLPrivate: "https://files.example.com/1"}},
+		Reactions: []slack.ItemReaction{{Name: "tada", Count: 1, Users: []string{"U2"}}},
+	})
+	parent.ReplyMessageGroups = []*MessageGroup{{Messages: []*Message{reply}, Author: testBob}}
+
+	archive := &ConversationArchive{
+		Conversation: &ChannelConversation{&slack.Channel{GroupConversation: slack.GroupConversation{Name: "general"}}},
+		MessageGroups: []*MessageGroup{
+			{Messages: []*Message{parent}, Author: testAlice},
+		},
+		MessageCount: 2,
+		StartTime:    time.Date(2026, time.March, 4, 0, 0, 0, 0, time.UTC),
+		EndTime:      time.Date(2026, time.March, 4, 23, 59, 59, 0, time.UTC),
+	}
+	expected := "#general Archive\n" +
+		"2 messages from " + archive.DisplayDate() + "\n" +
+		"\n" +
+		"alice, " + archive.MessageGroups[0].DisplayTimestamp() + "\n" +
+		"    We shipped!\n" +
+		"    > finally\n" +
+		"        | Release notes (https://example.com/notes)\n" +
+		"        | Line 1\n" +
+		"        | Line 2\n" +
+		"        | Version: 1.0\n" +
+		"    [File: screenshot.png] https://files.example.com/1\n" +
+		"    [🎉 1] bob reacted with :tada:\n" +
+		"    1 Reply:\n" +
+		"        bob, " + parent.ReplyMessageGroups[0].DisplayTimestamp() + "\n" +
+		"            congrats\n"
+	if actual := archive.PlainText(); actual != expected {
+		t.Errorf("Plain text:\n%s\nwant:\n%s", actual, expected)
+	}
+}
@@ -0,0 +1,369 @@
+//go:build !standalone
+
+package main
+
+import (
+	"context"
+	"net/http"
+	netmail "net/mail"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/mail"
+	"google.golang.org/appengine/memcache"
+	"google.golang.org/appengine/urlfetch"
+	"google.golang.org/appengine/user"
+)
+
+// App Engine implementations of the platform hooks and backends. The
+// standalone build (with the "standalone" build tag) provides equivalents in
+// platform_standalone.go.
+
+func initPlatform() {
+	accountStore = &DatastoreAccountStore{}
+	archiveLog = &DatastoreArchiveLogStore{}
+	messageStore = &DatastoreMessageStore{}
+	mailer = &AppEngineMailer{}
+	cache = &MemcacheCache{}
+}
+
+// There are no command-line tools on App Engine.
+func runCommandLine() bool {
+	return false
+}
+
+func runServer() {
+	appengine.Main()
+}
+
+func newContext(r *http.Request) context.Context {
+	return appengine.NewContext(r)
+}
+
+func isDevServer() bool {
+	return appengine.IsDevAppServer()
+}
+
+func baseUrl() string {
+	if appengine.IsDevAppServer() {
+		return "http://localhost:8080"
+	}
+	return "https://slack-archive.appspot.com"
+}
+
+// URL Fetch requests are limited to 5 seconds unless their context has a
+// deadline, which is too short for some Slack API calls.
+const UrlFetchTimeout = time.Second * 60
+
+func newTransport(c context.Context) http.RoundTripper {
+	return &urlfetchTransport{c}
+}
+
+// Gives each request its own deadline, since transports (e.g. in Slack
+// clients) may be used for longer than a single request's timeout.
+type urlfetchTransport struct {
+	Context context.Context
+}
+
+func (t *urlfetchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c, cancel := context.WithTimeout(t.Context, UrlFetchTimeout)
+	defer cancel()
+	// Responses are read in full by the fetch, so their bodies don't need
+	// the context.
+	return (&urlfetch.Transport{Context: c}).RoundTrip(req)
+}
+
+func isAdminRequest(r *http.Request) bool {
+	return user.IsAdmin(appengine.NewContext(r))
+}
+
+func isTimeoutError(err error) bool {
+	return appengine.IsTimeoutError(err)
+}
+
+func logInfof(c context.Context, format string, args ...interface{}) {
+	log.Infof(c, format, args...)
+}
+
+func logWarningf(c context.Context, format string, args ...interface{}) {
+	log.Warningf(c, format, args...)
+}
+
+func logErrorf(c context.Context, format string, args ...interface{}) {
+	log.Errorf(c, format, args...)
+}
+
+// delay.Func keys functions by the file that it's called from, so it's called
+// directly from where tasks are defined (and not from a wrapper here). That
+// keeps the keys the same as before the standalone build was added, so tasks
+// that are queued during a deploy can still be run.
+var newTaskFunc = delay.Func
+
+type DatastoreAccountStore struct{}
+
+func (s *DatastoreAccountStore) Get(c context.Context, slackUserId string) (*Account, error) {
+	key := datastore.NewKey(c, "Account", slackUserId, 0, nil)
+	account := new(Account)
+	err := datastore.Get(c, key, account)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrNoSuchAccount
+	}
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *DatastoreAccountStore) GetAll(c context.Context) ([]Account, error) {
+	q := datastore.NewQuery("Account")
+	var accounts []Account
+	_, err := q.GetAll(c, &accounts)
+	return accounts, err
+}
+
+func (s *DatastoreAccountStore) Put(c context.Context, account *Account) error {
+	key := datastore.NewKey(c, "Account", account.SlackUserId, 0, nil)
+	_, err := datastore.Put(c, key, account)
+	return err
+}
+
+func (s *DatastoreAccountStore) Update(c context.Context, slackUserId string, update func(account *Account) error) error {
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		key := datastore.NewKey(tc, "Account", slackUserId, 0, nil)
+		account := new(Account)
+		err := datastore.Get(tc, key, account)
+		if err == datastore.ErrNoSuchEntity {
+			return ErrNoSuchAccount
+		}
+		if err != nil {
+			return err
+		}
+		if err := update(account); err != nil {
+			return err
+		}
+		_, err = datastore.Put(tc, key, account)
+		return err
+	}, nil)
+}
+
+func (s *DatastoreAccountStore) Delete(c context.Context, slackUserId string) error {
+	key := datastore.NewKey(c, "Account", slackUserId, 0, nil)
+	return datastore.Delete(c, key)
+}
+
+type DatastoreArchiveLogStore struct{}
+
+func (s *DatastoreArchiveLogStore) Get(c context.Context, slackUserId string, conversationId string, date string) (*ArchiveLogEntry, error) {
+	key := datastore.NewKey(c, "ArchiveLogEntry", archiveLogKey(slackUserId, conversationId, date), 0, nil)
+	entry := new(ArchiveLogEntry)
+	err := datastore.Get(c, key, entry)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrNoSuchArchiveLogEntry
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *DatastoreArchiveLogStore) Put(c context.Context, entry *ArchiveLogEntry) error {
+	key := datastore.NewKey(c, "ArchiveLogEntry", entry.Key(), 0, nil)
+	_, err := datastore.Put(c, key, entry)
+	return err
+}
+
+// Needs the composite index in index.yaml.
+func (s *DatastoreArchiveLogStore) GetPrevious(c context.Context, slackUserId string, conversationId string, date string) (*ArchiveLogEntry, error) {
+	q := datastore.NewQuery("ArchiveLogEntry").
+		Filter("SlackUserId =", slackUserId).
+		Filter("ConversationId =", conversationId).
+		Filter("Date <", date).
+		Order("-Date").
+		Limit(1)
+	var entries []*ArchiveLogEntry
+	if _, err := q.GetAll(c, &entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoSuchArchiveLogEntry
+	}
+	return entries[0], nil
+}
+
+func (s *DatastoreArchiveLogStore) DeleteAll(c context.Context, slackUserId string) error {
+	return deleteAllDatastoreEntities(c, "ArchiveLogEntry", slackUserId)
+}
+
+// Batch operations are limited to 500 entities.
+const DatastoreBatchSize = 500
+
+// deleteAllDatastoreEntities removes the entities of kind that belong to an
+// account (they must have an indexed SlackUserId property).
+func deleteAllDatastoreEntities(c context.Context, kind string, slackUserId string) error {
+	q := datastore.NewQuery(kind).Filter("SlackUserId =", slackUserId).KeysOnly()
+	keys, err := q.GetAll(c, nil)
+	if err != nil {
+		return err
+	}
+	for len(keys) > 0 {
+		batchSize := len(keys)
+		if batchSize > DatastoreBatchSize {
+			batchSize = DatastoreBatchSize
+		}
+		if err := datastore.DeleteMulti(c, keys[:batchSize]); err != nil {
+			return err
+		}
+		keys = keys[batchSize:]
+	}
+	return nil
+}
+
+// Searches use equality filters on SlackUserId, Terms and ConversationId and
+// are ordered by Time, which Datastore satisfies by merging the composite
+// indexes in index.yaml. Authors are matched in memory, this bounds how many
+// messages are checked.
+const DatastoreMessageSearchMaxCandidates = 5000
+
+type DatastoreMessageStore struct{}
+
+func (s *DatastoreMessageStore) Put(c context.Context, messages []*StoredMessage) error {
+	for len(messages) > 0 {
+		batchSize := len(messages)
+		if batchSize > DatastoreBatchSize {
+			batchSize = DatastoreBatchSize
+		}
+		keys := make([]*datastore.Key, batchSize)
+		for i, message := range messages[:batchSize] {
+			keys[i] = datastore.NewKey(c, "StoredMessage", message.Key(), 0, nil)
+		}
+		if _, err := datastore.PutMulti(c, keys, messages[:batchSize]); err != nil {
+			return err
+		}
+		messages = messages[batchSize:]
+	}
+	return nil
+}
+
+func (s *DatastoreMessageStore) Search(c context.Context, slackUserId string, query *MessageSearchQuery, limit int) ([]*StoredMessage, error) {
+	q := datastore.NewQuery("StoredMessage").Filter("SlackUserId =", slackUserId)
+	for _, term := range searchTerms(query.Text) {
+		q = q.Filter("Terms =", term)
+	}
+	if query.ConversationId != "" {
+		q = q.Filter("ConversationId =", query.ConversationId)
+	}
+	if !query.StartTime.IsZero() {
+		q = q.Filter("Time >=", query.StartTime)
+	}
+	if !query.EndTime.IsZero() {
+		q = q.Filter("Time <", query.EndTime)
+	}
+	messages := make([]*StoredMessage, 0)
+	it := q.Order("-Time").Limit(DatastoreMessageSearchMaxCandidates).Run(c)
+	for len(messages) < limit {
+		message := new(StoredMessage)
+		_, err := it.Next(message)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if query.Matches(message) {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+func (s *DatastoreMessageStore) DeleteAll(c context.Context, slackUserId string) error {
+	return deleteAllDatastoreEntities(c, "StoredMessage", slackUserId)
+}
+
+// The mail API does not allow the Message-ID to be set (it always generates
+// its own), so In-Reply-To and References would point at messages that don't
+// exist. They're dropped, leaving archives to be grouped by their List-Id.
+type AppEngineMailer struct{}
+
+var appEngineMailerDroppedHeaders = []string{"In-Reply-To", "References"}
+
+// The mail API only accepts attachments with these extensions, and derives
+// their content type from it (MailAttachment.ContentType can't be passed on).
+var appEngineMailerAttachmentExtensions = map[string]bool{
+	"aac": true, "abw": true, "aif": true, "aifc": true, "aiff": true, "arj": true, "asc": true,
+	"au": true, "avi": true, "bmp": true, "css": true, "csv": true, "diff": true, "doc": true,
+	"docx": true, "flac": true, "gif": true, "gz": true, "htm": true, "html": true, "ics": true,
+	"jpe": true, "jpeg": true, "jpg": true, "kml": true, "kmz": true, "m4a": true, "mid": true,
+	"mov": true, "mp3": true, "mp4": true, "mpeg": true, "mpg": true, "odp": true, "ods": true,
+	"odt": true, "oga": true, "ogg": true, "ogv": true, "pdf": true, "png": true, "pot": true,
+	"pps": true, "ppt": true, "pptx": true, "qt": true, "rmi": true, "rtf": true, "sgm": true,
+	"sgml": true, "snd": true, "svg": true, "tar": true, "tif": true, "tiff": true, "tsv": true,
+	"txt": true, "vcf": true, "vsd": true, "wav": true, "webm": true, "webp": true, "xls": true,
+	"xlsx": true, "zip": true,
+}
+
+func (m *AppEngineMailer) GeneratesMessageIds() bool {
+	return true
+}
+
+func (m *AppEngineMailer) AllowsAttachment(name string) bool {
+	extension := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	return appEngineMailerAttachmentExtensions[extension]
+}
+
+func (m *AppEngineMailer) Send(c context.Context, message *MailMessage) error {
+	attachments := make([]mail.Attachment, 0, len(message.Attachments))
+	for _, attachment := range message.Attachments {
+		contentId := ""
+		if attachment.ContentId != "" {
+			contentId = "<" + attachment.ContentId + ">"
+		}
+		attachments = append(attachments, mail.Attachment{
+			Name:      attachment.Name,
+			Data:      attachment.Data,
+			ContentID: contentId,
+		})
+	}
+	headers := make(netmail.Header, len(message.Headers))
+	for name, values := range message.Headers {
+		headers[name] = values
+	}
+	for _, name := range appEngineMailerDroppedHeaders {
+		delete(headers, name)
+	}
+	return mail.Send(c, &mail.Message{
+		Sender:      message.Sender,
+		To:          message.To,
+		Subject:     message.Subject,
+		Body:        message.Body,
+		HTMLBody:    message.HTMLBody,
+		Headers:     headers,
+		Attachments: attachments,
+	})
+}
+
+type MemcacheCache struct{}
+
+func (cache *MemcacheCache) Get(c context.Context, key string) ([]byte, error) {
+	item, err := memcache.Get(c, key)
+	if err == memcache.ErrCacheMiss {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+func (cache *MemcacheCache) Set(c context.Context, key string, value []byte, expiration time.Duration) error {
+	return memcache.Set(c, &memcache.Item{
+		Key:        key,
+		Value:      value,
+		Expiration: expiration,
+	})
+}
@@ -0,0 +1,249 @@
+//go:build standalone
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	log_ "log"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Standalone implementations of the platform hooks and backends, for running
+// as a plain net/http server outside of App Engine. Built with
+// "go build -tags standalone".
+
+const (
+	TaskQueueInterval       = time.Second * 2
+	TaskQueueRetryLimit     = 5
+	TaskQueueMinBackoff     = time.Minute * 5
+	ArchiveCronMinuteOfHour = 5
+)
+
+type ServerConfig struct {
+	ListenAddress string
+	BaseUrl       string
+	Dev           bool
+	// Path to the bbolt database file that accounts (and the archive log and
+	// searchable messages) are stored in. If empty, they're only kept in
+	// memory.
+	DatabasePath string
+	// Token that must be passed (as a bearer token in the Authorization header)
+	// to access /admin/ routes. If empty, they're disabled.
+	AdminToken string
+}
+
+var serverConfig ServerConfig
+var baseTransport http.RoundTripper = http.DefaultTransport
+var localTasks = make(chan *localTask, 1000)
+
+func initPlatform() {
+	serverConfig = loadServerConfig()
+	if serverConfig.DatabasePath != "" {
+		db, err := openBoltDatabase(serverConfig.DatabasePath)
+		if err != nil {
+			log_.Panicf("Could not open database %s: %s", serverConfig.DatabasePath, err.Error())
+		}
+		accountStore, err = newBoltAccountStore(db)
+		if err != nil {
+			log_.Panicf("Could not initialize account store: %s", err.Error())
+		}
+		archiveLog, err = newBoltArchiveLogStore(db)
+		if err != nil {
+			log_.Panicf("Could not initialize archive log: %s", err.Error())
+		}
+		messageStore, err = newBoltMessageStore(db)
+		if err != nil {
+			log_.Panicf("Could not initialize message store: %s", err.Error())
+		}
+	} else {
+		log_.Printf("No DatabasePath configured, accounts will only be kept in memory")
+		accountStore = newMemoryAccountStore()
+		archiveLog = newMemoryArchiveLogStore()
+		messageStore = newMemoryMessageStore()
+	}
+	mailer = &LogMailer{}
+	cache = newMemoryCache()
+}
+
+func loadServerConfig() (config ServerConfig) {
+	configBytes, err := ioutil.ReadFile("config/server.json")
+	if err != nil {
+		log_.Panicf("Could not read server config: %s", err.Error())
+	}
+	err = json.Unmarshal(configBytes, &config)
+	if err != nil {
+		log_.Panicf("Could not parse server config %s: %s", configBytes, err.Error())
+	}
+	if config.ListenAddress == "" {
+		config.ListenAddress = ":8080"
+	}
+	if config.BaseUrl == "" {
+		config.BaseUrl = "http://localhost:8080"
+	}
+	config.BaseUrl = strings.TrimSuffix(config.BaseUrl, "/")
+	return
+}
+
+// runCommandLine runs the command that the arguments name (see commands.go),
+// returning false if there is none and the server should be run instead.
+func runCommandLine() bool {
+	ran, err := runCommand(os.Args[1:])
+	if err != nil {
+		log_.Fatal(err)
+	}
+	return ran
+}
+
+func runServer() {
+	// Handled by app.yaml when running on App Engine.
+	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+
+	go runLocalTasks()
+	go runArchiveCronLoop()
+
+	log_.Printf("Listening on %s (%s)", serverConfig.ListenAddress, serverConfig.BaseUrl)
+	log_.Fatal(http.ListenAndServe(serverConfig.ListenAddress, nil))
+}
+
+// Equivalent of cron.yaml, runs the archive cron a few minutes after every
+// hour.
+func runArchiveCronLoop() {
+	for {
+		now := time.Now()
+		next := now.Truncate(time.Hour).Add(time.Minute * ArchiveCronMinuteOfHour)
+		if !next.After(now) {
+			next = next.Add(time.Hour)
+		}
+		time.Sleep(next.Sub(now))
+		c := context.Background()
+		if err := runArchiveCron(c); err != nil {
+			logErrorf(c, "Error running archive cron: %s", err.Error())
+		}
+	}
+}
+
+func newContext(r *http.Request) context.Context {
+	return r.Context()
+}
+
+func isDevServer() bool {
+	return serverConfig.Dev
+}
+
+func baseUrl() string {
+	return serverConfig.BaseUrl
+}
+
+func newTransport(c context.Context) http.RoundTripper {
+	return baseTransport
+}
+
+func isAdminRequest(r *http.Request) bool {
+	if serverConfig.AdminToken == "" {
+		return false
+	}
+	authorization := []byte(r.Header.Get("Authorization"))
+	expected := []byte("Bearer " + serverConfig.AdminToken)
+	return subtle.ConstantTimeCompare(authorization, expected) == 1
+}
+
+func isTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func logInfof(c context.Context, format string, args ...interface{}) {
+	log_.Printf("INFO: "+format, args...)
+}
+
+func logWarningf(c context.Context, format string, args ...interface{}) {
+	log_.Printf("WARNING: "+format, args...)
+}
+
+func logErrorf(c context.Context, format string, args ...interface{}) {
+	log_.Printf("ERROR: "+format, args...)
+}
+
+// In-process replacement for delay.Func. Tasks are run one at a time (like the
+// default queue in queue.yaml) and are retried with a backoff if they return
+// an error. Pending tasks are lost if the process exits.
+type LocalTaskFunc struct {
+	name string
+	fn   reflect.Value
+}
+
+type localTask struct {
+	f       *LocalTaskFunc
+	args    []reflect.Value
+	attempt int
+}
+
+func newTaskFunc(name string, fn interface{}) *LocalTaskFunc {
+	fnValue := reflect.ValueOf(fn)
+	fnType := fnValue.Type()
+	if fnType.Kind() != reflect.Func || fnType.NumIn() == 0 ||
+		fnType.In(0) != reflect.TypeOf((*context.Context)(nil)).Elem() {
+		log_.Panicf("Task function %s must take a context.Context as its first argument", name)
+	}
+	return &LocalTaskFunc{name: name, fn: fnValue}
+}
+
+func (f *LocalTaskFunc) Call(c context.Context, args ...interface{}) error {
+	fnType := f.fn.Type()
+	if len(args) != fnType.NumIn()-1 {
+		return fmt.Errorf("Task %s takes %d arguments, got %d", f.name, fnType.NumIn()-1, len(args))
+	}
+	argValues := make([]reflect.Value, 0, len(args)+1)
+	argValues = append(argValues, reflect.Value{})
+	for i, arg := range args {
+		argValue := reflect.ValueOf(arg)
+		if !argValue.IsValid() {
+			argValue = reflect.Zero(fnType.In(i + 1))
+		}
+		if !argValue.Type().AssignableTo(fnType.In(i + 1)) {
+			return fmt.Errorf("Task %s argument %d has type %s, expected %s",
+				f.name, i, argValue.Type(), fnType.In(i+1))
+		}
+		argValues = append(argValues, argValue)
+	}
+	select {
+	case localTasks <- &localTask{f: f, args: argValues}:
+		return nil
+	default:
+		return fmt.Errorf("Task queue is full, could not enqueue %s", f.name)
+	}
+}
+
+func runLocalTasks() {
+	for task := range localTasks {
+		c := context.Background()
+		task.args[0] = reflect.ValueOf(c)
+		var err error
+		results := task.f.fn.Call(task.args)
+		if len(results) > 0 {
+			err, _ = results[len(results)-1].Interface().(error)
+		}
+		if err != nil && task.attempt < TaskQueueRetryLimit {
+			task.attempt++
+			backoff := TaskQueueMinBackoff * time.Duration(task.attempt)
+			logWarningf(c, "Task %s failed (attempt %d), retrying in %s: %s",
+				task.f.name, task.attempt, backoff, err.Error())
+			retryTask := task
+			time.AfterFunc(backoff, func() { localTasks <- retryTask })
+		}
+		time.Sleep(TaskQueueInterval)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build standalone
+
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsAdminRequest(t *testing.T) {
+	defer func(config ServerConfig) { serverConfig = config }(serverConfig)
+	for _, test := range []struct {
+		adminToken    string
+		authorization string
+		expected      bool
+	}{
+		{"", "", false},
+		{"", "Bearer ", false},
+		{"secret", "", false},
+		{"secret", "Bearer wrong", false},
+		{"secret", "Bearer secret", true},
+	} {
+		serverConfig.AdminToken = test.adminToken
+		r := httptest.NewRequest("POST", "/admin/backfill", nil)
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		if actual := isAdminRequest(r); actual != test.expected {
+			t.Errorf("isAdminRequest with token %q and %q: got %v", test.adminToken, test.authorization, actual)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Static HTML site with an account's conversations, which can be browsed
+// offline: an index of conversations, an index of days for each one, and a
+// page per day rendered with the same conversation-archive template as
+// emails. Images (thumbnails, avatars, custom emoji, etc.) are downloaded into
+// the site, so that it works without access to Slack.
+
+const (
+	SiteAssetsDirectory = "assets"
+	// Images that are larger than this keep pointing to the original.
+	SiteAssetMaxBytes = 10 * 1024 * 1024
+)
+
+// Images are only downloaded from Slack and Gravatar (which Slack uses for
+// default avatars), since the URLs come from message content and the
+// requests are made from the server. Others keep pointing to the original.
+var siteAssetHosts = []string{
+	"slack.com",
+	"slack-edge.com",
+	"slack-files.com",
+	"slack-imgs.com",
+	"gravatar.com",
+}
+
+// Images in rendered pages (templates use double quotes, but the HTML
+// generated for custom emoji and DM names uses single ones).
+var siteImageSrcPattern = regexp.MustCompile(`src="(https?://[^"]+)"|src='(https?://[^']+)'`)
+
+type SiteConversation struct {
+	Conversation Conversation
+	// Directory that the conversation's pages are in.
+	Path string
+	Days []*SiteDay
+}
+
+func (sc *SiteConversation) MessageCount() int {
+	count := 0
+	for _, day := range sc.Days {
+		count += day.Archive.MessageCount
+	}
+	return count
+}
+
+func (sc *SiteConversation) DisplayDate() string {
+	return archiveDisplayDate(sc.Days[0].Archive.StartTime, sc.Days[len(sc.Days)-1].Archive.StartTime)
+}
+
+type SiteDay struct {
+	Archive *ConversationArchive
+	// Relative to the conversation's directory.
+	Path string
+}
+
+// newSiteConversation splits the archive up into a page per day.
+func newSiteConversation(archive *ConversationArchive, path string) *SiteConversation {
+	sc := &SiteConversation{Conversation: archive.Conversation, Path: path}
+	for _, dayArchive := range archive.dayArchives() {
+		sc.Days = append(sc.Days, &SiteDay{
+			Archive: dayArchive,
+			Path:    dayArchive.StartTime.Format(ArchiveDayFormat) + ".html",
+		})
+	}
+	return sc
+}
+
+type siteExporter struct {
+	writer     ExportWriter
+	account    *Account
+	exportTime time.Time
+	c          context.Context
+	// Paths (relative to the root of the site) of images that were saved,
+	// keyed by their URL. Empty for ones that couldn't be fetched.
+	assetPaths map[string]string
+	usedPaths  map[string]bool
+}
+
+// exportSite writes the site for the account's (included) conversations from
+// startDay through endDay (inclusive). Conversations without messages in that
+// range are left out. Returns the number of conversations that were exported.
+func exportSite(writer ExportWriter, account *Account, startDay time.Time, endDay time.Time, c context.Context) (int, error) {
+	slackClient := account.NewSlackClient(c)
+	team, err := slackClient.GetTeamInfo()
+	if err != nil {
+		return 0, err
+	}
+	conversations, err := getConversations(slackClient, account)
+	if err != nil {
+		return 0, err
+	}
+	e := &siteExporter{
+		writer:     writer,
+		account:    account,
+		exportTime: time.Now(),
+		c:          c,
+		assetPaths: make(map[string]string),
+		usedPaths:  make(map[string]bool),
+	}
+	siteConversations := make([]*SiteConversation, 0)
+	for _, conversation := range includedConversations(conversations.AllConversations, account) {
+		archive, err := newConversationArchiveForDays(conversation, slackClient, account, startDay, endDay)
+		if err != nil {
+			return 0, err
+		}
+		storeArchiveMessages(c, account, archive)
+		if archive.Empty() {
+			continue
+		}
+		sc := newSiteConversation(archive, e.conversationPath(conversation))
+		if err := e.writeConversation(sc); err != nil {
+			return 0, err
+		}
+		siteConversations = append(siteConversations, sc)
+	}
+	var data = map[string]interface{}{
+		"Title":         fmt.Sprintf("%s Slack Archive", team.Name),
+		"DisplayDate":   archiveDisplayDate(startDay, endDay),
+		"Conversations": siteConversations,
+	}
+	return len(siteConversations), e.writePage("index.html", "site-index", data)
+}
+
+func (e *siteExporter) conversationPath(conversation Conversation) string {
+	return uniqueConversationFileName(conversation, e.usedPaths)
+}
+
+func (e *siteExporter) writeConversation(sc *SiteConversation) error {
+	for i, day := range sc.Days {
+		if err := e.saveThumbnails(day.Archive); err != nil {
+			return err
+		}
+		var data = map[string]interface{}{
+			"Title":            fmt.Sprintf("%s Archive: %s", sc.Conversation.Name(), day.Archive.DisplayDate()),
+			"SiteConversation": sc,
+			"SiteDay":          day,
+		}
+		if i > 0 {
+			data["PreviousDay"] = sc.Days[i-1]
+		}
+		if i < len(sc.Days)-1 {
+			data["NextDay"] = sc.Days[i+1]
+		}
+		if err := e.writePage(sc.Path+"/"+day.Path, "site-day", data); err != nil {
+			return err
+		}
+	}
+	var data = map[string]interface{}{
+		"Title":            fmt.Sprintf("%s Archive", sc.Conversation.Name()),
+		"SiteConversation": sc,
+	}
+	return e.writePage(sc.Path+"/index.html", "site-conversation", data)
+}
+
+// saveThumbnails downloads the thumbnails of the archive's files, which
+// (unlike other images) need the account's token.
+func (e *siteExporter) saveThumbnails(archive *ConversationArchive) error {
+	emailFiles := newArchiveEmailFiles(archive)
+	authorization := fmt.Sprintf("Bearer %s", e.account.ApiToken)
+	for _, message := range emailFiles.messages {
+		for _, file := range message.MessageFiles() {
+			if file.Thumb360 == "" {
+				continue
+			}
+			path, err := e.saveAsset(thumbnailSourceUrl(file.File), authorization)
+			if err != nil {
+				return err
+			}
+			if path != "" {
+				// Day pages are in the conversation's directory.
+				emailFiles.thumbnailPaths[file.ID] = "../" + path
+			}
+		}
+	}
+	return nil
+}
+
+// saveAsset downloads an image into the site (once per URL), returning its
+// path relative to the root of the site. Images that can't be fetched are
+// logged and an empty path is returned, only failing to write them is an
+// error.
+func (e *siteExporter) saveAsset(url string, authorization string) (string, error) {
+	if path, ok := e.assetPaths[url]; ok {
+		return path, nil
+	}
+	e.assetPaths[url] = ""
+	data, contentType, err := fetchUrl(e.c, url, authorization, SiteAssetMaxBytes, siteAssetHosts)
+	if err != nil {
+		logWarningf(e.c, "Could not save %s: %s", url, err.Error())
+		return "", nil
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		logWarningf(e.c, "Could not save %s: unexpected type %s", url, contentType)
+		return "", nil
+	}
+	hash := sha1.Sum([]byte(url))
+	path := fmt.Sprintf("%s/%s%s", SiteAssetsDirectory, hex.EncodeToString(hash[:8]), thumbnailExtension(contentType))
+	if err := e.writer.WriteFile(path, data); err != nil {
+		return "", err
+	}
+	e.assetPaths[url] = path
+	return path, nil
+}
+
+func (e *siteExporter) writePage(path string, templateName string, data map[string]interface{}) error {
+	data["ExportDate"] = archiveDisplayDate(e.exportTime, e.exportTime)
+	var page bytes.Buffer
+	if err := templates["site-export"].ExecuteTemplate(&page, templateName, data); err != nil {
+		return err
+	}
+	pageHtml, err := e.localizeImages(page.String(), strings.Repeat("../", strings.Count(path, "/")))
+	if err != nil {
+		return err
+	}
+	return e.writer.WriteFile(path, []byte(pageHtml))
+}
+
+// localizeImages replaces the URLs of images in the page with the paths of
+// the saved copies, with rootPrefix leading from the page to the root of the
+// site.
+func (e *siteExporter) localizeImages(pageHtml string, rootPrefix string) (string, error) {
+	var writeErr error
+	pageHtml = siteImageSrcPattern.ReplaceAllStringFunc(pageHtml, func(attribute string) string {
+		match := siteImageSrcPattern.FindStringSubmatch(attribute)
+		quote, escapedUrl := `"`, match[1]
+		if escapedUrl == "" {
+			quote, escapedUrl = `'`, match[2]
+		}
+		path, err := e.saveAsset(html.UnescapeString(escapedUrl), "")
+		if err != nil {
+			writeErr = err
+		}
+		if path == "" {
+			return attribute
+		}
+		return "src=" + quote + rootPrefix + path + quote
+	})
+	return pageHtml, writeErr
+}
@@ -0,0 +1,130 @@
+//go:build standalone
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Only run in the standalone build, since images are fetched (and failures
+// logged) via the platform hooks.
+func TestSiteExporter(t *testing.T) {
+	account := initTestApp(t)
+	templates = loadTemplates()
+	previousHosts := siteAssetHosts
+	t.Cleanup(func() { siteAssetHosts = previousHosts })
+	siteAssetHosts = []string{"127.0.0.1"}
+	var serverUrl string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect.png":
+			// Redirects have to stay on allowed hosts too.
+			http.Redirect(w, r, strings.Replace(serverUrl, "127.0.0.1", "localhost", 1)+"/avatar.png", http.StatusFound)
+		case "/avatar.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("avatar data"))
+		case "/thumb.jpg":
+			if r.Header.Get("Authorization") != "Bearer "+account.ApiToken {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("thumbnail data"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	serverUrl = server.URL
+	// Not on an allowed host, so it's not fetched.
+	internalUrl := strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/avatar.png"
+
+	alice := *testAlice
+	alice.Profile.Image72 = server.URL + "/avatar.png?size=72&v=1"
+	first := newTestMessage(account, slack.Msg{Timestamp: "1772640000", Text: "First day"})
+	first.Files = []slack.File{{ID: "F1", Mimetype: "image/jpeg", Thumb360: server.URL + "/thumb.jpg"}}
+	first.Attachments = []slack.Attachment{
+		{ImageURL: server.URL + "/missing.png"},
+		{ImageURL: internalUrl},
+		{ImageURL: server.URL + "/redirect.png"},
+	}
+	second := newTestMessage(account, slack.Msg{Timestamp: "1772726400", Text: "Second day"})
+	archive := &ConversationArchive{
+		Conversation: newTestChannelConversation("C1", "general"),
+		MessageGroups: []*MessageGroup{
+			{Messages: []*Message{first}, Author: &alice},
+			{Messages: []*Message{second}, Author: &alice},
+		},
+		MessageCount: 2,
+	}
+
+	writer := make(testExportWriter)
+	e := &siteExporter{
+		writer:     writer,
+		account:    account,
+		exportTime: time.Now(),
+		c:          context.Background(),
+		assetPaths: make(map[string]string),
+		usedPaths:  make(map[string]bool),
+	}
+	sc := newSiteConversation(archive, e.conversationPath(archive.Conversation))
+	if len(sc.Days) != 2 || sc.Days[0].Path != "2026-03-04.html" || sc.Days[1].Path != "2026-03-05.html" {
+		t.Fatalf("Unexpected days: %+v", sc.Days)
+	}
+	if err := e.writeConversation(sc); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.writePage("index.html", "site-index", map[string]interface{}{
+		"Title":         "Team Slack Archive",
+		"DisplayDate":   "March 2026",
+		"Conversations": []*SiteConversation{sc},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	avatarPath := e.assetPaths[alice.Profile.Image72]
+	thumbnailPath := e.assetPaths[server.URL+"/thumb.jpg"]
+	if string(writer[avatarPath]) != "avatar data" || !strings.HasSuffix(avatarPath, ".png") {
+		t.Errorf("Avatar not saved: %q", avatarPath)
+	}
+	if string(writer[thumbnailPath]) != "thumbnail data" || !strings.HasSuffix(thumbnailPath, ".jpg") {
+		t.Errorf("Thumbnail not saved: %q", thumbnailPath)
+	}
+
+	firstDay := string(writer["general/2026-03-04.html"])
+	for _, expected := range []string{
+		"First day",
+		`src="../` + avatarPath + `"`,
+		`src="../` + thumbnailPath + `"`,
+		// Images that can't be fetched keep their original URL.
+		`src="` + server.URL + `/missing.png"`,
+		`src="` + internalUrl + `"`,
+		`src="` + server.URL + `/redirect.png"`,
+		`href="2026-03-05.html"`,
+		`href="../index.html"`,
+	} {
+		if !strings.Contains(firstDay, expected) {
+			t.Errorf("Expected %q in the first day's page: %s", expected, firstDay)
+		}
+	}
+	conversationIndex := string(writer["general/index.html"])
+	for _, expected := range []string{`href="2026-03-04.html"`, `href="2026-03-05.html"`, "2 messages"} {
+		if !strings.Contains(conversationIndex, expected) {
+			t.Errorf("Expected %q in the conversation index: %s", expected, conversationIndex)
+		}
+	}
+	if index := string(writer["index.html"]); !strings.Contains(index, `href="general/index.html"`) {
+		t.Errorf("Expected a link to the conversation in the index: %s", index)
+	}
+
+	if path := e.conversationPath(newTestChannelConversation("C2", "general")); path != "general-C2" {
+		t.Errorf("Conversations with the same name should get different paths, got %q", path)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Export in the same layout as Slack's workspace exports, so that archives can
+// be imported back into Slack or into other tools that understand it (e.g.
+// Mattermost's and Discord's importers). channels.json, groups.json, mpims.json
+// and dms.json list the conversations, users.json has the workspace's users,
+// and each conversation has a directory (named after it, or its ID for DMs)
+// with a JSON file per day of its messages, as returned by the Slack API
+// (thread replies are in the file for the day that they were posted).
+
+// Entry in channels.json, groups.json (private channels) or mpims.json.
+type SlackExportChannel struct {
+	Id         string         `json:"id"`
+	Name       string         `json:"name"`
+	Created    slack.JSONTime `json:"created"`
+	Creator    string         `json:"creator"`
+	IsArchived bool           `json:"is_archived"`
+	IsGeneral  bool           `json:"is_general"`
+	Members    []string       `json:"members"`
+	Topic      slack.Topic    `json:"topic"`
+	Purpose    slack.Purpose  `json:"purpose"`
+}
+
+// Entry in dms.json.
+type SlackExportDirectMessage struct {
+	Id      string         `json:"id"`
+	Created slack.JSONTime `json:"created"`
+	Members []string       `json:"members"`
+}
+
+func newSlackExportChannel(channel *slack.Channel, slackClient *slack.Client) (SlackExportChannel, error) {
+	members, err := getConversationMembers(channel.ID, slackClient)
+	if err != nil {
+		return SlackExportChannel{}, err
+	}
+	return SlackExportChannel{
+		Id:         channel.ID,
+		Name:       channel.Name,
+		Created:    channel.Created,
+		Creator:    channel.Creator,
+		IsArchived: channel.IsArchived,
+		IsGeneral:  channel.IsGeneral,
+		Members:    members,
+		Topic:      channel.Topic,
+		Purpose:    channel.Purpose,
+	}, nil
+}
+
+// exportSlack writes the export for the account's (included) conversations,
+// with messages from startDay through endDay (inclusive). All conversations
+// are listed, even if they have no messages in that range. Returns the number
+// of messages that were exported.
+func exportSlack(writer ExportWriter, account *Account, startDay time.Time, endDay time.Time, c context.Context) (int, error) {
+	slackClient := account.NewSlackClient(c)
+	conversations, err := getConversations(slackClient, account)
+	if err != nil {
+		return 0, err
+	}
+	startTime := startDay
+	endTime := endDay.AddDate(0, 0, 1).Add(-time.Second)
+	channels := make([]SlackExportChannel, 0)
+	groups := make([]SlackExportChannel, 0)
+	mpims := make([]SlackExportChannel, 0)
+	dms := make([]SlackExportDirectMessage, 0)
+	messageCount := 0
+	for _, conversation := range includedConversations(conversations.AllConversations, account) {
+		var directory string
+		var channel *slack.Channel
+		// List that the channel goes in, all but DMs have the same format.
+		var list *[]SlackExportChannel
+		switch conversation := conversation.(type) {
+		case *ChannelConversation:
+			channel, list = conversation.channel, &channels
+		case *PrivateChannelConversation:
+			channel, list = conversation.channel, &groups
+		case *MultiPartyDirectMessageConversation:
+			channel, list = conversation.mpim, &mpims
+		case *DirectMessageConversation:
+			dms = append(dms, SlackExportDirectMessage{
+				Id:      conversation.im.ID,
+				Created: conversation.im.Created,
+				Members: []string{account.SlackUserId, conversation.im.User},
+			})
+			directory = conversation.im.ID
+		}
+		if channel != nil {
+			exportChannel, err := newSlackExportChannel(channel, slackClient)
+			if err != nil {
+				return messageCount, err
+			}
+			*list = append(*list, exportChannel)
+			directory = channel.Name
+		}
+		messages, err := getConversationMessages(conversation, slackClient, startTime, endTime)
+		if err != nil {
+			return messageCount, err
+		}
+		for date, dayMessages := range slackExportDays(messages, account.TimezoneLocation) {
+			if err := writeExportJson(writer, directory+"/"+date+".json", dayMessages); err != nil {
+				return messageCount, err
+			}
+		}
+		messageCount += len(messages)
+	}
+
+	users := make([]*slack.User, 0, len(conversations.UserLookup.usersById))
+	for _, user := range conversations.UserLookup.usersById {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	for path, data := range map[string]interface{}{
+		"channels.json": channels,
+		"groups.json":   groups,
+		"mpims.json":    mpims,
+		"dms.json":      dms,
+		"users.json":    users,
+	} {
+		if err := writeExportJson(writer, path, data); err != nil {
+			return messageCount, err
+		}
+	}
+	return messageCount, nil
+}
+
+// getConversationMessages returns the conversation's messages between
+// startTime and endTime, including thread replies, oldest first.
+func getConversationMessages(conversation Conversation, slackClient *slack.Client, startTime time.Time, endTime time.Time) ([]*slack.Message, error) {
+	history, err := getConversationHistory(conversation, slackClient, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*slack.Message, 0, len(history))
+	for _, message := range history {
+		messages = append(messages, message)
+		if (&Message{Message: message}).HasReplies() {
+			replies, err := getThreadReplies(conversation, slackClient, message.ThreadTimestamp, startTime, endTime)
+			if err != nil {
+				log.Printf("Could not get replies for %s, continuing: %s", message.Timestamp, err)
+				continue
+			}
+			messages = append(messages, replies...)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return slackTimestampTime(messages[i].Timestamp).Before(slackTimestampTime(messages[j].Timestamp))
+	})
+	return messages, nil
+}
+
+// slackExportDays splits up (sorted) messages by the day that they were posted
+// on in location, keyed by the day in ArchiveDayFormat.
+func slackExportDays(messages []*slack.Message, location *time.Location) map[string][]*slack.Message {
+	days := make(map[string][]*slack.Message)
+	for _, message := range messages {
+		date := slackTimestampTime(message.Timestamp).In(location).Format(ArchiveDayFormat)
+		days[date] = append(days[date], message)
+	}
+	return days
+}
+
+// slackTimestampTime parses a Slack message timestamp (seconds since the epoch,
+// with a fractional part to make it unique).
+func slackTimestampTime(timestamp string) time.Time {
+	floatTimestamp, err := strconv.ParseFloat(timestamp, 64)
+	if err != nil {
+		log.Printf("Could not parse timestamp \"%s\": %s.\n", timestamp, err)
+		return time.Time{}
+	}
+	seconds := int64(floatTimestamp)
+	return time.Unix(seconds, int64((floatTimestamp-float64(seconds))*1e9))
+}
+
+func writeExportJson(writer ExportWriter, path string, data interface{}) error {
+	dataJson, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writer.WriteFile(path, dataJson)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestSlackExportDays(t *testing.T) {
+	location, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newMessage := func(timestamp string) *slack.Message {
+		return &slack.Message{Msg: slack.Msg{Timestamp: timestamp}}
+	}
+	// 2026-03-04 23:00 and 2026-03-05 01:00 in Los Angeles.
+	late := newMessage("1772694000.000100")
+	early := newMessage("1772701200.000200")
+	days := slackExportDays([]*slack.Message{late, early}, location)
+	if len(days) != 2 || len(days["2026-03-04"]) != 1 || days["2026-03-04"][0] != late ||
+		len(days["2026-03-05"]) != 1 || days["2026-03-05"][0] != early {
+		t.Errorf("Unexpected days: %v", days)
+	}
+
+	if actual := slackTimestampTime("1772694000.5"); !actual.Equal(time.Unix(1772694000, 5e8)) {
+		t.Errorf("Unexpected time: %s", actual)
+	}
+	if actual := slackTimestampTime("not a timestamp"); !actual.IsZero() {
+		t.Errorf("Expected a zero time, got %s", actual)
+	}
+}
+
+func TestWriteExportJson(t *testing.T) {
+	writer := make(testExportWriter)
+	message := &slack.Message{Msg: slack.Msg{
+		Type:            "message",
+		User:            "U1",
+		Text:            "Reply",
+		Timestamp:       "1772694000.000200",
+		ThreadTimestamp: "1772694000.000100",
+	}}
+	if err := writeExportJson(writer, "general/2026-03-04.json", []*slack.Message{message}); err != nil {
+		t.Fatal(err)
+	}
+	var messages []map[string]interface{}
+	if err := json.Unmarshal(writer["general/2026-03-04.json"], &messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0]["ts"] != "1772694000.000200" ||
+		messages[0]["thread_ts"] != "1772694000.000100" || messages[0]["user"] != "U1" {
+		t.Errorf("Unexpected messages: %v", messages)
+	}
+
+	channel := SlackExportChannel{Id: "C1", Name: "general", Created: 1772694000, Members: []string{"U1"}}
+	if err := writeExportJson(writer, "channels.json", []SlackExportChannel{channel}); err != nil {
+		t.Fatal(err)
+	}
+	var channels []map[string]interface{}
+	if err := json.Unmarshal(writer["channels.json"], &channels); err != nil {
+		t.Fatal(err)
+	}
+	if len(channels) != 1 || channels[0]["id"] != "C1" || channels[0]["name"] != "general" ||
+		channels[0]["created"] != float64(1772694000) {
+		t.Errorf("Unexpected channels: %v", channels)
+	}
+}
@@ -1,3 +1,52 @@
 function confirmDeleteAccount() {
   return confirm("Are you sure you want to delete your account?");
 }
+
+function updateImapSettings() {
+  var imapMode = document.querySelector(
+      "input[name=delivery_mode][value=imap]").checked;
+  document.getElementById("imap-settings").style.display =
+      imapMode ? "" : "none";
+}
+
+function updateFileAttachmentSettings() {
+  var attachFiles = document.querySelector(
+      "input[name=attach_files][value=true]").checked;
+  document.getElementById("file-attachment-settings").style.display =
+      attachFiles ? "" : "none";
+}
+
+// Listing conversations is slow, so the backfill picker only fetches them
+// once it's used.
+function initBackfillConversations() {
+  var select = document.getElementById("backfill-conversations");
+  if (!select) {
+    return;
+  }
+  select.addEventListener("focus", function() {
+    var loading = select.querySelector("option.loading");
+    fetch(select.dataset.url, {credentials: "same-origin"})
+        .then(function(response) {
+          if (!response.ok) {
+            throw new Error(response.statusText);
+          }
+          return response.json();
+        })
+        .then(function(conversations) {
+          conversations.forEach(function(conversation) {
+            var option = document.createElement("option");
+            option.value = conversation.Value;
+            option.textContent = conversation.Name;
+            select.appendChild(option);
+          });
+          select.removeChild(loading);
+        })
+        .catch(function() {
+          loading.textContent = "Could not load conversations";
+        });
+  }, {once: true});
+}
+
+document.addEventListener("DOMContentLoaded", updateImapSettings);
+document.addEventListener("DOMContentLoaded", updateFileAttachmentSettings);
+document.addEventListener("DOMContentLoaded", initBackfillConversations);
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"context"
+)
+
+// Deferred function invocation, backed by the App Engine task queue (via the
+// delay package) or by an in-process queue when running standalone. Must be
+// created at init time via newTaskFunc, and args must be serializable. Task
+// keys include the name of the file that calls newTaskFunc, so moving a task
+// to a different file breaks tasks that are already queued. Changing a task's
+// arguments needs a new name, with the old one kept as a shim that converts
+// them, since queued tasks are decoded with the current function's signature.
+type TaskFunc interface {
+	Call(c context.Context, args ...interface{}) error
+}
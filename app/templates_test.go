@@ -0,0 +1,153 @@
+//go:build standalone
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Golden-file tests for the templates that render archives, so that template
+// and styles.json changes show up as diffs. The archives are built from the
+// fake Slack workspace in testdata/fake-slack/golden.json (so only in the
+// standalone build), with each of its conversations covering a different kind
+// of message. After an intended change, regenerate the golden files with:
+//
+//	go test -tags standalone -run TestArchiveTemplates -update
+
+var updateGolden = flag.Bool("update", false, "Update the golden files in testdata/golden")
+
+// Thumbnail URLs have encrypted file refs, with a random IV.
+var goldenThumbnailRefPattern = regexp.MustCompile(`/archive/file-thumbnail/([A-Za-z0-9_=-]+)`)
+
+// The conversations in the golden workspace.
+var goldenConversationRefs = []string{"C1", "C2", "C3", "C4"}
+
+func TestArchiveTemplates(t *testing.T) {
+	account := initTestApp(t)
+	previousStyles, previousEmoji := styles, emojiByShortName
+	t.Cleanup(func() {
+		styles, emojiByShortName = previousStyles, previousEmoji
+	})
+	templates = loadTemplates()
+	styles = loadStyles()
+	emojiByShortName = loadEmoji()
+	fileUrlRefEncryptionKey = []byte("0123456789abcdef")
+	newFakeSlack(t, "golden.json")
+
+	slackClient := account.NewSlackClient(context.Background())
+	day := time.Date(2026, time.March, 4, 0, 0, 0, 0, account.TimezoneLocation)
+	for _, ref := range goldenConversationRefs {
+		conversation, err := getConversationFromRef("channel", ref, slackClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// newConversationArchive covers the previous day, so the day that it
+		// delegates to is used instead, to get the fixtures' day.
+		archive, err := newConversationArchiveForDay(conversation, slackClient, account, day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := strings.TrimPrefix(conversation.Name(), "#")
+		t.Run(name, func(t *testing.T) {
+			checkGoldenTemplate(t, name, "conversation-archive-email", map[string]interface{}{
+				"ConversationArchive": archive,
+			})
+			// Same data as conversationArchiveHandler, with fixed dates.
+			checkGoldenTemplate(t, name, "conversation-archive-page", map[string]interface{}{
+				"Conversation":        conversation,
+				"ConversationType":    "channel",
+				"ConversationRef":     ref,
+				"ConversationArchive": archive,
+				"CadenceOverride":     "",
+				"DefaultCadence":      account.CadenceDisplayName(),
+				"TimezoneLocation":    account.TimezoneLocation,
+				"ExportMaxDate":       day.AddDate(0, 0, 1).Format(ArchiveDayFormat),
+				"ExportMaxDays":       WebExportMaxDays,
+			})
+		})
+	}
+
+	digest, err := newArchiveDigest(slackClient, account, day.AddDate(0, 0, 1), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest.ConversationCount() != len(goldenConversationRefs) {
+		t.Errorf("Expected all conversations in the digest, got %d", digest.ConversationCount())
+	}
+	checkGoldenTemplate(t, "all", "digest-email", map[string]interface{}{"ArchiveDigest": digest})
+}
+
+// checkGoldenTemplate renders the template and compares it to the
+// testdata/golden/<name>-<template>.html golden file (or replaces the file,
+// with -update).
+func checkGoldenTemplate(t *testing.T, name string, templateName string, data map[string]interface{}) {
+	var rendered bytes.Buffer
+	if err := templates[templateName].Execute(&rendered, data); err != nil {
+		t.Fatalf("Could not render %s: %s", templateName, err)
+	}
+	actual, err := normalizeGoldenHtml(rendered.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join("testdata", "golden", fmt.Sprintf("%s-%s.html", name, templateName))
+	if *updateGolden {
+		if err := ioutil.WriteFile(path, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Could not read golden file (run with -update to create it): %s", err)
+	}
+	if actual != string(expected) {
+		t.Errorf("%s does not match %s (run with -update if the change is intended):\n%s",
+			templateName, path, goldenDiff(string(expected), actual))
+	}
+}
+
+// normalizeGoldenHtml replaces the parts of the output that differ between
+// runs: encrypted thumbnail refs are decrypted.
+func normalizeGoldenHtml(html string) (string, error) {
+	var decodeErr error
+	html = goldenThumbnailRefPattern.ReplaceAllStringFunc(html, func(url string) string {
+		encodedRef := goldenThumbnailRefPattern.FindStringSubmatch(url)[1]
+		ref, err := DecodeFileUrlRef(encodedRef)
+		if err != nil {
+			decodeErr = err
+			return url
+		}
+		return fmt.Sprintf("/archive/file-thumbnail/%s-%s", ref.SlackUserId, ref.FileId)
+	})
+	return html, decodeErr
+}
+
+// goldenDiff describes the first line where the output differs, which is
+// enough to find the change (the full diff can be seen by updating the golden
+// file and using git diff).
+func goldenDiff(expected string, actual string) string {
+	expectedLines := strings.Split(expected, "\n")
+	actualLines := strings.Split(actual, "\n")
+	for i := 0; i < len(expectedLines) || i < len(actualLines); i++ {
+		var expectedLine, actualLine string
+		if i < len(expectedLines) {
+			expectedLine = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			actualLine = actualLines[i]
+		}
+		if expectedLine != actualLine {
+			return fmt.Sprintf("line %d:\n-%s\n+%s", i+1, expectedLine, actualLine)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+const ArchiveMessageIdDateFormat = "20060102"
+
+// Each day's (or week's, etc.) archive of a conversation is a separate
+// message. To have mail clients group them into a single thread, Message-IDs
+// are derived from the team, conversation and first day (so that they can be
+// recomputed later), and each archive replies to the previous one that was
+// sent (previousMessageId, from the archive log). Archives without messages
+// are not sent, so all archives also reference a shared (never sent) root
+// message, which the first archive replies to and is enough for clients that
+// thread based on References.
+func archiveThreadHeaders(teamId string, archive *ConversationArchive, previousMessageId string, sender string) (string, mail.Header) {
+	domain := emailAddressDomain(sender)
+	conversationId := archive.Conversation.Id()
+	messageId := archiveMessageId(teamId, conversationId, archive.StartTime, domain)
+	rootMessageId := fmt.Sprintf("<%s.%s@%s>", conversationId, teamId, domain)
+	headers := mail.Header{
+		"List-Id": {fmt.Sprintf("%s <%s>",
+			archiveListName(archive.Conversation), archiveListId(teamId, conversationId, domain))},
+	}
+	setReplyHeaders(headers, rootMessageId, previousMessageId)
+	return messageId, headers
+}
+
+// Combined digests are threaded in the same way, with a single thread per
+// account (previousMessageId is the last digest that was sent).
+func digestThreadHeaders(teamId string, account *Account, day time.Time, previousMessageId string, sender string) (string, mail.Header) {
+	domain := emailAddressDomain(sender)
+	digestId := "digest." + account.SlackUserId
+	messageId := archiveMessageId(teamId, digestId, day, domain)
+	rootMessageId := fmt.Sprintf("<%s.%s@%s>", digestId, teamId, domain)
+	headers := mail.Header{
+		"List-Id": {fmt.Sprintf("\"Slack Digest\" <%s>", archiveListId(teamId, digestId, domain))},
+	}
+	setReplyHeaders(headers, rootMessageId, previousMessageId)
+	return messageId, headers
+}
+
+// Threads that are exported on their own (see exportConversationMbox) get
+// Message-IDs derived from their timestamp instead, and only reference the
+// conversation's root message (threads don't have a previous one).
+func threadArchiveThreadHeaders(teamId string, archive *ConversationArchive, threadTimestamp string, sender string) (string, mail.Header) {
+	domain := emailAddressDomain(sender)
+	conversationId := archive.Conversation.Id()
+	messageId := fmt.Sprintf("<%s.%s.%s@%s>", threadTimestamp, conversationId, teamId, domain)
+	rootMessageId := fmt.Sprintf("<%s.%s@%s>", conversationId, teamId, domain)
+	headers := mail.Header{
+		"In-Reply-To": {rootMessageId},
+		"References":  {rootMessageId},
+		"List-Id": {fmt.Sprintf("%s <%s>",
+			archiveListName(archive.Conversation), archiveListId(teamId, conversationId, domain))},
+	}
+	return messageId, headers
+}
+
+// setReplyHeaders makes the message a reply to the previous one, or to the
+// root if there's no previous one.
+func setReplyHeaders(headers mail.Header, rootMessageId string, previousMessageId string) {
+	if previousMessageId == "" {
+		headers["In-Reply-To"] = []string{rootMessageId}
+		headers["References"] = []string{rootMessageId}
+		return
+	}
+	headers["In-Reply-To"] = []string{previousMessageId}
+	headers["References"] = []string{rootMessageId + " " + previousMessageId}
+}
+
+// markArchiveResent gives an archive that's sent again (when forced) a new
+// Message-ID, since mail servers drop messages whose Message-ID is already in
+// the mailbox. It's derived from the original and the time that it was resent,
+// and the original is added to References to keep them in the same thread.
+func markArchiveResent(message *MailMessage, resendTime time.Time) {
+	originalMessageId := message.MessageId
+	message.MessageId = strings.Replace(
+		originalMessageId, "@", fmt.Sprintf(".%d@", resendTime.Unix()), 1)
+	message.Headers["References"] = []string{
+		message.Headers.Get("References") + " " + originalMessageId}
+}
+
+func archiveMessageId(teamId string, conversationId string, date time.Time, domain string) string {
+	return fmt.Sprintf("<%s.%s.%s@%s>",
+		date.Format(ArchiveMessageIdDateFormat), conversationId, teamId, domain)
+}
+
+// List-Id (RFC 2919) identifiers must be dot-atoms, and are case-insensitive.
+func archiveListId(teamId string, conversationId string, domain string) string {
+	return strings.ToLower(fmt.Sprintf("%s.%s.%s", conversationId, teamId, domain))
+}
+
+// The List-Id description is a quoted string, so it's simplest to only keep
+// printable ASCII characters (names may contain emoji, e.g. the 🔒 for private
+// channels).
+func archiveListName(conversation Conversation) string {
+	var name strings.Builder
+	for _, r := range conversation.Name() {
+		if r >= 0x20 && r < 0x7f && r != '"' && r != '\\' {
+			name.WriteRune(r)
+		}
+	}
+	return fmt.Sprintf("\"%s Archive\"", strings.TrimSpace(name.String()))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestArchiveThreadHeaders(t *testing.T) {
+	channel := &slack.Channel{}
+	channel.ID = "C123"
+	channel.Name = "general"
+	conversation := &ChannelConversation{channel}
+	sender := "Team Slack Archive <archive@Example.com>"
+	location := time.FixedZone("PST", -8*60*60)
+
+	day := func(year int, month time.Month, day int) *ConversationArchive {
+		startTime := time.Date(year, month, day, 0, 0, 0, 0, location)
+		return &ConversationArchive{
+			Conversation: conversation,
+			StartTime:    startTime,
+			EndTime:      startTime.AddDate(0, 0, 1).Add(-time.Second),
+		}
+	}
+
+	messageId, headers := archiveThreadHeaders("T456", day(2026, time.March, 1), "", sender)
+	if messageId != "<20260301.C123.T456@example.com>" {
+		t.Errorf("Message-ID: got %q", messageId)
+	}
+	// Without a previous archive, it replies to the root.
+	if inReplyTo := headers.Get("In-Reply-To"); inReplyTo != "<C123.T456@example.com>" {
+		t.Errorf("In-Reply-To: got %q", inReplyTo)
+	}
+	if references := headers.Get("References"); references != "<C123.T456@example.com>" {
+		t.Errorf("References: got %q", references)
+	}
+	if listId := headers.Get("List-Id"); listId != "\"#general Archive\" <c123.t456.example.com>" {
+		t.Errorf("List-Id: got %q", listId)
+	}
+
+	// Later archives reply to the previous one that was sent.
+	_, nextHeaders := archiveThreadHeaders("T456", day(2026, time.March, 4), messageId, sender)
+	if inReplyTo := nextHeaders.Get("In-Reply-To"); inReplyTo != messageId {
+		t.Errorf("Next In-Reply-To: got %q, want %q", inReplyTo, messageId)
+	}
+	if references := nextHeaders.Get("References"); references != "<C123.T456@example.com> "+messageId {
+		t.Errorf("Next References: got %q", references)
+	}
+	if nextHeaders.Get("List-Id") != headers.Get("List-Id") {
+		t.Errorf("List-Id changed: %q vs %q", nextHeaders.Get("List-Id"), headers.Get("List-Id"))
+	}
+
+	// Recomputing gives the same Message-ID.
+	if sameMessageId, _ := archiveThreadHeaders("T456", day(2026, time.March, 1), "", sender); sameMessageId != messageId {
+		t.Errorf("Message-ID is not stable: got %q and %q", messageId, sameMessageId)
+	}
+}
+
+func TestDigestThreadHeaders(t *testing.T) {
+	account := &Account{SlackUserId: "U1", Cadence: CadenceWeekly}
+	sender := "Team Slack Archive <archive@example.com>"
+	day := time.Date(2026, time.March, 9, 0, 0, 0, 0, time.FixedZone("PST", -8*60*60))
+
+	// The previous digest isn't necessarily from the previous period (e.g.
+	// if the cadence changed, or it was empty).
+	previousMessageId := "<20260227.digest.U1.T456@example.com>"
+	messageId, headers := digestThreadHeaders("T456", account, day, previousMessageId, sender)
+	if messageId != "<20260309.digest.U1.T456@example.com>" {
+		t.Errorf("Message-ID: got %q", messageId)
+	}
+	if inReplyTo := headers.Get("In-Reply-To"); inReplyTo != previousMessageId {
+		t.Errorf("In-Reply-To: got %q", inReplyTo)
+	}
+	if references := headers.Get("References"); references != "<digest.U1.T456@example.com> "+previousMessageId {
+		t.Errorf("References: got %q", references)
+	}
+	if listId := headers.Get("List-Id"); listId != "\"Slack Digest\" <digest.u1.t456.example.com>" {
+		t.Errorf("List-Id: got %q", listId)
+	}
+}
+
+func TestThreadArchiveThreadHeaders(t *testing.T) {
+	archive := &ConversationArchive{Conversation: newTestChannelConversation("C123", "general")}
+	messageId, headers := threadArchiveThreadHeaders(
+		"T456", archive, "1772665200.000100", "Team Slack Archive <archive@example.com>")
+	if messageId != "<1772665200.000100.C123.T456@example.com>" {
+		t.Errorf("Message-ID: got %q", messageId)
+	}
+	if references := headers.Get("References"); references != "<C123.T456@example.com>" {
+		t.Errorf("References: got %q", references)
+	}
+	if listId := headers.Get("List-Id"); listId != "\"#general Archive\" <c123.t456.example.com>" {
+		t.Errorf("List-Id: got %q", listId)
+	}
+}
+
+func TestMarkArchiveResent(t *testing.T) {
+	archive := &ConversationArchive{
+		Conversation: newTestChannelConversation("C123", "general"),
+		StartTime:    time.Date(2026, time.March, 4, 0, 0, 0, 0, time.UTC),
+	}
+	previousMessageId := "<20260303.C123.T456@example.com>"
+	messageId, headers := archiveThreadHeaders(
+		"T456", archive, previousMessageId, "Team Slack Archive <archive@example.com>")
+	message := &MailMessage{MessageId: messageId, Headers: headers}
+	markArchiveResent(message, time.Unix(1772668800, 0))
+	if message.MessageId != "<20260304.C123.T456.1772668800@example.com>" {
+		t.Errorf("Message-ID: got %q", message.MessageId)
+	}
+	if inReplyTo := message.Headers.Get("In-Reply-To"); inReplyTo != previousMessageId {
+		t.Errorf("In-Reply-To: got %q", inReplyTo)
+	}
+	if references := message.Headers.Get("References"); references != "<C123.T456@example.com> "+previousMessageId+" "+messageId {
+		t.Errorf("References: got %q", references)
+	}
+}